@@ -0,0 +1,129 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backfill
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+)
+
+// errBufferFull is returned by Append for samples which do not fit in the buffer.
+var errBufferFull = errors.New("backfill buffer is full")
+
+// Buffer is a collectors.SampleSink keeping the samples until they are drained in the OpenMetrics format, ie to be
+// backfilled with `promtool tsdb create-blocks-from openmetrics`.
+type Buffer struct {
+	logger log.Logger
+	limit  int
+
+	mtx     sync.Mutex
+	samples []collectors.Sample
+	// drainMtx serializes the draining requests, so that the samples served by one are not removed by another.
+	drainMtx sync.Mutex
+
+	droppedTotal prometheus.Counter
+	bufferedDesc *prometheus.Desc
+}
+
+// NewBuffer returns a Buffer holding at most limit samples, 0 means unlimited. Samples which do not fit in the buffer
+// are rejected.
+func NewBuffer(logger log.Logger, limit int) *Buffer {
+	return &Buffer{
+		logger: logger,
+		limit:  limit,
+		droppedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "stackdriver",
+			Subsystem: "backfill",
+			Name:      "dropped_samples_total",
+			Help:      "Total number of samples rejected because the backfill buffer was full.",
+		}),
+		bufferedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName("stackdriver", "backfill", "buffered_samples"),
+			"Number of samples waiting to be served by the backfill endpoint.",
+			nil, nil,
+		),
+	}
+}
+
+// Append buffers the samples, unless they do not all fit in the buffer. Rejected samples are appended again by the
+// next collection, as long as they are in its requested interval.
+func (b *Buffer) Append(samples []collectors.Sample) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.limit > 0 && len(b.samples)+len(samples) > b.limit {
+		b.droppedTotal.Add(float64(len(samples)))
+		level.Warn(b.logger).Log("msg", "Backfill buffer is full, rejecting samples", "limit", b.limit, "rejected", len(samples))
+		return errBufferFull
+	}
+	b.samples = append(b.samples, samples...)
+	return nil
+}
+
+// ServeHTTP serves the buffered samples in the OpenMetrics format. GET requests leave them in the buffer, while POST
+// requests drain it: the served samples are removed once they were written successfully, so that every sample is only
+// drained once and none is lost to a failed request.
+func (b *Buffer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	drain := false
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		drain = true
+		b.drainMtx.Lock()
+		defer b.drainMtx.Unlock()
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b.mtx.Lock()
+	samples := b.samples
+	b.mtx.Unlock()
+
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	if err := WriteOpenMetrics(w, samples); err != nil {
+		level.Error(b.logger).Log("msg", "Error writing backfill samples", "samples", len(samples), "err", err)
+		return
+	}
+	if !drain {
+		return
+	}
+
+	// Samples appended meanwhile follow the served ones
+	b.mtx.Lock()
+	b.samples = append([]collectors.Sample(nil), b.samples[len(samples):]...)
+	b.mtx.Unlock()
+}
+
+func (b *Buffer) Describe(ch chan<- *prometheus.Desc) {
+	b.droppedTotal.Describe(ch)
+	ch <- b.bufferedDesc
+}
+
+func (b *Buffer) Collect(ch chan<- prometheus.Metric) {
+	b.mtx.Lock()
+	buffered := len(b.samples)
+	b.mtx.Unlock()
+
+	b.droppedTotal.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(b.bufferedDesc, prometheus.GaugeValue, float64(buffered))
+}
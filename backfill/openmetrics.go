@@ -0,0 +1,196 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backfill
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+)
+
+// WriteOpenMetrics writes samples in the OpenMetrics text format. Unlike the Prometheus exposition, a series may have
+// several samples, which are written in ascending timestamp order. Samples of a series sharing a timestamp are only
+// written once.
+//
+// As OpenMetrics requires the samples of counters to end with `_total`, the suffix is appended to the names of counters
+// which do not end with it already. Counters and histograms carry a `_created` sample when their start time is known.
+// @see https://github.com/OpenObservability/OpenMetrics/blob/main/specification/OpenMetrics.md
+func WriteOpenMetrics(w io.Writer, samples []collectors.Sample) error {
+	type series struct {
+		labels  string
+		samples []collectors.Sample
+	}
+	type family struct {
+		help   string
+		series map[string]*series
+	}
+
+	families := map[string]*family{}
+	for _, s := range samples {
+		f, ok := families[s.FqName]
+		if !ok {
+			f = &family{help: s.Help, series: map[string]*series{}}
+			families[s.FqName] = f
+		}
+		labels := formatLabels(s.LabelKeys, s.LabelValues)
+		ser, ok := f.series[labels]
+		if !ok {
+			ser = &series{labels: labels}
+			f.series[labels] = ser
+		}
+		ser.samples = append(ser.samples, s)
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, fqName := range names {
+		f := families[fqName]
+		labels := make([]string, 0, len(f.series))
+		for l := range f.series {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+
+		metricType, name := "", fqName
+		for _, l := range labels {
+			ser := f.series[l]
+			sort.SliceStable(ser.samples, func(i, j int) bool {
+				return ser.samples[i].Timestamp.Before(ser.samples[j].Timestamp)
+			})
+			if metricType == "" {
+				metricType = openMetricsType(ser.samples[0])
+				if metricType == "counter" {
+					name = strings.TrimSuffix(fqName, "_total")
+				}
+				fmt.Fprintf(bw, "# TYPE %s %s\n", name, metricType)
+				if f.help != "" {
+					fmt.Fprintf(bw, "# HELP %s %s\n", name, escape(f.help))
+				}
+			}
+
+			var previous time.Time
+			for i, s := range ser.samples {
+				if i > 0 && !s.Timestamp.After(previous) {
+					continue
+				}
+				previous = s.Timestamp
+				writeSample(bw, name, ser.labels, s)
+			}
+		}
+	}
+	fmt.Fprint(bw, "# EOF\n")
+	return bw.Flush()
+}
+
+func openMetricsType(s collectors.Sample) string {
+	switch {
+	case s.Histogram != nil:
+		return "histogram"
+	case s.ValueType == prometheus.CounterValue:
+		return "counter"
+	case s.ValueType == prometheus.GaugeValue:
+		return "gauge"
+	default:
+		return "unknown"
+	}
+}
+
+func writeSample(w io.Writer, name, labels string, s collectors.Sample) {
+	timestamp := formatTimestamp(s.Timestamp)
+	if s.Histogram == nil {
+		if s.ValueType != prometheus.CounterValue {
+			fmt.Fprintf(w, "%s%s %s %s\n", name, braces(labels), formatFloat(s.Value), timestamp)
+			return
+		}
+		fmt.Fprintf(w, "%s_total%s %s %s\n", name, braces(labels), formatFloat(s.Value), timestamp)
+		writeCreated(w, name, labels, s, timestamp)
+		return
+	}
+
+	bounds := make([]float64, 0, len(s.Histogram.Buckets))
+	for bound := range s.Histogram.Buckets {
+		bounds = append(bounds, bound)
+	}
+	sort.Float64s(bounds)
+	for _, bound := range bounds {
+		le := fmt.Sprintf(`le="%s"`, formatFloat(bound))
+		if labels != "" {
+			le = labels + "," + le
+		}
+		fmt.Fprintf(w, "%s_bucket{%s} %d %s\n", name, le, s.Histogram.Buckets[bound], timestamp)
+	}
+	fmt.Fprintf(w, "%s_count%s %d %s\n", name, braces(labels), s.Histogram.Count, timestamp)
+	fmt.Fprintf(w, "%s_sum%s %s %s\n", name, braces(labels), formatFloat(s.Histogram.Sum), timestamp)
+	writeCreated(w, name, labels, s, timestamp)
+}
+
+// writeCreated writes the `_created` sample of a counter or histogram whose start time is known.
+func writeCreated(w io.Writer, name, labels string, s collectors.Sample, timestamp string) {
+	if !s.CreatedTimestamp.IsZero() {
+		fmt.Fprintf(w, "%s_created%s %s %s\n", name, braces(labels), formatTimestamp(s.CreatedTimestamp), timestamp)
+	}
+}
+
+// formatLabels returns the label pairs sorted by name, without the enclosing braces.
+func formatLabels(keys, values []string) string {
+	pairs := make([]string, 0, len(keys))
+	for i, key := range keys {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, key, escape(values[i])))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// formatTimestamp returns the timestamp in seconds with millisecond precision.
+func formatTimestamp(t time.Time) string {
+	return fmt.Sprintf("%d.%03d", t.Unix(), t.Nanosecond()/int(time.Millisecond))
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backfill
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/promlog"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+)
+
+func TestWriteOpenMetrics(t *testing.T) {
+	start := time.Unix(1700000000, 500*int64(time.Millisecond))
+	gauge := func(offset time.Duration, value float64) collectors.Sample {
+		return collectors.Sample{
+			FqName:      "stackdriver_gce_instance_cpu",
+			Help:        "CPU \"usage\"",
+			LabelKeys:   []string{"zone", "instance"},
+			LabelValues: []string{"a", "1"},
+			Timestamp:   start.Add(offset),
+			ValueType:   prometheus.GaugeValue,
+			Value:       value,
+		}
+	}
+
+	var b strings.Builder
+	err := WriteOpenMetrics(&b, []collectors.Sample{
+		gauge(time.Minute, 2),
+		gauge(0, 1),
+		gauge(time.Minute, 2),
+		{
+			FqName:           "stackdriver_gce_instance_latency",
+			Timestamp:        start,
+			CreatedTimestamp: start.Add(-time.Hour),
+			ValueType:        prometheus.CounterValue,
+			Histogram:        &collectors.HistogramSample{Sum: 3, Count: 2, Buckets: map[float64]uint64{1: 1, math.Inf(1): 2}},
+		},
+		{
+			FqName:           "stackdriver_gce_instance_requests",
+			Timestamp:        start,
+			CreatedTimestamp: start.Add(-time.Minute),
+			ValueType:        prometheus.CounterValue,
+			Value:            7,
+		},
+		{
+			FqName:    "stackdriver_gce_instance_sent_bytes_total",
+			Timestamp: start,
+			ValueType: prometheus.CounterValue,
+			Value:     3,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# TYPE stackdriver_gce_instance_cpu gauge
+# HELP stackdriver_gce_instance_cpu CPU \"usage\"
+stackdriver_gce_instance_cpu{instance="1",zone="a"} 1 1700000000.500
+stackdriver_gce_instance_cpu{instance="1",zone="a"} 2 1700000060.500
+# TYPE stackdriver_gce_instance_latency histogram
+stackdriver_gce_instance_latency_bucket{le="1"} 1 1700000000.500
+stackdriver_gce_instance_latency_bucket{le="+Inf"} 2 1700000000.500
+stackdriver_gce_instance_latency_count 2 1700000000.500
+stackdriver_gce_instance_latency_sum 3 1700000000.500
+stackdriver_gce_instance_latency_created 1699996400.500 1700000000.500
+# TYPE stackdriver_gce_instance_requests counter
+stackdriver_gce_instance_requests_total 7 1700000000.500
+stackdriver_gce_instance_requests_created 1699999940.500 1700000000.500
+# TYPE stackdriver_gce_instance_sent_bytes counter
+stackdriver_gce_instance_sent_bytes_total 3 1700000000.500
+# EOF
+`
+	if b.String() != expected {
+		t.Errorf("unexpected output\nexpected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	buffer := NewBuffer(promlog.New(&promlog.Config{}), 2)
+	sample := collectors.Sample{FqName: "metric", Timestamp: time.Unix(1, 0), ValueType: prometheus.GaugeValue}
+	if err := buffer.Append([]collectors.Sample{sample}); err != nil {
+		t.Fatal(err)
+	}
+	second, third := sample, sample
+	second.Timestamp, third.Timestamp = time.Unix(2, 0), time.Unix(3, 0)
+	if err := buffer.Append([]collectors.Sample{second, third}); err == nil {
+		t.Error("expected samples above the limit to be rejected")
+	}
+	if err := buffer.Append([]collectors.Sample{second}); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(method string) string {
+		rec := httptest.NewRecorder()
+		buffer.ServeHTTP(rec, httptest.NewRequest(method, "/backfill", nil))
+		return rec.Body.String()
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		if body := serve(method); strings.Count(body, "metric 0 ") != 2 {
+			t.Errorf("%s: expected the buffered samples, got:\n%s", method, body)
+		}
+	}
+	if body := serve(http.MethodPost); body != "# EOF\n" {
+		t.Errorf("expected drained samples to be removed from the buffer, got:\n%s", body)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// ScrapeError is an error which occurred while collecting the metrics of a metric type prefix.
+type ScrapeError struct {
+	Prefix string
+	// MetricType is empty when the metric descriptors of Prefix could not be listed.
+	MetricType string
+	Err        error
+}
+
+func (e *ScrapeError) Error() string {
+	if e.MetricType == "" {
+		return fmt.Sprintf("listing metric descriptors of prefix %s: %v", e.Prefix, e.Err)
+	}
+	return fmt.Sprintf("collecting metric type %s: %v", e.MetricType, e.Err)
+}
+
+func (e *ScrapeError) Unwrap() error {
+	return e.Err
+}
+
+// Code classifies the error: the HTTP status code of Monitoring API errors, "timeout" or "canceled" when the
+// collection was cut short by its context and "other" for anything else.
+func (e *ScrapeError) Code() string {
+	var apiErr *googleapi.Error
+	switch {
+	case errors.As(e.Err, &apiErr):
+		return strconv.Itoa(apiErr.Code)
+	case errors.Is(e.Err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(e.Err, context.Canceled):
+		return "canceled"
+	default:
+		return "other"
+	}
+}
+
+// ScrapeErrors are all the errors which occurred during a single collection.
+type ScrapeErrors []*ScrapeError
+
+func (e ScrapeErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d errors occurred: %s", len(e), strings.Join(messages, "; "))
+}
+
+// forPrefix returns the errors of the given metric type prefix.
+func (e ScrapeErrors) forPrefix(prefix string) ScrapeErrors {
+	var errs ScrapeErrors
+	for _, err := range e {
+		if err.Prefix == prefix {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// PrefixErrors are the errors of the last collection of a metric type prefix.
+type PrefixErrors struct {
+	ProjectID string
+	Prefix    string
+	Timestamp time.Time
+	Errors    ScrapeErrors
+}
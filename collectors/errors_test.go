@@ -0,0 +1,138 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promlog"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+func TestScrapeErrorCode(t *testing.T) {
+	for _, tc := range []struct {
+		err      error
+		expected string
+	}{
+		{&googleapi.Error{Code: http.StatusForbidden}, "403"},
+		{fmt.Errorf("wrapped: %w", &googleapi.Error{Code: http.StatusTooManyRequests}), "429"},
+		{&url.Error{Op: "Get", URL: "http://example.com", Err: context.DeadlineExceeded}, "timeout"},
+		{context.Canceled, "canceled"},
+		{errors.New("invalid ingest delay"), "other"},
+	} {
+		if code := (&ScrapeError{Err: tc.err}).Code(); code != tc.expected {
+			t.Errorf("expected code %q for %v, got %q", tc.expected, tc.err, code)
+		}
+	}
+}
+
+func TestScrapeErrorsError(t *testing.T) {
+	single := ScrapeErrors{{Prefix: "a", Err: errors.New("boom")}}
+	if msg := single.Error(); msg != "listing metric descriptors of prefix a: boom" {
+		t.Errorf("unexpected message %q", msg)
+	}
+
+	multiple := ScrapeErrors{
+		{Prefix: "a", MetricType: "a/x", Err: errors.New("first")},
+		{Prefix: "a", MetricType: "a/y", Err: errors.New("second")},
+	}
+	if msg := multiple.Error(); msg != "2 errors occurred: collecting metric type a/x: first; collecting metric type a/y: second" {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
+
+func TestCollectReportsAllErrors(t *testing.T) {
+	service := newTestMonitoringService(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/metricDescriptors"):
+			_, _ = w.Write([]byte(`{"metricDescriptors": [
+				{"type": "test.googleapis.com/forbidden", "metricKind": "GAUGE", "valueType": "DOUBLE"},
+				{"type": "test.googleapis.com/throttled", "metricKind": "GAUGE", "valueType": "DOUBLE"},
+				{"type": "test.googleapis.com/ok", "metricKind": "GAUGE", "valueType": "DOUBLE"}
+			]}`))
+		case strings.HasSuffix(r.URL.Path, "/timeSeries"):
+			filter := r.URL.Query().Get("filter")
+			switch {
+			case strings.Contains(filter, "forbidden"):
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"error": {"code": 403, "message": "permission denied"}}`))
+			case strings.Contains(filter, "throttled"):
+				w.WriteHeader(http.StatusTooManyRequests)
+				_, _ = w.Write([]byte(`{"error": {"code": 429, "message": "quota exceeded"}}`))
+			default:
+				_, _ = w.Write([]byte(`{}`))
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	collector, err := NewMonitoringCollector("project", service, MonitoringCollectorOptions{
+		MetricTypePrefixes: []string{"test.googleapis.com"},
+		RequestInterval:    time.Minute,
+	}, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.CollectAndCount(collector)
+
+	for metricType, code := range map[string]string{
+		"test.googleapis.com/forbidden": "403",
+		"test.googleapis.com/throttled": "429",
+	} {
+		if v := testutil.ToFloat64(collector.metricTypeScrapeErrorsMetric.WithLabelValues("test.googleapis.com", metricType, code)); v != 1 {
+			t.Errorf("expected 1 error for %s with code %s, got %v", metricType, code, v)
+		}
+	}
+	if v := testutil.ToFloat64(collector.scrapeErrorsTotalMetric); v != 1 {
+		t.Errorf("expected the failing scrape to be counted once, got %v", v)
+	}
+
+	lastErrors := collector.LastScrapeErrors()
+	if len(lastErrors) != 1 || lastErrors[0].Prefix != "test.googleapis.com" {
+		t.Fatalf("expected the errors of a single prefix, got %+v", lastErrors)
+	}
+	if n := len(lastErrors[0].Errors); n != 2 {
+		t.Errorf("expected 2 errors, got %d: %v", n, lastErrors[0].Errors)
+	}
+}
+
+func TestLastScrapeErrorsClearedOnSuccess(t *testing.T) {
+	collector, err := NewMonitoringCollector("project", nil, MonitoringCollectorOptions{}, promlog.New(&promlog.Config{}), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collector.recordScrape(context.Background(), time.Now(), []string{"a", "b"}, ScrapeErrors{
+		{Prefix: "a", MetricType: "a/x", Err: errors.New("boom")},
+	})
+	if lastErrors := collector.LastScrapeErrors(); len(lastErrors) != 1 || lastErrors[0].Prefix != "a" {
+		t.Fatalf("expected the errors of prefix a, got %+v", lastErrors)
+	}
+
+	collector.recordScrape(context.Background(), time.Now(), []string{"a"}, nil)
+	if lastErrors := collector.LastScrapeErrors(); len(lastErrors) != 0 {
+		t.Errorf("expected the errors to be cleared, got %+v", lastErrors)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/monitoring/v3"
+
+	"github.com/prometheus-community/stackdriver_exporter/utils"
+)
+
+const (
+	// AllMetadataLabels selects every system or user metadata label.
+	AllMetadataLabels = "*"
+
+	systemMetadataLabelPrefix = "metadata_system_"
+	userMetadataLabelPrefix   = "metadata_user_"
+)
+
+// metadataLabelSelector selects metadata labels by key.
+type metadataLabelSelector struct {
+	all  bool
+	keys map[string]bool
+}
+
+func newMetadataLabelSelector(keys []string) metadataLabelSelector {
+	s := metadataLabelSelector{keys: make(map[string]bool, len(keys))}
+	for _, key := range keys {
+		if key == AllMetadataLabels {
+			s.all = true
+		}
+		s.keys[key] = true
+	}
+	return s
+}
+
+func (s metadataLabelSelector) empty() bool {
+	return !s.all && len(s.keys) == 0
+}
+
+func (s metadataLabelSelector) selects(key string) bool {
+	return s.all || s.keys[key]
+}
+
+// metadataLabels returns the keys and values of the selected system and user metadata labels, named
+// `metadata_system_<key>` and `metadata_user_<key>`. The values of system labels which are lists are joined with
+// commas. The labels are ordered by their original key, so keys which are sanitized to the same name collide in the same
+// order on every scrape.
+// @see https://cloud.google.com/monitoring/api/ref_v3/rest/v3/projects.timeSeries#MonitoredResourceMetadata
+func metadataLabels(metadata *monitoring.MonitoredResourceMetadata, systemLabels, userLabels metadataLabelSelector) ([]string, []string, error) {
+	var keys, values []string
+	if metadata == nil {
+		return keys, values, nil
+	}
+
+	if !systemLabels.empty() && len(metadata.SystemLabels) > 0 {
+		var systemValues map[string]interface{}
+		if err := json.Unmarshal(metadata.SystemLabels, &systemValues); err != nil {
+			return nil, nil, fmt.Errorf("error parsing metadata system labels: %w", err)
+		}
+		for _, key := range sortedMapKeys(systemValues) {
+			if systemLabels.selects(key) {
+				keys = append(keys, systemMetadataLabelPrefix+utils.SanitizeLabelName(key))
+				values = append(values, systemLabelValue(systemValues[key]))
+			}
+		}
+	}
+
+	for _, key := range sortedMapKeys(metadata.UserLabels) {
+		if userLabels.selects(key) {
+			keys = append(keys, userMetadataLabelPrefix+utils.SanitizeLabelName(key))
+			values = append(values, metadata.UserLabels[key])
+		}
+	}
+	return keys, values, nil
+}
+
+// sortedMapKeys returns the keys of m in ascending order.
+func sortedMapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// systemLabelValue formats the value of a system label, which can be a string, a boolean or a list of strings.
+func systemLabelValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, systemLabelValue(item))
+		}
+		return strings.Join(values, ",")
+	case nil:
+		return ""
+	}
+	b, _ := json.Marshal(value)
+	return string(b)
+}
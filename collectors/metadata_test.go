@@ -0,0 +1,203 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/promlog"
+	"google.golang.org/api/monitoring/v3"
+)
+
+func testMetadata() *monitoring.MonitoredResourceMetadata {
+	return &monitoring.MonitoredResourceMetadata{
+		SystemLabels: []byte(`{"name": "web-1", "machine_type": "e2-small", "network_tags": ["http", "ssh"], "spot_instance": false}`),
+		UserLabels:   map[string]string{"team": "search", "cost-center": "42"},
+	}
+}
+
+func TestMetadataLabels(t *testing.T) {
+	keys, values, err := metadataLabels(testMetadata(), newMetadataLabelSelector([]string{"*"}), newMetadataLabelSelector([]string{"cost-center"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedKeys := []string{"metadata_system_machine_type", "metadata_system_name", "metadata_system_network_tags", "metadata_system_spot_instance", "metadata_user_cost_center"}
+	expectedValues := []string{"e2-small", "web-1", "http,ssh", "false", "42"}
+	if !reflect.DeepEqual(keys, expectedKeys) || !reflect.DeepEqual(values, expectedValues) {
+		t.Errorf("expected %v=%v, got %v=%v", expectedKeys, expectedValues, keys, values)
+	}
+
+	if _, _, err := metadataLabels(&monitoring.MonitoredResourceMetadata{SystemLabels: []byte(`[`)}, newMetadataLabelSelector([]string{"*"}), newMetadataLabelSelector(nil)); err == nil {
+		t.Error("expected an error for invalid system labels")
+	}
+	if keys, _, err := metadataLabels(nil, newMetadataLabelSelector([]string{"*"}), newMetadataLabelSelector([]string{"*"})); err != nil || len(keys) != 0 {
+		t.Errorf("expected no labels without metadata, got %v (%v)", keys, err)
+	}
+}
+
+func TestReportMetadataLabels(t *testing.T) {
+	c, err := NewMonitoringCollector("project", nil, MonitoringCollectorOptions{
+		MetadataSystemLabels: []string{"name"},
+		MetadataUserLabels:   []string{AllMetadataLabels},
+	}, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptor := &monitoring.MetricDescriptor{Type: "compute.googleapis.com/instance/uptime", MetricKind: "GAUGE", ValueType: "DOUBLE"}
+	value := 1.0
+	ts := newTimeSeries(descriptor.Type, "DOUBLE", &monitoring.TypedValue{DoubleValue: &value}, nil)
+	ts.Metadata = testMetadata()
+
+	ch := make(chan prometheus.Metric, 10)
+	page := &monitoring.ListTimeSeriesResponse{TimeSeries: []*monitoring.TimeSeries{ts}}
+	if err := c.reportTimeSeriesMetrics(page, descriptor, CollectionSettings{}, newStringValueLimiter(0), ch, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+
+	out := &dto.Metric{}
+	if err := (<-ch).Write(out); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{"metadata_system_name": "web-1", "metadata_system_machine_type": "", "metadata_user_team": "search", "metadata_user_cost_center": "42"} {
+		if v := labelValue(out, key); v != value {
+			t.Errorf("expected label %s=%q, got %q", key, value, v)
+		}
+	}
+}
+
+func TestReportMetadataLabelCollisions(t *testing.T) {
+	// The user labels team-a and team_a are both sanitized to metadata_user_team_a
+	c, err := NewMonitoringCollector("project", nil, MonitoringCollectorOptions{
+		MetadataUserLabels: []string{AllMetadataLabels},
+	}, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptor := &monitoring.MetricDescriptor{Type: "compute.googleapis.com/instance/uptime", MetricKind: "GAUGE", ValueType: "DOUBLE"}
+	value := 1.0
+	ts := newTimeSeries(descriptor.Type, "DOUBLE", &monitoring.TypedValue{DoubleValue: &value}, nil)
+	ts.Metadata = &monitoring.MonitoredResourceMetadata{UserLabels: map[string]string{"team_a": "ads", "team-a": "search"}}
+
+	for i := 0; i < 5; i++ {
+		ch := make(chan prometheus.Metric, 10)
+		page := &monitoring.ListTimeSeriesResponse{TimeSeries: []*monitoring.TimeSeries{ts}}
+		if err := c.reportTimeSeriesMetrics(page, descriptor, CollectionSettings{}, newStringValueLimiter(0), ch, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		close(ch)
+
+		out := &dto.Metric{}
+		if err := (<-ch).Write(out); err != nil {
+			t.Fatal(err)
+		}
+		if v := labelValue(out, "metadata_user_team_a"); v != "search" {
+			t.Fatalf("expected the label with the first key to be kept, got %q", v)
+		}
+	}
+	if dropped := testutil.ToFloat64(c.labelCollisionsTotalMetric.WithLabelValues(descriptor.Type, "dropped")); dropped != 5 {
+		t.Errorf("expected 5 dropped labels, got %v", dropped)
+	}
+}
+
+// fakeInventory holds the labels of instances as alternating keys and values.
+type fakeInventory map[string][]string
+
+func (i fakeInventory) InstanceLabels(projectID, instanceID string) ([]string, []string) {
+	var keys, values []string
+	pairs := i[projectID+"/"+instanceID]
+	for j := 0; j < len(pairs); j += 2 {
+		keys = append(keys, pairs[j])
+		values = append(values, pairs[j+1])
+	}
+	return keys, values
+}
+
+func (i fakeInventory) CollectProject(projectID string, ch chan<- prometheus.Metric) {}
+
+func TestReportInventoryLabels(t *testing.T) {
+	c, err := NewMonitoringCollector("project", nil, MonitoringCollectorOptions{
+		Inventory:       fakeInventory{"project/1": {"instance_name", "web-1", "label_team", "search"}},
+		InventoryLabels: true,
+	}, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptor := &monitoring.MetricDescriptor{Type: "compute.googleapis.com/instance/uptime", MetricKind: "GAUGE", ValueType: "DOUBLE"}
+	value := 1.0
+	ts := newTimeSeries(descriptor.Type, "DOUBLE", &monitoring.TypedValue{DoubleValue: &value}, map[string]string{"instance_name": "web-1"})
+	ts.Resource.Labels["project_id"] = "project"
+
+	ch := make(chan prometheus.Metric, 10)
+	page := &monitoring.ListTimeSeriesResponse{TimeSeries: []*monitoring.TimeSeries{ts}}
+	if err := c.reportTimeSeriesMetrics(page, descriptor, CollectionSettings{}, newStringValueLimiter(0), ch, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+
+	out := &dto.Metric{}
+	if err := (<-ch).Write(out); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{"instance_id": "1", "instance_name": "web-1", "label_team": "search"} {
+		if v := labelValue(out, key); v != value {
+			t.Errorf("expected label %s=%q, got %q", key, value, v)
+		}
+	}
+	// The instance_name metric label equal to the inventory one is not a collision
+	if collisions := testutil.CollectAndCount(c.labelCollisionsTotalMetric); collisions != 0 {
+		t.Errorf("expected no label collisions, got %d", collisions)
+	}
+}
+
+func TestReportInventoryLabelCollisions(t *testing.T) {
+	// The user labels team-a and team_a are both sanitized to label_team_a
+	c, err := NewMonitoringCollector("project", nil, MonitoringCollectorOptions{
+		Inventory:       fakeInventory{"project/1": {"label_team_a", "search", "label_team_a", "ads"}},
+		InventoryLabels: true,
+		LabelCollisions: SuffixLabelCollisions,
+	}, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptor := &monitoring.MetricDescriptor{Type: "compute.googleapis.com/instance/uptime", MetricKind: "GAUGE", ValueType: "DOUBLE"}
+	value := 1.0
+	ts := newTimeSeries(descriptor.Type, "DOUBLE", &monitoring.TypedValue{DoubleValue: &value}, nil)
+	ts.Resource.Labels["project_id"] = "project"
+
+	ch := make(chan prometheus.Metric, 10)
+	page := &monitoring.ListTimeSeriesResponse{TimeSeries: []*monitoring.TimeSeries{ts}}
+	if err := c.reportTimeSeriesMetrics(page, descriptor, CollectionSettings{}, newStringValueLimiter(0), ch, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+
+	out := &dto.Metric{}
+	if err := (<-ch).Write(out); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{"label_team_a": "search", "label_team_a_inventory": "ads"} {
+		if v := labelValue(out, key); v != value {
+			t.Errorf("expected label %s=%q, got %q", key, value, v)
+		}
+	}
+	if renamed := testutil.ToFloat64(c.labelCollisionsTotalMetric.WithLabelValues(descriptor.Type, "renamed")); renamed != 1 {
+		t.Errorf("expected 1 renamed label, got %v", renamed)
+	}
+}
@@ -17,6 +17,8 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -24,40 +26,183 @@ import (
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 	"golang.org/x/net/context"
 	"google.golang.org/api/monitoring/v3"
 
+	"github.com/prometheus-community/stackdriver_exporter/relabel"
 	"github.com/prometheus-community/stackdriver_exporter/utils"
 )
 
 const namespace = "stackdriver"
 
+// resourceTypeLabel holds the monitored resource type when the metric names do not contain it.
+const resourceTypeLabel = "resource_type"
+
 type MetricFilter struct {
 	Prefix   string
 	Modifier string
 }
 
+// Inventory looks up the Compute Engine resources of a project, see the inventory package.
+type Inventory interface {
+	// InstanceLabels returns the labels joined onto the series of an instance as parallel keys and values slices, or
+	// nil if the instance is unknown. Keys can repeat.
+	InstanceLabels(projectID, instanceID string) ([]string, []string)
+	// CollectProject collects the info metrics of the resources of a project.
+	CollectProject(projectID string, ch chan<- prometheus.Metric)
+}
+
+// RelabelConfig relabels the series of the metric types starting with Prefix.
+type RelabelConfig struct {
+	Prefix string
+	Rules  []*relabel.Config
+}
+
+// Strategies for the metric and resource labels sharing a key.
+const (
+	// DropLabelCollisions drops the resource labels whose key is already used by a metric label.
+	DropLabelCollisions = "drop"
+	// SuffixLabelCollisions renames the colliding resource labels to `<key>_resource`.
+	SuffixLabelCollisions = "suffix"
+	// PrefixLabels namespaces every label as `metric_<key>` or `resource_<key>`, so that they never collide.
+	PrefixLabels = "prefix"
+)
+
+const (
+	// metricTypeMetaLabel and resourceTypeMetaLabel hold the metric type and the monitored resource type of a series
+	// while it is relabeled.
+	metricTypeMetaLabel   = "__metric_type__"
+	resourceTypeMetaLabel = "__resource_type__"
+)
+
+// CollectionSettings control how the time series of a metric type are requested and reported.
+type CollectionSettings struct {
+	// RequestInterval is the time interval used in each request to get metrics. If there are many data points returned
+	// during this interval, only the latest will be reported unless AllPoints is set.
+	RequestInterval time.Duration
+	// RequestOffset is used to offset the requested interval into the past.
+	RequestOffset time.Duration
+	// IngestDelay decides if the ingestion delay specified in the metrics metadata is used when calculating the
+	// request time interval.
+	IngestDelay bool
+	// DropDelegatedProjects decides if only metrics matching the collector's projectID should be retrieved.
+	DropDelegatedProjects bool
+	// AggregateDeltas decides if DELTA metrics should be treated as a counter using the provided counterStore/distributionStore or a gauge
+	AggregateDeltas bool
+	// Aggregation, when set, is sent with each request so the time series are aligned and reduced by the API.
+	Aggregation *Aggregation
+	// PollInterval, when set, fetches the metrics in the background at this interval instead of on every Collect.
+	// It only applies to whole metric type prefixes and requires the collector to be started with StartPolling.
+	PollInterval time.Duration
+	// NativeHistograms decides if DISTRIBUTION metrics with exponential buckets matching a native histogram schema are
+	// also exposed as native histograms.
+	NativeHistograms bool
+	// StringValuesLimit is the maximum number of distinct values of a STRING metric type exported per collection, 0
+	// means unlimited.
+	StringValuesLimit int
+	// AllPoints decides if every point of the requested interval is handed to the collector's SampleSink with its own
+	// timestamp, in addition to the newest one being reported. Aggregated DELTA metrics only report the newest point.
+	AllPoints bool
+}
+
+// Aggregation describes how the Monitoring API aligns and reduces time series before returning them.
+// @see https://cloud.google.com/monitoring/api/ref_v3/rest/v3/projects.timeSeries/list#aggregation
+type Aggregation struct {
+	// AlignmentPeriod is the period of time each point represents after alignment.
+	AlignmentPeriod time.Duration
+	// PerSeriesAligner is the aligner applied to each individual time series, ie ALIGN_RATE.
+	PerSeriesAligner string
+	// CrossSeriesReducer is the reducer combining the aligned time series, ie REDUCE_SUM.
+	CrossSeriesReducer string
+	// GroupByFields are the fields preserved by the CrossSeriesReducer, ie resource.label.zone. Labels which are not
+	// grouped by are not returned by the API.
+	GroupByFields []string
+}
+
+// metricNameSuffix returns the normalized aligner and reducer names which are appended to the metric name, so that
+// aggregated series are not confused with the raw ones.
+func (a *Aggregation) metricNameSuffix() string {
+	var parts []string
+	for _, v := range []string{a.PerSeriesAligner, a.CrossSeriesReducer} {
+		if v != "" && v != "ALIGN_NONE" && v != "REDUCE_NONE" {
+			parts = append(parts, strings.ToLower(v))
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+func (a *Aggregation) apply(call *monitoring.ProjectsTimeSeriesListCall) *monitoring.ProjectsTimeSeriesListCall {
+	if a.AlignmentPeriod > 0 {
+		call = call.AggregationAlignmentPeriod(fmt.Sprintf("%ds", int64(a.AlignmentPeriod/time.Second)))
+	}
+	if a.PerSeriesAligner != "" {
+		call = call.AggregationPerSeriesAligner(a.PerSeriesAligner)
+	}
+	if a.CrossSeriesReducer != "" {
+		call = call.AggregationCrossSeriesReducer(a.CrossSeriesReducer)
+	}
+	if len(a.GroupByFields) > 0 {
+		call = call.AggregationGroupByFields(a.GroupByFields...)
+	}
+	return call
+}
+
+// CollectionOverride replaces the default CollectionSettings for the metric types it matches.
+type CollectionOverride struct {
+	// Match is either a metric type prefix or, when it contains any of the characters `*?[`, a glob pattern as
+	// understood by path.Match.
+	Match    string
+	Settings CollectionSettings
+}
+
+func (o CollectionOverride) matches(metricType string) bool {
+	if strings.ContainsAny(o.Match, "*?[") {
+		matched, _ := path.Match(o.Match, metricType)
+		return matched
+	}
+	return strings.HasPrefix(metricType, o.Match)
+}
+
 type MonitoringCollector struct {
 	projectID                       string
 	metricsTypePrefixes             []string
 	metricsFilters                  []MetricFilter
-	metricsInterval                 time.Duration
-	metricsOffset                   time.Duration
-	metricsIngestDelay              bool
+	defaultSettings                 CollectionSettings
+	collectionOverrides             []CollectionOverride
 	monitoringService               *monitoring.Service
 	apiCallsTotalMetric             prometheus.Counter
 	scrapesTotalMetric              prometheus.Counter
 	scrapeErrorsTotalMetric         prometheus.Counter
+	metricTypeScrapeErrorsMetric    *prometheus.CounterVec
 	lastScrapeErrorMetric           prometheus.Gauge
 	lastScrapeTimestampMetric       prometheus.Gauge
 	lastScrapeDurationSecondsMetric prometheus.Gauge
+	lastScrapeTimeoutMetric         prometheus.Gauge
+	snapshotAgeDesc                 *prometheus.Desc
 	collectorFillMissingLabels      bool
-	monitoringDropDelegatedProjects bool
 	logger                          log.Logger
 	counterStore                    DeltaCounterStore
 	histogramStore                  DeltaHistogramStore
-	aggregateDeltas                 bool
 	descriptorCache                 DescriptorCache
+	snapshots                       *Snapshots
+	lastErrorsMtx                   sync.Mutex
+	lastErrors                      map[string]PrefixErrors
+	sampleSink                      SampleSink
+	sampleTracker                   *SampleTracker
+	timeSeriesSink                  TimeSeriesSink
+	unitLabel                       bool
+	baseUnits                       bool
+	metricUnitsMtx                  sync.Mutex
+	metricUnits                     map[string]string
+	metricNameTemplate              *utils.MetricNameTemplate
+	relabelConfigs                  []RelabelConfig
+	labelCollisions                 string
+	labelCollisionsTotalMetric      *prometheus.CounterVec
+	metadataSystemLabels            metadataLabelSelector
+	metadataUserLabels              metadataLabelSelector
+	inventory                       Inventory
+	inventoryLabels                 bool
 }
 
 type MonitoringCollectorOptions struct {
@@ -85,6 +230,58 @@ type MonitoringCollectorOptions struct {
 	DescriptorCacheTTL time.Duration
 	// DescriptorCacheOnlyGoogle decides whether only google specific descriptors should be cached or all
 	DescriptorCacheOnlyGoogle bool
+	// PollInterval, when set, fetches the metrics in the background at this interval instead of on every Collect.
+	// It requires Snapshots and a collector of the project started with StartPolling.
+	PollInterval time.Duration
+	// NativeHistograms decides if DISTRIBUTION metrics with exponential buckets matching a native histogram schema are
+	// also exposed as native histograms.
+	NativeHistograms bool
+	// StringValuesLimit is the maximum number of distinct values of a STRING metric type exported per collection, 0
+	// means unlimited.
+	StringValuesLimit int
+	// AllPoints decides if every point of the requested interval is handed to SampleSink with its own timestamp.
+	AllPoints bool
+	// SampleSink receives the points of the metric types collected with AllPoints, nil disables it.
+	SampleSink SampleSink
+	// TimeSeriesSink receives every time series returned by the API, nil disables it.
+	TimeSeriesSink TimeSeriesSink
+	// UnitLabel decides if the unit of the metric descriptor is added as `unit` label to every metric.
+	UnitLabel bool
+	// BaseUnits decides if values are converted to the base unit of their metric descriptor unit, ie milliseconds to
+	// seconds, which is then appended to the metric name.
+	BaseUnits bool
+	// MetricNameTemplate builds the metric names, see utils.MetricNameTemplate. Defaults to
+	// utils.DefaultMetricNameTemplate. Unless it contains {resource_type}, the monitored resource type is added as
+	// `resource_type` label instead.
+	MetricNameTemplate string
+	// RelabelConfigs are applied in order to the labels of the series of matching metric types.
+	RelabelConfigs []RelabelConfig
+	// LabelCollisions is how metric and resource labels sharing a key are handled, one of DropLabelCollisions
+	// (default), SuffixLabelCollisions or PrefixLabels.
+	LabelCollisions string
+	// MetadataSystemLabels are the keys of the metadata system labels added to the series, AllMetadataLabels selects
+	// all of them.
+	MetadataSystemLabels []string
+	// MetadataUserLabels are the keys of the metadata user labels added to the series, AllMetadataLabels selects all
+	// of them.
+	MetadataUserLabels []string
+	// Inventory, when set, collects the info metrics of the Compute Engine resources of the project with every
+	// collection.
+	Inventory Inventory
+	// InventoryLabels decides if the labels of Compute Engine instances found in Inventory are added to the series of
+	// gce_instance resources.
+	InventoryLabels bool
+	// SampleTracker remembers the points accepted by SampleSink. It can be shared by the collectors of several sets of
+	// prefixes of the project, so that a point collected by several of them is only exported once. A collector gets its
+	// own when nil.
+	SampleTracker *SampleTracker
+	// Snapshots holds the metrics polled in the background for the project. It can be shared by the collectors of
+	// several sets of prefixes of the project, so that a single one of them polls. Without it, every prefix is
+	// requested on every Collect regardless of its PollInterval.
+	Snapshots *Snapshots
+	// CollectionOverrides replace the collection settings for the metric types they match. The first matching
+	// override is used.
+	CollectionOverrides []CollectionOverride
 }
 
 func isGoogleMetric(name string) bool {
@@ -120,9 +317,31 @@ type DeltaHistogramStore interface {
 	ListMetrics(metricDescriptorName string) []*HistogramMetric
 }
 
+// TimeSeriesSink receives the time series of every page returned by the API as they are reported, ie to export them
+// in another format than Prometheus metrics. Time series of delegated projects are not handed over when they are
+// dropped.
+type TimeSeriesSink interface {
+	AppendTimeSeries(projectID string, metricDescriptor *monitoring.MetricDescriptor, settings CollectionSettings, timeSeries []*monitoring.TimeSeries)
+}
+
 func NewMonitoringCollector(projectID string, monitoringService *monitoring.Service, opts MonitoringCollectorOptions, logger log.Logger, counterStore DeltaCounterStore, histogramStore DeltaHistogramStore) (*MonitoringCollector, error) {
 	const subsystem = "monitoring"
 
+	switch opts.LabelCollisions {
+	case "":
+		opts.LabelCollisions = DropLabelCollisions
+	case DropLabelCollisions, SuffixLabelCollisions, PrefixLabels:
+	default:
+		return nil, fmt.Errorf("unknown label collisions strategy %q", opts.LabelCollisions)
+	}
+	if opts.MetricNameTemplate == "" {
+		opts.MetricNameTemplate = utils.DefaultMetricNameTemplate
+	}
+	metricNameTemplate, err := utils.ParseMetricNameTemplate(opts.MetricNameTemplate)
+	if err != nil {
+		return nil, err
+	}
+
 	apiCallsTotalMetric := prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Namespace:   namespace,
@@ -153,6 +372,28 @@ func NewMonitoringCollector(projectID string, monitoringService *monitoring.Serv
 		},
 	)
 
+	metricTypeScrapeErrorsMetric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "metric_type_scrape_errors_total",
+			Help:        "Total number of Google Stackdriver Monitoring metrics scrape errors by metric type prefix, metric type and error code.",
+			ConstLabels: prometheus.Labels{"project_id": projectID},
+		},
+		[]string{"prefix", "metric_type", "code"},
+	)
+
+	labelCollisionsTotalMetric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "label_collisions_total",
+			Help:        "Total number of labels dropped or renamed because their key was already used by another label of the series.",
+			ConstLabels: prometheus.Labels{"project_id": projectID},
+		},
+		[]string{"metric_type", "action"},
+	)
+
 	lastScrapeErrorMetric := prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Namespace:   namespace,
@@ -183,6 +424,23 @@ func NewMonitoringCollector(projectID string, monitoringService *monitoring.Serv
 		},
 	)
 
+	lastScrapeTimeoutMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "last_scrape_timeout",
+			Help:        "Whether the last metrics scrape from Google Stackdriver Monitoring was cut short by its deadline and only reported partial results (1 for timeout, 0 for complete).",
+			ConstLabels: prometheus.Labels{"project_id": projectID},
+		},
+	)
+
+	snapshotAgeDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, subsystem, "snapshot_age_seconds"),
+		"Age of the served snapshot of a metric type prefix fetched in the background from Google Stackdriver Monitoring.",
+		[]string{"metric_type_prefix"},
+		prometheus.Labels{"project_id": projectID},
+	)
+
 	var descriptorCache DescriptorCache
 	if opts.DescriptorCacheTTL == 0 {
 		descriptorCache = &noopDescriptorCache{}
@@ -193,69 +451,186 @@ func NewMonitoringCollector(projectID string, monitoringService *monitoring.Serv
 
 	}
 
+	sampleTracker := opts.SampleTracker
+	if sampleTracker == nil {
+		sampleTracker = NewSampleTracker()
+	}
+
 	monitoringCollector := &MonitoringCollector{
-		projectID:                       projectID,
-		metricsTypePrefixes:             opts.MetricTypePrefixes,
-		metricsFilters:                  opts.ExtraFilters,
-		metricsInterval:                 opts.RequestInterval,
-		metricsOffset:                   opts.RequestOffset,
-		metricsIngestDelay:              opts.IngestDelay,
+		projectID:           projectID,
+		metricsTypePrefixes: opts.MetricTypePrefixes,
+		metricsFilters:      opts.ExtraFilters,
+		defaultSettings: CollectionSettings{
+			RequestInterval:       opts.RequestInterval,
+			RequestOffset:         opts.RequestOffset,
+			IngestDelay:           opts.IngestDelay,
+			DropDelegatedProjects: opts.DropDelegatedProjects,
+			AggregateDeltas:       opts.AggregateDeltas,
+			PollInterval:          opts.PollInterval,
+			NativeHistograms:      opts.NativeHistograms,
+			StringValuesLimit:     opts.StringValuesLimit,
+			AllPoints:             opts.AllPoints,
+		},
+		collectionOverrides:             opts.CollectionOverrides,
 		monitoringService:               monitoringService,
 		apiCallsTotalMetric:             apiCallsTotalMetric,
 		scrapesTotalMetric:              scrapesTotalMetric,
 		scrapeErrorsTotalMetric:         scrapeErrorsTotalMetric,
+		metricTypeScrapeErrorsMetric:    metricTypeScrapeErrorsMetric,
 		lastScrapeErrorMetric:           lastScrapeErrorMetric,
 		lastScrapeTimestampMetric:       lastScrapeTimestampMetric,
 		lastScrapeDurationSecondsMetric: lastScrapeDurationSecondsMetric,
+		lastScrapeTimeoutMetric:         lastScrapeTimeoutMetric,
+		snapshotAgeDesc:                 snapshotAgeDesc,
 		collectorFillMissingLabels:      opts.FillMissingLabels,
-		monitoringDropDelegatedProjects: opts.DropDelegatedProjects,
 		logger:                          logger,
 		counterStore:                    counterStore,
 		histogramStore:                  histogramStore,
-		aggregateDeltas:                 opts.AggregateDeltas,
 		descriptorCache:                 descriptorCache,
+		snapshots:                       opts.Snapshots,
+		lastErrors:                      make(map[string]PrefixErrors),
+		sampleSink:                      opts.SampleSink,
+		sampleTracker:                   sampleTracker,
+		timeSeriesSink:                  opts.TimeSeriesSink,
+		unitLabel:                       opts.UnitLabel,
+		baseUnits:                       opts.BaseUnits,
+		metricUnits:                     make(map[string]string),
+		metricNameTemplate:              metricNameTemplate,
+		relabelConfigs:                  opts.RelabelConfigs,
+		labelCollisions:                 opts.LabelCollisions,
+		labelCollisionsTotalMetric:      labelCollisionsTotalMetric,
+		metadataSystemLabels:            newMetadataLabelSelector(opts.MetadataSystemLabels),
+		metadataUserLabels:              newMetadataLabelSelector(opts.MetadataUserLabels),
+		inventory:                       opts.Inventory,
+		inventoryLabels:                 opts.InventoryLabels && opts.Inventory != nil,
 	}
 
 	return monitoringCollector, nil
 }
 
+// settingsFor returns the CollectionSettings of the first override matching metricType, or the default settings.
+func (c *MonitoringCollector) settingsFor(metricType string) CollectionSettings {
+	for _, override := range c.collectionOverrides {
+		if override.matches(metricType) {
+			return override.Settings
+		}
+	}
+	return c.defaultSettings
+}
+
 func (c *MonitoringCollector) Describe(ch chan<- *prometheus.Desc) {
 	c.apiCallsTotalMetric.Describe(ch)
 	c.scrapesTotalMetric.Describe(ch)
 	c.scrapeErrorsTotalMetric.Describe(ch)
+	c.metricTypeScrapeErrorsMetric.Describe(ch)
+	c.labelCollisionsTotalMetric.Describe(ch)
 	c.lastScrapeErrorMetric.Describe(ch)
 	c.lastScrapeTimestampMetric.Describe(ch)
 	c.lastScrapeDurationSecondsMetric.Describe(ch)
+	c.lastScrapeTimeoutMetric.Describe(ch)
+	ch <- c.snapshotAgeDesc
 }
 
 func (c *MonitoringCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectWithContext(context.Background(), ch)
+}
+
+// CollectWithContext is like Collect, but stops requesting the API once ctx is done and only reports the metrics
+// fetched until then.
+func (c *MonitoringCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
 	var begun = time.Now()
 
+	polled, requested := c.polledPrefixes()
+	c.collectSnapshots(ch, polled)
+
+	if len(requested) > 0 || len(polled) == 0 {
+		errs := c.reportMonitoringMetrics(ctx, ch, begun, requested)
+		c.recordScrape(ctx, begun, requested, errs)
+	}
+	if c.inventory != nil {
+		c.inventory.CollectProject(c.projectID, ch)
+	}
+
+	c.scrapeErrorsTotalMetric.Collect(ch)
+	c.metricTypeScrapeErrorsMetric.Collect(ch)
+	c.labelCollisionsTotalMetric.Collect(ch)
+	c.apiCallsTotalMetric.Collect(ch)
+	c.scrapesTotalMetric.Collect(ch)
+	c.lastScrapeErrorMetric.Collect(ch)
+	c.lastScrapeTimestampMetric.Collect(ch)
+	c.lastScrapeDurationSecondsMetric.Collect(ch)
+	c.lastScrapeTimeoutMetric.Collect(ch)
+}
+
+// recordScrape updates the scrape self metrics and the last errors of prefixes with the outcome of a scrape which
+// started at begun.
+func (c *MonitoringCollector) recordScrape(ctx context.Context, begun time.Time, prefixes []string, errs ScrapeErrors) {
 	errorMetric := float64(0)
-	if err := c.reportMonitoringMetrics(ch, begun); err != nil {
+	timeoutMetric := float64(0)
+	if len(errs) > 0 {
 		errorMetric = float64(1)
 		c.scrapeErrorsTotalMetric.Inc()
-		level.Error(c.logger).Log("msg", "Error while getting Google Stackdriver Monitoring metrics", "err", err)
+		for _, err := range errs {
+			c.metricTypeScrapeErrorsMetric.WithLabelValues(err.Prefix, err.MetricType, err.Code()).Inc()
+		}
+		if ctx.Err() != nil {
+			timeoutMetric = float64(1)
+			level.Warn(c.logger).Log("msg", "Google Stackdriver Monitoring metrics scrape was cut short, reporting partial results", "err", ctx.Err(), "duration", time.Since(begun))
+		} else {
+			level.Error(c.logger).Log("msg", "Error while getting Google Stackdriver Monitoring metrics", "errors", len(errs), "err", errs)
+		}
 	}
-	c.scrapeErrorsTotalMetric.Collect(ch)
 
-	c.apiCallsTotalMetric.Collect(ch)
+	c.lastErrorsMtx.Lock()
+	for _, prefix := range prefixes {
+		if prefixErrs := errs.forPrefix(prefix); len(prefixErrs) > 0 {
+			c.lastErrors[prefix] = PrefixErrors{ProjectID: c.projectID, Prefix: prefix, Timestamp: begun, Errors: prefixErrs}
+		} else {
+			delete(c.lastErrors, prefix)
+		}
+	}
+	c.lastErrorsMtx.Unlock()
 
 	c.scrapesTotalMetric.Inc()
-	c.scrapesTotalMetric.Collect(ch)
-
 	c.lastScrapeErrorMetric.Set(errorMetric)
-	c.lastScrapeErrorMetric.Collect(ch)
-
+	c.lastScrapeTimeoutMetric.Set(timeoutMetric)
 	c.lastScrapeTimestampMetric.Set(float64(time.Now().Unix()))
-	c.lastScrapeTimestampMetric.Collect(ch)
-
 	c.lastScrapeDurationSecondsMetric.Set(time.Since(begun).Seconds())
-	c.lastScrapeDurationSecondsMetric.Collect(ch)
 }
 
-func (c *MonitoringCollector) reportMonitoringMetrics(ch chan<- prometheus.Metric, begun time.Time) error {
-	metricDescriptorsFunction := func(descriptors []*monitoring.MetricDescriptor) error {
+// contextCollector collects a MonitoringCollector with a context.
+type contextCollector struct {
+	ctx       context.Context
+	collector *MonitoringCollector
+}
+
+// WithContext returns a prometheus.Collector which collects c with ctx, ie to honour the scrape timeout of a request.
+func WithContext(ctx context.Context, c *MonitoringCollector) prometheus.Collector {
+	return &contextCollector{ctx: ctx, collector: c}
+}
+
+func (c *contextCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.collector.Describe(ch)
+}
+
+func (c *contextCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collector.CollectWithContext(c.ctx, ch)
+}
+
+// reportMonitoringMetrics sends the metrics of metricsTypePrefixes to ch and returns every error which occurred. A
+// failing metric type does not prevent the other ones from being reported.
+func (c *MonitoringCollector) reportMonitoringMetrics(ctx context.Context, ch chan<- prometheus.Metric, begun time.Time, metricsTypePrefixes []string) ScrapeErrors {
+	var (
+		errsMtx sync.Mutex
+		errs    ScrapeErrors
+	)
+	addError := func(prefix, metricType string, err error) {
+		errsMtx.Lock()
+		defer errsMtx.Unlock()
+		errs = append(errs, &ScrapeError{Prefix: prefix, MetricType: metricType, Err: err})
+	}
+
+	metricDescriptorsFunction := func(prefix string, descriptors []*monitoring.MetricDescriptor) {
 		var wg = &sync.WaitGroup{}
 
 		// It has been noticed that the same metric descriptor can be obtained from different GCP
@@ -272,32 +647,33 @@ func (c *MonitoringCollector) reportMonitoringMetrics(ch chan<- prometheus.Metri
 			uniqueDescriptors[descriptor.Type] = descriptor
 		}
 
-		errChannel := make(chan error, len(uniqueDescriptors))
-
-		endTime := time.Now().UTC().Add(c.metricsOffset * -1)
-		startTime := endTime.Add(c.metricsInterval * -1)
+		now := time.Now().UTC()
 
 		for _, metricDescriptor := range uniqueDescriptors {
+			settings := c.settingsFor(metricDescriptor.Type)
+			endTime := now.Add(settings.RequestOffset * -1)
+			startTime := endTime.Add(settings.RequestInterval * -1)
+
 			wg.Add(1)
-			go func(metricDescriptor *monitoring.MetricDescriptor, ch chan<- prometheus.Metric, startTime, endTime time.Time) {
+			go func(metricDescriptor *monitoring.MetricDescriptor, settings CollectionSettings, ch chan<- prometheus.Metric, startTime, endTime time.Time) {
 				defer wg.Done()
 				level.Debug(c.logger).Log("msg", "retrieving Google Stackdriver Monitoring metrics for descriptor", "descriptor", metricDescriptor.Type)
 				filter := fmt.Sprintf("metric.type=\"%s\"", metricDescriptor.Type)
-				if c.monitoringDropDelegatedProjects {
+				if settings.DropDelegatedProjects {
 					filter = fmt.Sprintf(
 						"project=\"%s\" AND metric.type=\"%s\"",
 						c.projectID,
 						metricDescriptor.Type)
 				}
 
-				if c.metricsIngestDelay &&
+				if settings.IngestDelay &&
 					metricDescriptor.Metadata != nil &&
 					metricDescriptor.Metadata.IngestDelay != "" {
 					ingestDelay := metricDescriptor.Metadata.IngestDelay
 					ingestDelayDuration, err := time.ParseDuration(ingestDelay)
 					if err != nil {
 						level.Error(c.logger).Log("msg", "error parsing ingest delay from metric metadata", "descriptor", metricDescriptor.Type, "err", err, "delay", ingestDelay)
-						errChannel <- err
+						addError(prefix, metricDescriptor.Type, err)
 						return
 					}
 					level.Debug(c.logger).Log("msg", "adding ingest delay", "descriptor", metricDescriptor.Type, "delay", ingestDelay)
@@ -317,21 +693,34 @@ func (c *MonitoringCollector) reportMonitoringMetrics(ch chan<- prometheus.Metri
 					Filter(filter).
 					IntervalStartTime(startTime.Format(time.RFC3339Nano)).
 					IntervalEndTime(endTime.Format(time.RFC3339Nano))
+				if settings.Aggregation != nil {
+					timeSeriesListCall = settings.Aggregation.apply(timeSeriesListCall)
+				}
 
+				stringValues := newStringValueLimiter(settings.StringValuesLimit)
 				for {
+					if err := ctx.Err(); err != nil {
+						addError(prefix, metricDescriptor.Type, err)
+						break
+					}
 					c.apiCallsTotalMetric.Inc()
-					page, err := timeSeriesListCall.Do()
+					page, err := timeSeriesListCall.Context(ctx).Do()
 					if err != nil {
-						level.Error(c.logger).Log("msg", "error retrieving Time Series metrics for descriptor", "descriptor", metricDescriptor.Type, "err", err)
-						errChannel <- err
+						if ctxErr := ctx.Err(); ctxErr != nil {
+							// The request was aborted because the collection was cut short
+							err = ctxErr
+						} else {
+							level.Error(c.logger).Log("msg", "error retrieving Time Series metrics for descriptor", "descriptor", metricDescriptor.Type, "err", err)
+						}
+						addError(prefix, metricDescriptor.Type, err)
 						break
 					}
 					if page == nil {
 						break
 					}
-					if err := c.reportTimeSeriesMetrics(page, metricDescriptor, ch, begun); err != nil {
+					if err := c.reportTimeSeriesMetrics(page, metricDescriptor, settings, stringValues, ch, begun); err != nil {
 						level.Error(c.logger).Log("msg", "error reporting Time Series metrics for descriptor", "descriptor", metricDescriptor.Type, "err", err)
-						errChannel <- err
+						addError(prefix, metricDescriptor.Type, err)
 						break
 					}
 					if page.NextPageToken == "" {
@@ -339,26 +728,23 @@ func (c *MonitoringCollector) reportMonitoringMetrics(ch chan<- prometheus.Metri
 					}
 					timeSeriesListCall.PageToken(page.NextPageToken)
 				}
-			}(metricDescriptor, ch, startTime, endTime)
+				if stringValues.dropped > 0 {
+					level.Warn(c.logger).Log("msg", "dropped STRING series above the limit of distinct values", "descriptor", metricDescriptor.Type, "limit", settings.StringValuesLimit, "dropped", stringValues.dropped)
+				}
+			}(metricDescriptor, settings, ch, startTime, endTime)
 		}
 
 		wg.Wait()
-		close(errChannel)
-
-		return <-errChannel
 	}
 
 	var wg = &sync.WaitGroup{}
 
-	errChannel := make(chan error, len(c.metricsTypePrefixes))
-
-	for _, metricsTypePrefix := range c.metricsTypePrefixes {
+	for _, metricsTypePrefix := range metricsTypePrefixes {
 		wg.Add(1)
 		go func(metricsTypePrefix string) {
 			defer wg.Done()
-			ctx := context.Background()
 			filter := fmt.Sprintf("metric.type = starts_with(\"%s\")", metricsTypePrefix)
-			if c.monitoringDropDelegatedProjects {
+			if c.settingsFor(metricsTypePrefix).DropDelegatedProjects {
 				filter = fmt.Sprintf(
 					"project = \"%s\" AND metric.type = starts_with(\"%s\")",
 					c.projectID,
@@ -367,23 +753,27 @@ func (c *MonitoringCollector) reportMonitoringMetrics(ch chan<- prometheus.Metri
 
 			if cached := c.descriptorCache.Lookup(metricsTypePrefix); cached != nil {
 				level.Debug(c.logger).Log("msg", "using cached Google Stackdriver Monitoring metric descriptors starting with", "prefix", metricsTypePrefix)
-				if err := metricDescriptorsFunction(cached); err != nil {
-					errChannel <- err
-				}
+				metricDescriptorsFunction(metricsTypePrefix, cached)
 			} else {
 				var cache []*monitoring.MetricDescriptor
 
 				callback := func(r *monitoring.ListMetricDescriptorsResponse) error {
 					c.apiCallsTotalMetric.Inc()
 					cache = append(cache, r.MetricDescriptors...)
-					return metricDescriptorsFunction(r.MetricDescriptors)
+					metricDescriptorsFunction(metricsTypePrefix, r.MetricDescriptors)
+					return nil
 				}
 
 				level.Debug(c.logger).Log("msg", "listing Google Stackdriver Monitoring metric descriptors starting with", "prefix", metricsTypePrefix)
 				if err := c.monitoringService.Projects.MetricDescriptors.List(utils.ProjectResource(c.projectID)).
 					Filter(filter).
 					Pages(ctx, callback); err != nil {
-					errChannel <- err
+					if ctxErr := ctx.Err(); ctxErr != nil {
+						err = ctxErr
+					}
+					addError(metricsTypePrefix, "", err)
+					// Do not cache a partial list of descriptors
+					return
 				}
 
 				c.descriptorCache.Store(metricsTypePrefix, cache)
@@ -392,15 +782,39 @@ func (c *MonitoringCollector) reportMonitoringMetrics(ch chan<- prometheus.Metri
 	}
 
 	wg.Wait()
-	close(errChannel)
+	c.sampleTracker.prune(begun.Add(-sampleTrackerRetention))
+
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].Prefix != errs[j].Prefix {
+			return errs[i].Prefix < errs[j].Prefix
+		}
+		return errs[i].MetricType < errs[j].MetricType
+	})
 
 	level.Debug(c.logger).Log("msg", "Done reporting monitoring metrics")
-	return <-errChannel
+	return errs
+}
+
+// LastScrapeErrors returns the errors of the last collection of every metric type prefix which failed.
+func (c *MonitoringCollector) LastScrapeErrors() []PrefixErrors {
+	c.lastErrorsMtx.Lock()
+	defer c.lastErrorsMtx.Unlock()
+
+	prefixErrors := make([]PrefixErrors, 0, len(c.lastErrors))
+	for _, errs := range c.lastErrors {
+		prefixErrors = append(prefixErrors, errs)
+	}
+	sort.Slice(prefixErrors, func(i, j int) bool {
+		return prefixErrors[i].Prefix < prefixErrors[j].Prefix
+	})
+	return prefixErrors
 }
 
 func (c *MonitoringCollector) reportTimeSeriesMetrics(
 	page *monitoring.ListTimeSeriesResponse,
 	metricDescriptor *monitoring.MetricDescriptor,
+	settings CollectionSettings,
+	stringValues *stringValueLimiter,
 	ch chan<- prometheus.Metric,
 	begun time.Time,
 ) error {
@@ -413,11 +827,31 @@ func (c *MonitoringCollector) reportTimeSeriesMetrics(
 		c.collectorFillMissingLabels,
 		c.counterStore,
 		c.histogramStore,
-		c.aggregateDeltas,
+		settings.AggregateDeltas,
 	)
 	if err != nil {
 		return fmt.Errorf("error creating the TimeSeriesMetrics %v", err)
 	}
+	if settings.Aggregation != nil {
+		timeSeriesMetrics.fqNameSuffix = settings.Aggregation.metricNameSuffix()
+	}
+	if metricDescriptor.ValueType == "STRING" {
+		timeSeriesMetrics.fqNameSuffix = strings.TrimPrefix(timeSeriesMetrics.fqNameSuffix+"_"+infoSuffix, "_")
+	}
+	timeSeriesMetrics.nameTemplate = c.metricNameTemplate
+	unitLabelValue := metricDescriptor.Unit
+	if c.baseUnits {
+		if unit, ok := metricBaseUnit(metricDescriptor); ok {
+			timeSeriesMetrics.unit = &unit
+			unitLabelValue = unit.name
+		}
+	}
+	relabelRules := c.relabelRulesFor(metricDescriptor.Type)
+	projectIDKey := "project_id"
+	if c.labelCollisions == PrefixLabels {
+		projectIDKey = "resource_project_id"
+	}
+	var reported []*monitoring.TimeSeries
 	for _, timeSeries := range page.TimeSeries {
 		newestEndTime := time.Unix(0, 0)
 		for _, point := range timeSeries.Points {
@@ -430,32 +864,57 @@ func (c *MonitoringCollector) reportTimeSeriesMetrics(
 				newestTSPoint = point
 			}
 		}
-		labelKeys := []string{"unit"}
-		labelValues := []string{metricDescriptor.Unit}
+		startTime, err := pointStartTime(timeSeries.MetricKind, newestTSPoint)
+		if err != nil {
+			return err
+		}
+		var labelKeys, labelValues []string
+		if c.unitLabel {
+			labelKeys = append(labelKeys, "unit")
+			labelValues = append(labelValues, unitLabelValue)
+		}
+		// Time series of different resource types share the metric name, tell them apart
+		if !c.metricNameTemplate.HasResourceType() {
+			labelKeys = append(labelKeys, resourceTypeLabel)
+			labelValues = append(labelValues, timeSeries.Resource.Type)
+		}
+		if timeSeriesMetrics.unit != nil {
+			c.recordMetricUnit(timeSeriesMetrics.fqName(timeSeries), timeSeriesMetrics.unit.name)
+		}
 
 		// Add the metric labels
 		// @see https://cloud.google.com/monitoring/api/metrics
-		for key, value := range timeSeries.Metric.Labels {
-			if !c.keyExists(labelKeys, key) {
-				labelKeys = append(labelKeys, key)
-				labelValues = append(labelValues, value)
-			}
-		}
+		labelKeys, labelValues = c.appendLabels(labelKeys, labelValues, timeSeries.Metric.Labels, "metric", metricDescriptor.Type)
 
 		// Add the monitored resource labels
 		// @see https://cloud.google.com/monitoring/api/resources
-		for key, value := range timeSeries.Resource.Labels {
-			if !c.keyExists(labelKeys, key) {
-				labelKeys = append(labelKeys, key)
-				labelValues = append(labelValues, value)
+		labelKeys, labelValues = c.appendLabels(labelKeys, labelValues, timeSeries.Resource.Labels, "resource", metricDescriptor.Type)
+
+		// Add the selected metadata labels, which are already namespaced
+		// @see https://cloud.google.com/monitoring/api/ref_v3/rest/v3/projects.timeSeries#MonitoredResourceMetadata
+		if !c.metadataSystemLabels.empty() || !c.metadataUserLabels.empty() {
+			metadataKeys, metadataValues, err := metadataLabels(timeSeries.Metadata, c.metadataSystemLabels, c.metadataUserLabels)
+			if err != nil {
+				level.Debug(c.logger).Log("msg", "discarding metadata labels", "metric", timeSeries.Metric.Type, "err", err)
+			}
+			for i, key := range metadataKeys {
+				labelKeys, labelValues = c.appendLabel(labelKeys, labelValues, key, metadataValues[i], "metadata", metricDescriptor.Type)
 			}
 		}
 
-		if c.monitoringDropDelegatedProjects {
+		// Add the labels of the Compute Engine instance found in the inventory
+		if c.inventoryLabels && timeSeries.Resource.Type == "gce_instance" {
+			keys, values := c.inventory.InstanceLabels(timeSeries.Resource.Labels["project_id"], timeSeries.Resource.Labels["instance_id"])
+			for i, key := range keys {
+				labelKeys, labelValues = c.appendLabel(labelKeys, labelValues, key, values[i], "inventory", metricDescriptor.Type)
+			}
+		}
+
+		if settings.DropDelegatedProjects {
 			dropDelegatedProject := false
 
 			for idx, val := range labelKeys {
-				if val == "project_id" && labelValues[idx] != c.projectID {
+				if val == projectIDKey && labelValues[idx] != c.projectID {
 					dropDelegatedProject = true
 					break
 				}
@@ -466,11 +925,41 @@ func (c *MonitoringCollector) reportTimeSeriesMetrics(
 			}
 		}
 
+		// The labels holding the currency of MONEY points and the value of STRING points are relabeled too
+		switch timeSeries.ValueType {
+		case "MONEY":
+			if !c.keyExists(labelKeys, currencyLabel) {
+				labelKeys = append(labelKeys, currencyLabel)
+				labelValues = append(labelValues, currencyFromUnit(metricDescriptor.Unit))
+			}
+		case "STRING":
+			// STRING metrics are exported as info-style gauges holding the value as a label
+			if newestTSPoint.Value.StringValue == nil {
+				continue
+			}
+			// The value label cannot be dropped as it tells the series apart, so it is prefixed until its key is free
+			valueLabel := stringValueLabel
+			for c.keyExists(labelKeys, valueLabel) {
+				valueLabel = "string_" + valueLabel
+			}
+			labelKeys = append(labelKeys, valueLabel)
+			labelValues = append(labelValues, *newestTSPoint.Value.StringValue)
+		}
+
+		if len(relabelRules) > 0 {
+			var keep bool
+			labelKeys, labelValues, keep = relabelSeries(timeSeries, labelKeys, labelValues, relabelRules)
+			if !keep {
+				continue
+			}
+		}
+		reported = append(reported, timeSeries)
+
 		switch timeSeries.MetricKind {
 		case "GAUGE":
 			metricValueType = prometheus.GaugeValue
 		case "DELTA":
-			if c.aggregateDeltas {
+			if settings.AggregateDeltas {
 				metricValueType = prometheus.CounterValue
 			} else {
 				metricValueType = prometheus.GaugeValue
@@ -481,6 +970,8 @@ func (c *MonitoringCollector) reportTimeSeriesMetrics(
 			continue
 		}
 
+		reportSamples := settings.AllPoints && c.sampleSink != nil && !(timeSeries.MetricKind == "DELTA" && settings.AggregateDeltas)
+
 		switch timeSeries.ValueType {
 		case "BOOL":
 			metricValue = 0
@@ -491,12 +982,38 @@ func (c *MonitoringCollector) reportTimeSeriesMetrics(
 			metricValue = float64(*newestTSPoint.Value.Int64Value)
 		case "DOUBLE":
 			metricValue = *newestTSPoint.Value.DoubleValue
+		case "MONEY":
+			// The API has no money value, the amount is returned as a double or an integer in the currency of the unit
+			switch {
+			case newestTSPoint.Value.DoubleValue != nil:
+				metricValue = *newestTSPoint.Value.DoubleValue
+			case newestTSPoint.Value.Int64Value != nil:
+				metricValue = float64(*newestTSPoint.Value.Int64Value)
+			default:
+				continue
+			}
+		case "STRING":
+			if !stringValues.allow(*newestTSPoint.Value.StringValue) {
+				continue
+			}
+			timeSeriesMetrics.CollectNewConstMetric(timeSeries, newestEndTime, time.Time{}, labelKeys, prometheus.GaugeValue, 1, labelValues, "GAUGE")
+			continue
 		case "DISTRIBUTION":
 			dist := newestTSPoint.Value.DistributionValue
 			buckets, err := c.generateHistogramBuckets(dist)
 
 			if err == nil {
-				timeSeriesMetrics.CollectNewConstHistogram(timeSeries, newestEndTime, labelKeys, dist, buckets, labelValues, timeSeries.MetricKind)
+				var nativeSchema *int32
+				// Converted bounds no longer match the boundaries of a native histogram schema
+				if settings.NativeHistograms && (timeSeriesMetrics.unit == nil || timeSeriesMetrics.unit.scale == 1) {
+					nativeSchema = nativeHistogramSchema(dist.BucketOptions)
+				}
+				timeSeriesMetrics.CollectNewConstHistogram(timeSeries, newestEndTime, startTime, labelKeys, dist, buckets, nativeSchema, labelValues, timeSeries.MetricKind)
+				if reportSamples {
+					if err := c.reportSamples(timeSeriesMetrics, timeSeries, labelKeys, labelValues, metricValueType, begun); err != nil {
+						return err
+					}
+				}
 			} else {
 				level.Debug(c.logger).Log("msg", "discarding", "resource", timeSeries.Resource.Type, "metric",
 					timeSeries.Metric.Type, "err", err)
@@ -507,12 +1024,116 @@ func (c *MonitoringCollector) reportTimeSeriesMetrics(
 			continue
 		}
 
-		timeSeriesMetrics.CollectNewConstMetric(timeSeries, newestEndTime, labelKeys, metricValueType, metricValue, labelValues, timeSeries.MetricKind)
+		timeSeriesMetrics.CollectNewConstMetric(timeSeries, newestEndTime, startTime, labelKeys, metricValueType, metricValue, labelValues, timeSeries.MetricKind)
+		if reportSamples {
+			if err := c.reportSamples(timeSeriesMetrics, timeSeries, labelKeys, labelValues, metricValueType, begun); err != nil {
+				return err
+			}
+		}
 	}
 	timeSeriesMetrics.Complete(begun)
+	if c.timeSeriesSink != nil && len(reported) > 0 {
+		c.timeSeriesSink.AppendTimeSeries(c.projectID, metricDescriptor, settings, reported)
+	}
 	return nil
 }
 
+// appendLabels appends the metric or resource labels of a series, given by kind, and handles the keys which are
+// already used according to the label collisions strategy.
+func (c *MonitoringCollector) appendLabels(labelKeys, labelValues []string, labels map[string]string, kind, metricType string) ([]string, []string) {
+	for key, value := range labels {
+		if c.labelCollisions == PrefixLabels {
+			key = kind + "_" + key
+		}
+		labelKeys, labelValues = c.appendLabel(labelKeys, labelValues, key, value, kind, metricType)
+	}
+	return labelKeys, labelValues
+}
+
+// appendLabel appends a label unless its key is already used, in which case it is dropped or suffixed with its kind.
+// A label with the same key and value as an existing one is not a collision, as nothing is lost.
+func (c *MonitoringCollector) appendLabel(labelKeys, labelValues []string, key, value, kind, metricType string) ([]string, []string) {
+	for i, existing := range labelKeys {
+		if existing == key && labelValues[i] == value {
+			return labelKeys, labelValues
+		}
+	}
+	if c.keyExists(labelKeys, key) {
+		suffixed := key + "_" + kind
+		if c.labelCollisions != SuffixLabelCollisions || c.keyExists(labelKeys, suffixed) {
+			c.labelCollisionsTotalMetric.WithLabelValues(metricType, "dropped").Inc()
+			return labelKeys, labelValues
+		}
+		c.labelCollisionsTotalMetric.WithLabelValues(metricType, "renamed").Inc()
+		key = suffixed
+	}
+	return append(labelKeys, key), append(labelValues, value)
+}
+
+// relabelRulesFor returns the rules of every relabel config matching metricType, in order.
+func (c *MonitoringCollector) relabelRulesFor(metricType string) []*relabel.Config {
+	var rules []*relabel.Config
+	for _, rc := range c.relabelConfigs {
+		if strings.HasPrefix(metricType, rc.Prefix) {
+			rules = append(rules, rc.Rules...)
+		}
+	}
+	return rules
+}
+
+// relabelSeries applies the rules to the labels of timeSeries, with the metric type and the resource type available as
+// meta labels. Like in Prometheus, labels starting with `__` are removed afterwards.
+func relabelSeries(timeSeries *monitoring.TimeSeries, labelKeys, labelValues []string, rules []*relabel.Config) ([]string, []string, bool) {
+	keys := append([]string{metricTypeMetaLabel, resourceTypeMetaLabel}, labelKeys...)
+	values := append([]string{timeSeries.Metric.Type, timeSeries.Resource.Type}, labelValues...)
+	keys, values, keep := relabel.Process(keys, values, rules...)
+	if !keep {
+		return nil, nil, false
+	}
+
+	labelKeys, labelValues = labelKeys[:0], labelValues[:0]
+	for i, key := range keys {
+		if !strings.HasPrefix(key, model.ReservedLabelPrefix) {
+			labelKeys = append(labelKeys, key)
+			labelValues = append(labelValues, values[i])
+		}
+	}
+	return labelKeys, labelValues, true
+}
+
+// recordMetricUnit records the base unit of the metric named fqName.
+func (c *MonitoringCollector) recordMetricUnit(fqName, unit string) {
+	c.metricUnitsMtx.Lock()
+	defer c.metricUnitsMtx.Unlock()
+	c.metricUnits[fqName] = unit
+}
+
+// MetricUnits returns the base unit of every metric reported with BaseUnits, by metric name, ie to expose them as
+// OpenMetrics UNIT metadata.
+func (c *MonitoringCollector) MetricUnits() map[string]string {
+	c.metricUnitsMtx.Lock()
+	defer c.metricUnitsMtx.Unlock()
+
+	units := make(map[string]string, len(c.metricUnits))
+	for fqName, unit := range c.metricUnits {
+		units[fqName] = unit
+	}
+	return units
+}
+
+// pointStartTime returns the start of the interval of a CUMULATIVE or DELTA point, which is exposed as created
+// timestamp. It is zero for GAUGE points, whose interval is a single instant.
+func pointStartTime(metricKind string, point *monitoring.Point) (time.Time, error) {
+	if metricKind == "GAUGE" || point.Interval.StartTime == "" {
+		return time.Time{}, nil
+	}
+	startTime, err := time.Parse(time.RFC3339Nano, point.Interval.StartTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Error parsing TimeSeries Point interval start time `%s`: %s", point.Interval.StartTime, err)
+	}
+	return startTime, nil
+}
+
 func (c *MonitoringCollector) generateHistogramBuckets(
 	dist *monitoring.Distribution,
 ) (map[float64]uint64, error) {
@@ -13,7 +13,19 @@
 
 package collectors
 
-import "testing"
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/promlog"
+	"google.golang.org/api/monitoring/v3"
+
+	"github.com/prometheus-community/stackdriver_exporter/relabel"
+)
 
 func TestIsGoogleMetric(t *testing.T) {
 	good := []string{
@@ -37,3 +49,244 @@ func TestIsGoogleMetric(t *testing.T) {
 		}
 	}
 }
+
+func TestSettingsFor(t *testing.T) {
+	defaults := CollectionSettings{RequestInterval: 5 * time.Minute}
+	bigquery := CollectionSettings{RequestInterval: 20 * time.Minute, IngestDelay: true}
+	cpu := CollectionSettings{RequestInterval: time.Minute, AggregateDeltas: true}
+
+	collector := &MonitoringCollector{
+		defaultSettings: defaults,
+		collectionOverrides: []CollectionOverride{
+			{Match: "bigquery.googleapis.com/", Settings: bigquery},
+			{Match: "compute.googleapis.com/instance/*/usage_time", Settings: cpu},
+		},
+	}
+
+	for metricType, expected := range map[string]CollectionSettings{
+		"bigquery.googleapis.com/query/count":             bigquery,
+		"compute.googleapis.com/instance/cpu/usage_time":  cpu,
+		"compute.googleapis.com/instance/cpu/utilization": defaults,
+		"compute.googleapis.com/instance/disk/write_ops":  defaults,
+	} {
+		if settings := collector.settingsFor(metricType); settings != expected {
+			t.Errorf("unexpected settings for %s: expected %+v, got %+v", metricType, expected, settings)
+		}
+	}
+}
+
+func TestAggregationMetricNameSuffix(t *testing.T) {
+	for expected, aggregation := range map[string]Aggregation{
+		"align_rate":            {PerSeriesAligner: "ALIGN_RATE"},
+		"align_rate_reduce_sum": {PerSeriesAligner: "ALIGN_RATE", CrossSeriesReducer: "REDUCE_SUM"},
+		"align_delta":           {PerSeriesAligner: "ALIGN_DELTA", CrossSeriesReducer: "REDUCE_NONE"},
+		"":                      {PerSeriesAligner: "ALIGN_NONE"},
+	} {
+		if suffix := aggregation.metricNameSuffix(); suffix != expected {
+			t.Errorf("expected suffix %q for %+v, got %q", expected, aggregation, suffix)
+		}
+	}
+}
+
+func TestCreatedTimestamps(t *testing.T) {
+	c, err := NewMonitoringCollector("project", nil, MonitoringCollectorOptions{}, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	interval := &monitoring.TimeInterval{StartTime: start.Format(time.RFC3339Nano), EndTime: end.Format(time.RFC3339Nano)}
+	value := 3.0
+
+	for name, tc := range map[string]struct {
+		metricKind string
+		valueType  string
+		value      *monitoring.TypedValue
+		created    bool
+	}{
+		"cumulative counter": {"CUMULATIVE", "DOUBLE", &monitoring.TypedValue{DoubleValue: &value}, true},
+		"cumulative histogram": {"CUMULATIVE", "DISTRIBUTION", &monitoring.TypedValue{DistributionValue: &monitoring.Distribution{
+			Count:         1,
+			BucketOptions: &monitoring.BucketOptions{ExplicitBuckets: &monitoring.Explicit{Bounds: []float64{1}}},
+			BucketCounts:  []int64{1},
+		}}, true},
+		"delta gauge": {"DELTA", "DOUBLE", &monitoring.TypedValue{DoubleValue: &value}, false},
+		"gauge":       {"GAUGE", "DOUBLE", &monitoring.TypedValue{DoubleValue: &value}, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			descriptor := &monitoring.MetricDescriptor{Type: "test.googleapis.com/usage", MetricKind: tc.metricKind, ValueType: tc.valueType}
+			page := &monitoring.ListTimeSeriesResponse{TimeSeries: []*monitoring.TimeSeries{{
+				Metric:     &monitoring.Metric{Type: descriptor.Type},
+				Resource:   &monitoring.MonitoredResource{Type: "gce_instance"},
+				MetricKind: tc.metricKind,
+				ValueType:  tc.valueType,
+				Points:     []*monitoring.Point{{Interval: interval, Value: tc.value}},
+			}}}
+			ch := make(chan prometheus.Metric, 1)
+			if err := c.reportTimeSeriesMetrics(page, descriptor, CollectionSettings{}, newStringValueLimiter(0), ch, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+
+			out := &dto.Metric{}
+			if err := (<-ch).Write(out); err != nil {
+				t.Fatal(err)
+			}
+			created := out.GetCounter().GetCreatedTimestamp()
+			if out.Histogram != nil {
+				created = out.Histogram.GetCreatedTimestamp()
+			}
+			if !tc.created {
+				if created != nil {
+					t.Errorf("expected no created timestamp, got %v", created.AsTime())
+				}
+				return
+			}
+			if created == nil || !created.AsTime().Equal(start) {
+				t.Errorf("expected created timestamp %s, got %v", start, created)
+			}
+			if out.GetTimestampMs() != end.UnixMilli() {
+				t.Errorf("expected timestamp %d, got %d", end.UnixMilli(), out.GetTimestampMs())
+			}
+		})
+	}
+}
+
+func TestMetricNameTemplate(t *testing.T) {
+	c, err := NewMonitoringCollector("project", nil, MonitoringCollectorOptions{MetricNameTemplate: "gcp_{service}_{metric_path}"}, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptor := &monitoring.MetricDescriptor{Type: "logging.googleapis.com/log_entry_count", MetricKind: "GAUGE", ValueType: "DOUBLE"}
+	value := 1.0
+	instance := newTimeSeries(descriptor.Type, "DOUBLE", &monitoring.TypedValue{DoubleValue: &value}, nil)
+	cluster := newTimeSeries(descriptor.Type, "DOUBLE", &monitoring.TypedValue{DoubleValue: &value}, nil)
+	cluster.Resource.Type = "k8s_cluster"
+
+	ch := make(chan prometheus.Metric, 10)
+	page := &monitoring.ListTimeSeriesResponse{TimeSeries: []*monitoring.TimeSeries{instance, cluster}}
+	if err := c.reportTimeSeriesMetrics(page, descriptor, CollectionSettings{}, newStringValueLimiter(0), ch, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+
+	resourceTypes := map[string]bool{}
+	for m := range ch {
+		if want := `fqName: "gcp_logging_log_entry_count"`; !strings.Contains(m.Desc().String(), want) {
+			t.Errorf("expected %s, got %s", want, m.Desc())
+		}
+		out := &dto.Metric{}
+		if err := m.Write(out); err != nil {
+			t.Fatal(err)
+		}
+		resourceTypes[labelValue(out, resourceTypeLabel)] = true
+	}
+	if !resourceTypes["gce_instance"] || !resourceTypes["k8s_cluster"] {
+		t.Errorf("expected the resource types as label, got %v", resourceTypes)
+	}
+
+	if _, err := NewMonitoringCollector("project", nil, MonitoringCollectorOptions{MetricNameTemplate: "gcp_{resource}"}, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{}); err == nil {
+		t.Error("expected an invalid template to be rejected")
+	}
+}
+
+func TestRelabelSeries(t *testing.T) {
+	rules := []*relabel.Config{
+		{SourceLabels: []string{"__resource_type__", "instance_id"}, Separator: ";", Regex: relabel.MustNewRegexp("gce_instance;2"), Action: relabel.Drop},
+		{SourceLabels: []string{"__metric_type__"}, Regex: relabel.MustNewRegexp("test.googleapis.com/(.*)"), TargetLabel: "metric", Replacement: "$1", Action: relabel.Replace},
+		{Regex: relabel.MustNewRegexp("zone"), Action: relabel.LabelDrop},
+	}
+	c, err := NewMonitoringCollector("project", nil, MonitoringCollectorOptions{
+		RelabelConfigs: []RelabelConfig{{Prefix: "test.googleapis.com/", Rules: rules}, {Prefix: "other.googleapis.com/", Rules: rules[:1]}},
+	}, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptor := &monitoring.MetricDescriptor{Type: "test.googleapis.com/requests", MetricKind: "GAUGE", ValueType: "DOUBLE"}
+	value := 1.0
+	kept := newTimeSeries(descriptor.Type, "DOUBLE", &monitoring.TypedValue{DoubleValue: &value}, map[string]string{"zone": "a"})
+	dropped := newTimeSeries(descriptor.Type, "DOUBLE", &monitoring.TypedValue{DoubleValue: &value}, map[string]string{"zone": "a"})
+	dropped.Resource.Labels = map[string]string{"instance_id": "2"}
+
+	ch := make(chan prometheus.Metric, 10)
+	page := &monitoring.ListTimeSeriesResponse{TimeSeries: []*monitoring.TimeSeries{kept, dropped}}
+	if err := c.reportTimeSeriesMetrics(page, descriptor, CollectionSettings{}, newStringValueLimiter(0), ch, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+
+	var metrics []*dto.Metric
+	for m := range ch {
+		out := &dto.Metric{}
+		if err := m.Write(out); err != nil {
+			t.Fatal(err)
+		}
+		metrics = append(metrics, out)
+	}
+	if len(metrics) != 1 {
+		t.Fatalf("expected the second series to be dropped, got %v", metrics)
+	}
+	for _, l := range metrics[0].Label {
+		if l.GetName() == "zone" || strings.HasPrefix(l.GetName(), "__") {
+			t.Errorf("unexpected label %s", l.GetName())
+		}
+	}
+	if v := labelValue(metrics[0], "metric"); v != "requests" {
+		t.Errorf("expected the metric label to be set from the metric type, got %q", v)
+	}
+	if len(c.relabelRulesFor("other.googleapis.com/requests")) != 1 || len(c.relabelRulesFor("unrelated.googleapis.com/requests")) != 0 {
+		t.Error("expected the rules to only apply to matching metric types")
+	}
+}
+
+func TestLabelCollisions(t *testing.T) {
+	descriptor := &monitoring.MetricDescriptor{Type: "test.googleapis.com/requests", MetricKind: "GAUGE", ValueType: "DOUBLE"}
+	value := 1.0
+
+	for strategy, tc := range map[string]struct {
+		expected map[string]string
+		action   string
+	}{
+		DropLabelCollisions:   {map[string]string{"instance_id": "metric"}, "dropped"},
+		SuffixLabelCollisions: {map[string]string{"instance_id": "metric", "instance_id_resource": "resource"}, "renamed"},
+		PrefixLabels:          {map[string]string{"metric_instance_id": "metric", "resource_instance_id": "resource"}, ""},
+	} {
+		t.Run(strategy, func(t *testing.T) {
+			c, err := NewMonitoringCollector("project", nil, MonitoringCollectorOptions{LabelCollisions: strategy}, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts := newTimeSeries(descriptor.Type, "DOUBLE", &monitoring.TypedValue{DoubleValue: &value}, map[string]string{"instance_id": "metric"})
+			ts.Resource.Labels = map[string]string{"instance_id": "resource"}
+
+			ch := make(chan prometheus.Metric, 10)
+			page := &monitoring.ListTimeSeriesResponse{TimeSeries: []*monitoring.TimeSeries{ts}}
+			if err := c.reportTimeSeriesMetrics(page, descriptor, CollectionSettings{}, newStringValueLimiter(0), ch, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			close(ch)
+
+			out := &dto.Metric{}
+			if err := (<-ch).Write(out); err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range tc.expected {
+				if v := labelValue(out, key); v != value {
+					t.Errorf("expected label %s=%q, got %q", key, value, v)
+				}
+			}
+			for _, action := range []string{"dropped", "renamed"} {
+				expected := 0.0
+				if action == tc.action {
+					expected = 1
+				}
+				if v := testutil.ToFloat64(c.labelCollisionsTotalMetric.WithLabelValues(descriptor.Type, action)); v != expected {
+					t.Errorf("expected %v %s collisions, got %v", expected, action, v)
+				}
+			}
+		})
+	}
+
+	if _, err := NewMonitoringCollector("project", nil, MonitoringCollectorOptions{LabelCollisions: "rename"}, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{}); err == nil {
+		t.Error("expected an unknown strategy to be rejected")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promlog"
+	"golang.org/x/net/context"
+	"google.golang.org/api/monitoring/v3"
+	"google.golang.org/api/option"
+)
+
+// newTestMonitoringService returns a monitoring.Service sending its requests to handler.
+func newTestMonitoringService(t *testing.T, handler http.Handler) *monitoring.Service {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	service, err := monitoring.NewService(context.Background(),
+		option.WithEndpoint(server.URL),
+		option.WithHTTPClient(server.Client()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+// noopDeltaStore is a DeltaCounterStore which never reports any metric.
+type noopDeltaStore struct{}
+
+func (noopDeltaStore) Increment(*monitoring.MetricDescriptor, *ConstMetric) {}
+
+func (noopDeltaStore) ListMetrics(string) []*ConstMetric { return nil }
+
+// noopDeltaHistogramStore is a DeltaHistogramStore which never reports any metric.
+type noopDeltaHistogramStore struct{}
+
+func (noopDeltaHistogramStore) Increment(*monitoring.MetricDescriptor, *HistogramMetric) {}
+
+func (noopDeltaHistogramStore) ListMetrics(string) []*HistogramMetric { return nil }
+
+func TestCollectWithContextTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	service := newTestMonitoringService(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/metricDescriptors"):
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"metricDescriptors": [{"name": "slow", "type": "slow.googleapis.com/metric", "metricKind": "GAUGE", "valueType": "DOUBLE"}]}`))
+		case strings.HasSuffix(r.URL.Path, "/timeSeries"):
+			select {
+			case <-r.Context().Done():
+			case <-unblock:
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	collector, err := NewMonitoringCollector("project", service, MonitoringCollectorOptions{
+		MetricTypePrefixes: []string{"slow.googleapis.com"},
+		RequestInterval:    time.Minute,
+	}, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(WithContext(ctx, collector))
+
+	begun := time.Now()
+	if _, err := registry.Gather(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begun); elapsed > 5*time.Second {
+		t.Errorf("expected the collection to stop at the deadline, took %s", elapsed)
+	}
+	if v := testutil.ToFloat64(collector.lastScrapeTimeoutMetric); v != 1 {
+		t.Errorf("expected the last scrape to be flagged as timed out, got %v", v)
+	}
+	if v := testutil.ToFloat64(collector.lastScrapeErrorMetric); v != 1 {
+		t.Errorf("expected the last scrape to be flagged as failed, got %v", v)
+	}
+}
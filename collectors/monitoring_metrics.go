@@ -17,7 +17,9 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"google.golang.org/api/monitoring/v3"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"sort"
 
@@ -25,14 +27,6 @@ import (
 	"github.com/prometheus-community/stackdriver_exporter/utils"
 )
 
-func buildFQName(timeSeries *monitoring.TimeSeries) string {
-	// The metric name to report is composed by the 3 parts:
-	// 1. namespace is a constant prefix (stackdriver)
-	// 2. subsystem is the monitored resource type (ie gce_instance)
-	// 3. name is the metric type (ie compute.googleapis.com/instance/cpu/usage_time)
-	return prometheus.BuildFQName(namespace, utils.NormalizeMetricName(timeSeries.Resource.Type), utils.NormalizeMetricName(timeSeries.Metric.Type))
-}
-
 type timeSeriesMetrics struct {
 	metricDescriptor *monitoring.MetricDescriptor
 
@@ -45,6 +39,13 @@ type timeSeriesMetrics struct {
 	counterStore    DeltaCounterStore
 	histogramStore  DeltaHistogramStore
 	aggregateDeltas bool
+
+	// fqNameSuffix is appended to the name of every metric, ie to reflect a server-side aggregation.
+	fqNameSuffix string
+	// unit, when set, is the base unit the values are converted to. It is appended to the name of every metric.
+	unit *baseUnit
+	// nameTemplate builds the name of every metric.
+	nameTemplate *utils.MetricNameTemplate
 }
 
 func newTimeSeriesMetrics(descriptor *monitoring.MetricDescriptor,
@@ -66,6 +67,30 @@ func newTimeSeriesMetrics(descriptor *monitoring.MetricDescriptor,
 	}, nil
 }
 
+func (t *timeSeriesMetrics) fqName(timeSeries *monitoring.TimeSeries) string {
+	unit := ""
+	if t.unit != nil {
+		unit = t.unit.name
+	}
+	return t.nameTemplate.Name(timeSeries.Resource.Type, timeSeries.Metric.Type, t.fqNameSuffix, unit)
+}
+
+// scale converts a value to the base unit of the metrics, if any.
+func (t *timeSeriesMetrics) scale(value float64) float64 {
+	if t.unit == nil {
+		return value
+	}
+	return value * t.unit.scale
+}
+
+// scaleBuckets converts the bucket bounds of a histogram to the base unit of the metrics, if any.
+func (t *timeSeriesMetrics) scaleBuckets(buckets map[float64]uint64) map[float64]uint64 {
+	if t.unit == nil {
+		return buckets
+	}
+	return t.unit.scaleBuckets(buckets)
+}
+
 func (t *timeSeriesMetrics) newMetricDesc(fqName string, labelKeys []string) *prometheus.Desc {
 	return prometheus.NewDesc(
 		fqName,
@@ -83,6 +108,9 @@ type ConstMetric struct {
 	LabelValues    []string
 	ReportTime     time.Time
 	CollectionTime time.Time
+	// StartTime is the start of the interval the value was accumulated over, exposed as created timestamp of
+	// counters. It is zero when unknown.
+	StartTime time.Time
 
 	KeysHash uint64
 }
@@ -90,30 +118,40 @@ type ConstMetric struct {
 type HistogramMetric struct {
 	FqName         string
 	LabelKeys      []string
-	Mean           float64
+	Sum            float64
 	Count          uint64
 	Buckets        map[float64]uint64
 	LabelValues    []string
 	ReportTime     time.Time
 	CollectionTime time.Time
+	// StartTime is the start of the interval the distribution was accumulated over, exposed as created timestamp. It
+	// is zero when unknown.
+	StartTime time.Time
 
 	KeysHash uint64
+	// NativeSchema is the native histogram schema matching the buckets, nil when it is exposed as a classic histogram
+	// only.
+	NativeSchema *int32
 }
 
-func (t *timeSeriesMetrics) CollectNewConstHistogram(timeSeries *monitoring.TimeSeries, reportTime time.Time, labelKeys []string, dist *monitoring.Distribution, buckets map[float64]uint64, labelValues []string, metricKind string) {
-	fqName := buildFQName(timeSeries)
+func (t *timeSeriesMetrics) CollectNewConstHistogram(timeSeries *monitoring.TimeSeries, reportTime, startTime time.Time, labelKeys []string, dist *monitoring.Distribution, buckets map[float64]uint64, nativeSchema *int32, labelValues []string, metricKind string) {
+	fqName := t.fqName(timeSeries)
+	sum := t.scale(dist.Mean * float64(dist.Count))
+	buckets = t.scaleBuckets(buckets)
 
 	var v HistogramMetric
 	if t.fillMissingLabels || (metricKind == "DELTA" && t.aggregateDeltas) {
 		v = HistogramMetric{
 			FqName:         fqName,
 			LabelKeys:      labelKeys,
-			Mean:           dist.Mean,
+			Sum:            sum,
 			Count:          uint64(dist.Count),
 			Buckets:        buckets,
+			NativeSchema:   nativeSchema,
 			LabelValues:    labelValues,
 			ReportTime:     reportTime,
 			CollectionTime: time.Now(),
+			StartTime:      startTime,
 
 			KeysHash: hashLabelKeys(labelKeys),
 		}
@@ -133,24 +171,45 @@ func (t *timeSeriesMetrics) CollectNewConstHistogram(timeSeries *monitoring.Time
 		return
 	}
 
-	t.ch <- t.newConstHistogram(fqName, reportTime, labelKeys, dist.Mean, uint64(dist.Count), buckets, labelValues)
+	t.ch <- t.newConstHistogram(fqName, reportTime, startTime, labelKeys, sum, uint64(dist.Count), buckets, nativeSchema, labelValues)
 }
 
-func (t *timeSeriesMetrics) newConstHistogram(fqName string, reportTime time.Time, labelKeys []string, mean float64, count uint64, buckets map[float64]uint64, labelValues []string) prometheus.Metric {
-	return prometheus.NewMetricWithTimestamp(
-		reportTime,
-		prometheus.MustNewConstHistogram(
-			t.newMetricDesc(fqName, labelKeys),
-			count,
-			mean*float64(count), // Stackdriver does not provide the sum, but we can fake it
-			buckets,
-			labelValues...,
-		),
+// newConstHistogram returns a histogram with the given sum. Stackdriver does not provide the sum, but it can be derived
+// from the mean and count of a distribution. When nativeSchema is set the histogram is also a native histogram.
+func (t *timeSeriesMetrics) newConstHistogram(fqName string, reportTime, startTime time.Time, labelKeys []string, sum float64, count uint64, buckets map[float64]uint64, nativeSchema *int32, labelValues []string) prometheus.Metric {
+	histogram := prometheus.MustNewConstHistogram(
+		t.newMetricDesc(fqName, labelKeys),
+		count,
+		sum,
+		buckets,
+		labelValues...,
 	)
+	if nativeSchema != nil {
+		histogram = newNativeHistogram(histogram, *nativeSchema, buckets)
+	}
+	if !startTime.IsZero() {
+		histogram = &createdHistogram{Metric: histogram, createdTimestamp: timestamppb.New(startTime)}
+	}
+	return prometheus.NewMetricWithTimestamp(reportTime, histogram)
 }
 
-func (t *timeSeriesMetrics) CollectNewConstMetric(timeSeries *monitoring.TimeSeries, reportTime time.Time, labelKeys []string, metricValueType prometheus.ValueType, metricValue float64, labelValues []string, metricKind string) {
-	fqName := buildFQName(timeSeries)
+// createdHistogram sets the created timestamp of a histogram, which const histograms do not support.
+type createdHistogram struct {
+	prometheus.Metric
+	createdTimestamp *timestamppb.Timestamp
+}
+
+func (h *createdHistogram) Write(out *dto.Metric) error {
+	if err := h.Metric.Write(out); err != nil {
+		return err
+	}
+	out.Histogram.CreatedTimestamp = h.createdTimestamp
+	return nil
+}
+
+func (t *timeSeriesMetrics) CollectNewConstMetric(timeSeries *monitoring.TimeSeries, reportTime, startTime time.Time, labelKeys []string, metricValueType prometheus.ValueType, metricValue float64, labelValues []string, metricKind string) {
+	fqName := t.fqName(timeSeries)
+	metricValue = t.scale(metricValue)
 
 	var v ConstMetric
 	if t.fillMissingLabels || (metricKind == "DELTA" && t.aggregateDeltas) {
@@ -162,6 +221,7 @@ func (t *timeSeriesMetrics) CollectNewConstMetric(timeSeries *monitoring.TimeSer
 			LabelValues:    labelValues,
 			ReportTime:     reportTime,
 			CollectionTime: time.Now(),
+			StartTime:      startTime,
 
 			KeysHash: hashLabelKeys(labelKeys),
 		}
@@ -181,14 +241,23 @@ func (t *timeSeriesMetrics) CollectNewConstMetric(timeSeries *monitoring.TimeSer
 		return
 	}
 
-	t.ch <- t.newConstMetric(fqName, reportTime, labelKeys, metricValueType, metricValue, labelValues)
+	t.ch <- t.newConstMetric(fqName, reportTime, startTime, labelKeys, metricValueType, metricValue, labelValues)
 }
 
-func (t *timeSeriesMetrics) newConstMetric(fqName string, reportTime time.Time, labelKeys []string, metricValueType prometheus.ValueType, metricValue float64, labelValues []string) prometheus.Metric {
+// newConstMetric returns a metric with the given value. The start time is only exposed as created timestamp of
+// counters.
+func (t *timeSeriesMetrics) newConstMetric(fqName string, reportTime, startTime time.Time, labelKeys []string, metricValueType prometheus.ValueType, metricValue float64, labelValues []string) prometheus.Metric {
+	desc := t.newMetricDesc(fqName, labelKeys)
+	if metricValueType == prometheus.CounterValue && !startTime.IsZero() {
+		return prometheus.NewMetricWithTimestamp(
+			reportTime,
+			prometheus.MustNewConstMetricWithCreatedTimestamp(desc, metricValueType, metricValue, startTime, labelValues...),
+		)
+	}
 	return prometheus.NewMetricWithTimestamp(
 		reportTime,
 		prometheus.MustNewConstMetric(
-			t.newMetricDesc(fqName, labelKeys),
+			desc,
 			metricValueType,
 			metricValue,
 			labelValues...,
@@ -230,7 +299,7 @@ func (t *timeSeriesMetrics) completeConstMetrics(constMetrics map[string][]*Cons
 		}
 
 		for _, v := range vs {
-			t.ch <- t.newConstMetric(v.FqName, v.ReportTime, v.LabelKeys, v.ValueType, v.Value, v.LabelValues)
+			t.ch <- t.newConstMetric(v.FqName, v.ReportTime, v.StartTime, v.LabelKeys, v.ValueType, v.Value, v.LabelValues)
 		}
 	}
 }
@@ -249,7 +318,7 @@ func (t *timeSeriesMetrics) completeHistogramMetrics(histograms map[string][]*Hi
 			}
 		}
 		for _, v := range vs {
-			t.ch <- t.newConstHistogram(v.FqName, v.ReportTime, v.LabelKeys, v.Mean, v.Count, v.Buckets, v.LabelValues)
+			t.ch <- t.newConstHistogram(v.FqName, v.ReportTime, v.StartTime, v.LabelKeys, v.Sum, v.Count, v.Buckets, v.NativeSchema, v.LabelValues)
 		}
 	}
 }
@@ -277,6 +346,7 @@ func (t *timeSeriesMetrics) completeDeltaConstMetrics(reportingStartTime time.Ti
 			t.ch <- t.newConstMetric(
 				collected.FqName,
 				collected.ReportTime,
+				collected.StartTime,
 				collected.LabelKeys,
 				collected.ValueType,
 				collected.Value,
@@ -313,10 +383,12 @@ func (t *timeSeriesMetrics) completeDeltaHistogramMetrics(reportingStartTime tim
 			t.ch <- t.newConstHistogram(
 				collected.FqName,
 				collected.ReportTime,
+				collected.StartTime,
 				collected.LabelKeys,
-				collected.Mean,
+				collected.Sum,
 				collected.Count,
 				collected.Buckets,
+				collected.NativeSchema,
 				collected.LabelValues,
 			)
 		}
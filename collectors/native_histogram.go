@@ -0,0 +1,149 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"math"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/api/monitoring/v3"
+)
+
+const (
+	// Native histogram schemas supported by Prometheus.
+	// @see https://github.com/prometheus/prometheus/blob/main/model/histogram/generic.go
+	minNativeSchema = -4
+	maxNativeSchema = 8
+
+	// nativeSchemaTolerance absorbs the rounding of the exponential bucket options returned by the API.
+	nativeSchemaTolerance = 1e-9
+)
+
+// nativeHistogramSchema returns the native histogram schema whose bucket boundaries match the exponential buckets of
+// opts, or nil when they do not match any schema. Boundaries match when the growth factor is 2^(2^-schema) and the
+// scale is one of the boundaries of that schema.
+// @see https://cloud.google.com/monitoring/api/ref_v3/rest/v3/TypedValue#exponential
+func nativeHistogramSchema(opts *monitoring.BucketOptions) *int32 {
+	if opts == nil || opts.ExponentialBuckets == nil {
+		return nil
+	}
+	exp := opts.ExponentialBuckets
+	if exp.GrowthFactor <= 1 || exp.Scale <= 0 {
+		return nil
+	}
+
+	schema := -math.Log2(math.Log2(exp.GrowthFactor))
+	if math.Abs(schema-math.Round(schema)) > nativeSchemaTolerance {
+		return nil
+	}
+	schema = math.Round(schema)
+	if schema < minNativeSchema || schema > maxNativeSchema {
+		return nil
+	}
+
+	index := math.Log2(exp.Scale) * math.Exp2(schema)
+	if math.Abs(index-math.Round(index)) > nativeSchemaTolerance {
+		return nil
+	}
+
+	s := int32(schema)
+	return &s
+}
+
+// nativeHistogram is a const histogram which also carries its buckets in the sparse native histogram representation.
+// The classic buckets are kept so that the histogram is still exposed in the text format, which does not support
+// native histograms.
+type nativeHistogram struct {
+	prometheus.Metric
+
+	schema        int32
+	zeroThreshold float64
+	zeroCount     uint64
+	spans         []*dto.BucketSpan
+	deltas        []int64
+}
+
+// newNativeHistogram converts the cumulative classic buckets of an exponential distribution with the given schema
+// to a native histogram wrapping classic. The underflow bucket of the distribution becomes the zero bucket. The
+// overflow bucket has no upper bound, which native buckets cannot express, so distributions with observations in it
+// are only exposed as classic.
+func newNativeHistogram(classic prometheus.Metric, schema int32, buckets map[float64]uint64) prometheus.Metric {
+	bounds := make([]float64, 0, len(buckets))
+	for bound := range buckets {
+		if !math.IsInf(bound, 1) {
+			bounds = append(bounds, bound)
+		}
+	}
+	if len(bounds) == 0 {
+		return classic
+	}
+	sort.Float64s(bounds)
+	last := bounds[len(bounds)-1]
+	if buckets[math.Inf(1)] > buckets[last] {
+		return classic
+	}
+
+	h := &nativeHistogram{
+		Metric:        classic,
+		schema:        schema,
+		zeroThreshold: bounds[0],
+		zeroCount:     buckets[bounds[0]],
+	}
+
+	var (
+		previousIndex int32
+		previousCount int64
+	)
+	addBucket := func(index int32, count uint64) {
+		if count == 0 {
+			return
+		}
+		if len(h.spans) > 0 && index == previousIndex+1 {
+			*h.spans[len(h.spans)-1].Length++
+		} else {
+			offset := index
+			if len(h.spans) > 0 {
+				offset = index - previousIndex - 1
+			}
+			length := uint32(1)
+			h.spans = append(h.spans, &dto.BucketSpan{Offset: &offset, Length: &length})
+		}
+		h.deltas = append(h.deltas, int64(count)-previousCount)
+		previousIndex = index
+		previousCount = int64(count)
+	}
+
+	nativeIndex := func(bound float64) int32 {
+		return int32(math.Round(math.Log2(bound) * math.Exp2(float64(schema))))
+	}
+	for i := 1; i < len(bounds); i++ {
+		addBucket(nativeIndex(bounds[i]), buckets[bounds[i]]-buckets[bounds[i-1]])
+	}
+
+	return h
+}
+
+func (h *nativeHistogram) Write(out *dto.Metric) error {
+	if err := h.Metric.Write(out); err != nil {
+		return err
+	}
+	out.Histogram.Schema = &h.schema
+	out.Histogram.ZeroThreshold = &h.zeroThreshold
+	out.Histogram.ZeroCount = &h.zeroCount
+	out.Histogram.PositiveSpan = h.spans
+	out.Histogram.PositiveDelta = h.deltas
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/api/monitoring/v3"
+)
+
+func TestNativeHistogramSchema(t *testing.T) {
+	for name, tc := range map[string]struct {
+		opts     *monitoring.BucketOptions
+		expected *int32
+	}{
+		"growth 2":            {exponentialBuckets(1, 2), int32Ptr(0)},
+		"growth sqrt 2":       {exponentialBuckets(1, math.Sqrt2), int32Ptr(1)},
+		"growth 4 scale 16":   {exponentialBuckets(16, 4), int32Ptr(-1)},
+		"scale below 1":       {exponentialBuckets(0.25, 2), int32Ptr(0)},
+		"scale off boundary":  {exponentialBuckets(3, 2), nil},
+		"unsupported growth":  {exponentialBuckets(1, 1.5), nil},
+		"schema out of range": {exponentialBuckets(1, math.Exp2(math.Exp2(-9))), nil},
+		"linear buckets": {&monitoring.BucketOptions{
+			LinearBuckets: &monitoring.Linear{NumFiniteBuckets: 3, Offset: 0, Width: 2},
+		}, nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			schema := nativeHistogramSchema(tc.opts)
+			if !reflect.DeepEqual(schema, tc.expected) {
+				t.Errorf("expected schema %v, got %v", deref(tc.expected), deref(schema))
+			}
+		})
+	}
+}
+
+func TestNewNativeHistogram(t *testing.T) {
+	dist := &monitoring.Distribution{
+		BucketOptions: exponentialBuckets(1, 2),
+		// Underflow, [1,2), [2,4), [4,8), overflow
+		BucketCounts: []int64{1, 2, 0, 3, 0},
+		Count:        6,
+		Mean:         5,
+	}
+	buckets, err := (&MonitoringCollector{}).generateHistogramBuckets(dist)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc := prometheus.NewDesc("test_histogram", "help", nil, nil)
+	classic := prometheus.MustNewConstHistogram(desc, 6, 30, buckets)
+	metric := newNativeHistogram(classic, 0, buckets)
+
+	out := &dto.Metric{}
+	if err := metric.Write(out); err != nil {
+		t.Fatal(err)
+	}
+	h := out.Histogram
+
+	if h.GetSchema() != 0 || h.GetZeroThreshold() != 1 || h.GetZeroCount() != 1 {
+		t.Errorf("unexpected schema %d, zero threshold %v or zero count %d", h.GetSchema(), h.GetZeroThreshold(), h.GetZeroCount())
+	}
+	var spans [][2]int64
+	for _, s := range h.PositiveSpan {
+		spans = append(spans, [2]int64{int64(s.GetOffset()), int64(s.GetLength())})
+	}
+	// Buckets 1 (2) and 3 (3), the empty bucket 2 is skipped
+	if expected := [][2]int64{{1, 1}, {1, 1}}; !reflect.DeepEqual(spans, expected) {
+		t.Errorf("expected spans %v, got %v", expected, spans)
+	}
+	if expected := []int64{2, 1}; !reflect.DeepEqual(h.PositiveDelta, expected) {
+		t.Errorf("expected deltas %v, got %v", expected, h.PositiveDelta)
+	}
+	if h.GetSampleCount() != 6 || h.GetSampleSum() != 30 {
+		t.Errorf("unexpected count %d or sum %v", h.GetSampleCount(), h.GetSampleSum())
+	}
+	if len(h.Bucket) == 0 {
+		t.Error("expected the classic buckets to be kept")
+	}
+
+	// The text format, which does not support native histograms, still exposes the classic buckets
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&staticCollector{metrics: []prometheus.Metric{metric}})
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := expfmt.MetricFamilyToText(&buf, families[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`test_histogram_bucket{le="8"} 6`)) {
+		t.Errorf("expected the classic buckets in the text format, got\n%s", buf.String())
+	}
+}
+
+func TestNewNativeHistogramWithOverflow(t *testing.T) {
+	dist := &monitoring.Distribution{
+		BucketOptions: exponentialBuckets(1, 2),
+		// Underflow, [1,2), [2,4), [4,8), overflow
+		BucketCounts: []int64{1, 2, 0, 3, 4},
+		Count:        10,
+		Mean:         5,
+	}
+	buckets, err := (&MonitoringCollector{}).generateHistogramBuckets(dist)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc := prometheus.NewDesc("test_histogram", "help", nil, nil)
+	classic := prometheus.MustNewConstHistogram(desc, 10, 50, buckets)
+	if metric := newNativeHistogram(classic, 0, buckets); metric != classic {
+		t.Error("expected a distribution with observations in the overflow bucket to stay classic")
+	}
+}
+
+// staticCollector collects a fixed list of metrics.
+type staticCollector struct {
+	metrics []prometheus.Metric
+}
+
+func (c *staticCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *staticCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
+
+func exponentialBuckets(scale, growthFactor float64) *monitoring.BucketOptions {
+	return &monitoring.BucketOptions{
+		ExponentialBuckets: &monitoring.Exponential{NumFiniteBuckets: 3, Scale: scale, GrowthFactor: growthFactor},
+	}
+}
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
+
+func deref(v *int32) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+)
+
+// snapshot holds the metrics fetched by the last successful poll of a metric type prefix.
+type snapshot struct {
+	metrics   []prometheus.Metric
+	timestamp time.Time
+}
+
+// Snapshots holds the latest snapshot of every polled metric type prefix of a project.
+type Snapshots struct {
+	mtx       sync.RWMutex
+	snapshots map[string]*snapshot
+}
+
+func NewSnapshots() *Snapshots {
+	return &Snapshots{snapshots: make(map[string]*snapshot)}
+}
+
+func (s *Snapshots) get(prefix string) (*snapshot, bool) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	snap, ok := s.snapshots[prefix]
+	return snap, ok
+}
+
+func (s *Snapshots) set(prefix string, snap *snapshot) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.snapshots[prefix] = snap
+}
+
+// StartPolling fetches the metrics of every prefix with a PollInterval into the collector's Snapshots in the
+// background until ctx is cancelled. Every collector sharing these Snapshots serves the latest snapshot of those
+// prefixes instead of requesting them from the API, so a single collector per project must be started.
+func (c *MonitoringCollector) StartPolling(ctx context.Context) {
+	if c.snapshots == nil {
+		return
+	}
+	for _, prefix := range c.metricsTypePrefixes {
+		if interval := c.settingsFor(prefix).PollInterval; interval > 0 {
+			go c.poll(ctx, prefix, interval)
+		}
+	}
+}
+
+// polledPrefixes splits the metric type prefixes between the ones served from snapshots and the ones requested on
+// every Collect.
+func (c *MonitoringCollector) polledPrefixes() (polled []string, requested []string) {
+	for _, prefix := range c.metricsTypePrefixes {
+		if c.snapshots != nil && c.settingsFor(prefix).PollInterval > 0 {
+			polled = append(polled, prefix)
+		} else {
+			requested = append(requested, prefix)
+		}
+	}
+	return polled, requested
+}
+
+func (c *MonitoringCollector) poll(ctx context.Context, prefix string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.pollPrefix(ctx, prefix)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *MonitoringCollector) pollPrefix(ctx context.Context, prefix string) {
+	begun := time.Now()
+
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	var metrics []prometheus.Metric
+	go func() {
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		close(done)
+	}()
+
+	errs := c.reportMonitoringMetrics(ctx, ch, begun, []string{prefix})
+	close(ch)
+	<-done
+
+	if ctx.Err() != nil {
+		// The collector was stopped
+		return
+	}
+	c.recordScrape(ctx, begun, []string{prefix}, errs)
+	if len(errs) > 0 {
+		// Keep serving the previous snapshot, its age reports how stale it is.
+		return
+	}
+
+	level.Debug(c.logger).Log("msg", "Stored Google Stackdriver Monitoring metrics snapshot", "prefix", prefix, "metrics", len(metrics))
+	c.snapshots.set(prefix, &snapshot{metrics: metrics, timestamp: time.Now()})
+}
+
+// collectSnapshots sends the latest snapshot of the prefixes together with their age.
+func (c *MonitoringCollector) collectSnapshots(ch chan<- prometheus.Metric, prefixes []string) {
+	for _, prefix := range prefixes {
+		s, ok := c.snapshots.get(prefix)
+		if !ok {
+			continue
+		}
+		for _, m := range s.metrics {
+			ch <- m
+		}
+		ch <- prometheus.MustNewConstMetric(c.snapshotAgeDesc, prometheus.GaugeValue, time.Since(s.timestamp).Seconds(), prefix)
+	}
+}
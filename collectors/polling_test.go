@@ -0,0 +1,212 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/promlog"
+	"golang.org/x/net/context"
+)
+
+func TestCollectSnapshots(t *testing.T) {
+	collector, err := NewMonitoringCollector("project", nil, MonitoringCollectorOptions{
+		MetricTypePrefixes: []string{"polled.googleapis.com", "requested.googleapis.com"},
+		CollectionOverrides: []CollectionOverride{
+			{Match: "polled.googleapis.com", Settings: CollectionSettings{PollInterval: time.Minute}},
+		},
+		Snapshots: NewSnapshots(),
+	}, promlog.New(&promlog.Config{}), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	polled, requested := collector.polledPrefixes()
+	if len(polled) != 1 || polled[0] != "polled.googleapis.com" {
+		t.Errorf("unexpected polled prefixes %v", polled)
+	}
+	if len(requested) != 1 || requested[0] != "requested.googleapis.com" {
+		t.Errorf("unexpected requested prefixes %v", requested)
+	}
+
+	gauge := prometheus.MustNewConstMetric(prometheus.NewDesc("metric", "help", nil, nil), prometheus.GaugeValue, 1)
+	collector.snapshots.set("polled.googleapis.com", &snapshot{
+		metrics:   []prometheus.Metric{gauge},
+		timestamp: time.Now().Add(-time.Minute),
+	})
+
+	ch := make(chan prometheus.Metric, 10)
+	collector.collectSnapshots(ch, polled)
+	close(ch)
+
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	if len(metrics) != 2 {
+		t.Fatalf("expected the snapshot metric and its age, got %d metrics", len(metrics))
+	}
+	if metrics[0] != gauge {
+		t.Errorf("expected the snapshot metric to be served")
+	}
+	if metrics[1].Desc() != collector.snapshotAgeDesc {
+		t.Errorf("expected the snapshot age to be served, got %s", metrics[1].Desc())
+	}
+}
+
+func TestPolledPrefixesWithoutSnapshots(t *testing.T) {
+	collector, err := NewMonitoringCollector("project", nil, MonitoringCollectorOptions{
+		MetricTypePrefixes: []string{"polled.googleapis.com"},
+		PollInterval:       time.Minute,
+	}, promlog.New(&promlog.Config{}), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	polled, requested := collector.polledPrefixes()
+	if len(polled) != 0 || len(requested) != 1 {
+		t.Errorf("expected every prefix to be requested without snapshots, got polled %v and requested %v", polled, requested)
+	}
+}
+
+// pollingTestAPI serves a single gauge whose value is the number of time series requests, or errors while failing is
+// set.
+type pollingTestAPI struct {
+	requests atomic.Int64
+	failing  atomic.Bool
+}
+
+func (a *pollingTestAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/metricDescriptors"):
+		_, _ = w.Write([]byte(`{"metricDescriptors": [{"type": "polled.googleapis.com/gauge", "metricKind": "GAUGE", "valueType": "DOUBLE"}]}`))
+	case strings.HasSuffix(r.URL.Path, "/timeSeries"):
+		requests := a.requests.Add(1)
+		if a.failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"error": {"code": 503, "message": "unavailable"}}`))
+			return
+		}
+		fmt.Fprintf(w, `{"timeSeries": [{
+			"metric": {"type": "polled.googleapis.com/gauge"},
+			"resource": {"type": "gce_instance", "labels": {"instance_id": "1"}},
+			"metricKind": "GAUGE",
+			"valueType": "DOUBLE",
+			"points": [{"interval": {"endTime": %q}, "value": {"doubleValue": %d}}]
+		}]}`, time.Now().Format(time.RFC3339Nano), requests)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newPollingTestCollector(t *testing.T, api *pollingTestAPI, snapshots *Snapshots) *MonitoringCollector {
+	collector, err := NewMonitoringCollector("project", newTestMonitoringService(t, api), MonitoringCollectorOptions{
+		MetricTypePrefixes: []string{"polled.googleapis.com"},
+		RequestInterval:    time.Minute,
+		PollInterval:       10 * time.Millisecond,
+		Snapshots:          snapshots,
+	}, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collector
+}
+
+// snapshotValue returns the value of the single gauge of the snapshot of the prefix.
+func snapshotValue(t *testing.T, snapshots *Snapshots, prefix string) float64 {
+	s, ok := snapshots.get(prefix)
+	if !ok {
+		t.Fatalf("expected a snapshot of %s", prefix)
+	}
+	if len(s.metrics) != 1 {
+		t.Fatalf("expected a single metric in the snapshot, got %d", len(s.metrics))
+	}
+	out := &dto.Metric{}
+	if err := s.metrics[0].Write(out); err != nil {
+		t.Fatal(err)
+	}
+	return out.GetGauge().GetValue()
+}
+
+func TestPollPrefix(t *testing.T) {
+	api := &pollingTestAPI{}
+	snapshots := NewSnapshots()
+	collector := newPollingTestCollector(t, api, snapshots)
+
+	collector.pollPrefix(context.Background(), "polled.googleapis.com")
+	if v := snapshotValue(t, snapshots, "polled.googleapis.com"); v != 1 {
+		t.Errorf("expected the first snapshot to hold 1, got %v", v)
+	}
+
+	collector.pollPrefix(context.Background(), "polled.googleapis.com")
+	if v := snapshotValue(t, snapshots, "polled.googleapis.com"); v != 2 {
+		t.Errorf("expected a successful poll to replace the snapshot, got %v", v)
+	}
+
+	api.failing.Store(true)
+	collector.pollPrefix(context.Background(), "polled.googleapis.com")
+	if v := snapshotValue(t, snapshots, "polled.googleapis.com"); v != 2 {
+		t.Errorf("expected a failed poll to keep the previous snapshot, got %v", v)
+	}
+
+	// Another collector sharing the snapshots serves them without requesting the API
+	requests := api.requests.Load()
+	other := newPollingTestCollector(t, api, snapshots)
+	ch := make(chan prometheus.Metric, 10)
+	other.collectSnapshots(ch, []string{"polled.googleapis.com"})
+	close(ch)
+	if len(ch) != 2 {
+		t.Errorf("expected the shared snapshot and its age, got %d metrics", len(ch))
+	}
+	if api.requests.Load() != requests {
+		t.Error("expected the shared snapshot to be served without requests")
+	}
+}
+
+func TestStartPolling(t *testing.T) {
+	api := &pollingTestAPI{}
+	snapshots := NewSnapshots()
+	collector := newPollingTestCollector(t, api, snapshots)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	collector.StartPolling(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for api.requests.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected polls every interval, got %d", api.requests.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := snapshots.get("polled.googleapis.com"); !ok {
+		t.Error("expected the polls to store a snapshot")
+	}
+
+	cancel()
+	// Let a poll in flight finish before checking that no other one starts
+	time.Sleep(50 * time.Millisecond)
+	requests := api.requests.Load()
+	time.Sleep(50 * time.Millisecond)
+	if api.requests.Load() != requests {
+		t.Error("expected polling to stop once the context is cancelled")
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/api/monitoring/v3"
+
+	"github.com/prometheus-community/stackdriver_exporter/hash"
+)
+
+// sampleTrackerRetention is how long the newest exported timestamp of a series which is no longer returned by the API
+// is remembered. It must exceed the requested intervals, otherwise points would be exported again.
+const sampleTrackerRetention = 24 * time.Hour
+
+// Sample is a single point of a time series with its own timestamp, as handed to a SampleSink.
+type Sample struct {
+	FqName      string
+	Help        string
+	LabelKeys   []string
+	LabelValues []string
+	Timestamp   time.Time
+	// CreatedTimestamp is the start of the interval of CUMULATIVE and DELTA points, zero for GAUGE points.
+	CreatedTimestamp time.Time
+
+	// ValueType and Value are set for BOOL, INT64, DOUBLE and MONEY points.
+	ValueType prometheus.ValueType
+	Value     float64
+	// Histogram is set instead of Value for DISTRIBUTION points.
+	Histogram *HistogramSample
+}
+
+// HistogramSample is the value of a DISTRIBUTION point, with cumulative buckets.
+type HistogramSample struct {
+	Sum     float64
+	Count   uint64
+	Buckets map[float64]uint64
+}
+
+// SampleSink receives every point of the metric types collected with CollectionSettings.AllPoints. The samples of a
+// series are appended in ascending timestamp order and, once accepted, never twice. Append either accepts all the
+// samples or returns an error, in which case the samples are handed again by the next collection requesting them.
+type SampleSink interface {
+	Append(samples []Sample) error
+}
+
+// SampleTracker remembers the timestamp of the newest sample accepted by the SampleSink for every series, so that the
+// points of overlapping requested intervals are only exported once.
+type SampleTracker struct {
+	mtx    sync.Mutex
+	series map[uint64]*trackedSeries
+}
+
+type trackedSeries struct {
+	newest time.Time
+	seen   time.Time
+}
+
+func NewSampleTracker() *SampleTracker {
+	return &SampleTracker{series: make(map[uint64]*trackedSeries)}
+}
+
+// export appends the samples of the series with the given key which were not exported yet to sink, and records them
+// as exported once sink accepted them. The samples must be in ascending timestamp order.
+func (t *SampleTracker) export(sink SampleSink, key uint64, samples []Sample, now time.Time) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	s, ok := t.series[key]
+	if !ok {
+		s = &trackedSeries{}
+		t.series[key] = s
+	}
+	s.seen = now
+
+	var pending []Sample
+	newest := s.newest
+	for _, sample := range samples {
+		if sample.Timestamp.After(newest) {
+			pending = append(pending, sample)
+			newest = sample.Timestamp
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := sink.Append(pending); err != nil {
+		return err
+	}
+	s.newest = newest
+	return nil
+}
+
+// prune forgets the series which were last seen before the given time.
+func (t *SampleTracker) prune(before time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for key, s := range t.series {
+		if s.seen.Before(before) {
+			delete(t.series, key)
+		}
+	}
+}
+
+// seriesKey identifies a series by its name and labels, independently of the order of the labels.
+func seriesKey(fqName string, labelKeys, labelValues []string) uint64 {
+	indexes := make([]int, len(labelKeys))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return labelKeys[indexes[i]] < labelKeys[indexes[j]]
+	})
+
+	h := hash.New()
+	h = hash.Add(h, fqName)
+	for _, i := range indexes {
+		h = hash.AddByte(h, hash.SeparatorByte)
+		h = hash.Add(h, labelKeys[i])
+		h = hash.AddByte(h, hash.SeparatorByte)
+		h = hash.Add(h, labelValues[i])
+	}
+	return h
+}
+
+// reportSamples hands the points of timeSeries which were not exported yet to the SampleSink, oldest first.
+func (c *MonitoringCollector) reportSamples(t *timeSeriesMetrics, timeSeries *monitoring.TimeSeries, labelKeys []string, labelValues []string, valueType prometheus.ValueType, begun time.Time) error {
+	type point struct {
+		startTime, endTime time.Time
+		value              *monitoring.TypedValue
+	}
+	points := make([]point, 0, len(timeSeries.Points))
+	for _, p := range timeSeries.Points {
+		endTime, err := time.Parse(time.RFC3339Nano, p.Interval.EndTime)
+		if err != nil {
+			return fmt.Errorf("Error parsing TimeSeries Point interval end time `%s`: %s", p.Interval.EndTime, err)
+		}
+		startTime, err := pointStartTime(timeSeries.MetricKind, p)
+		if err != nil {
+			return err
+		}
+		points = append(points, point{startTime: startTime, endTime: endTime, value: p.Value})
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].endTime.Before(points[j].endTime)
+	})
+
+	fqName := t.fqName(timeSeries)
+	var samples []Sample
+	for _, p := range points {
+		sample := Sample{
+			FqName:      fqName,
+			Help:        t.metricDescriptor.Description,
+			LabelKeys:   labelKeys,
+			LabelValues: labelValues,
+			Timestamp:   p.endTime,
+			ValueType:   valueType,
+
+			CreatedTimestamp: p.startTime,
+		}
+		if dist := p.value.DistributionValue; dist != nil {
+			buckets, err := c.generateHistogramBuckets(dist)
+			if err != nil {
+				continue
+			}
+			sample.Histogram = &HistogramSample{Sum: t.scale(dist.Mean * float64(dist.Count)), Count: uint64(dist.Count), Buckets: t.scaleBuckets(buckets)}
+		} else {
+			value, ok := scalarValue(p.value)
+			if !ok {
+				continue
+			}
+			sample.Value = t.scale(value)
+		}
+		samples = append(samples, sample)
+	}
+	if err := c.sampleTracker.export(c.sampleSink, seriesKey(fqName, labelKeys, labelValues), samples, begun); err != nil {
+		level.Debug(c.logger).Log("msg", "Samples not accepted by the sink, they will be handed again", "metric", fqName, "err", err)
+	}
+	return nil
+}
+
+// scalarValue returns the value of a BOOL, INT64 or DOUBLE point, MONEY amounts being returned as one of the latter.
+func scalarValue(v *monitoring.TypedValue) (float64, bool) {
+	switch {
+	case v.BoolValue != nil:
+		if *v.BoolValue {
+			return 1, true
+		}
+		return 0, true
+	case v.Int64Value != nil:
+		return float64(*v.Int64Value), true
+	case v.DoubleValue != nil:
+		return *v.DoubleValue, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/promlog"
+	"google.golang.org/api/monitoring/v3"
+)
+
+type recordingSampleSink struct {
+	samples []Sample
+	// failing rejects the appended samples
+	failing bool
+}
+
+func (s *recordingSampleSink) Append(samples []Sample) error {
+	if s.failing {
+		return errors.New("sink is full")
+	}
+	s.samples = append(s.samples, samples...)
+	return nil
+}
+
+func pointsTimeSeries(metricType string, start time.Time, values ...float64) *monitoring.TimeSeries {
+	ts := &monitoring.TimeSeries{
+		Metric:     &monitoring.Metric{Type: metricType},
+		Resource:   &monitoring.MonitoredResource{Type: "gce_instance", Labels: map[string]string{"instance_id": "1"}},
+		MetricKind: "GAUGE",
+		ValueType:  "DOUBLE",
+	}
+	// The API returns the newest point first
+	for i := len(values) - 1; i >= 0; i-- {
+		v := values[i]
+		ts.Points = append(ts.Points, &monitoring.Point{
+			Interval: &monitoring.TimeInterval{EndTime: start.Add(time.Duration(i) * time.Minute).Format(time.RFC3339Nano)},
+			Value:    &monitoring.TypedValue{DoubleValue: &v},
+		})
+	}
+	return ts
+}
+
+func TestReportAllPoints(t *testing.T) {
+	sink := &recordingSampleSink{}
+	c, err := NewMonitoringCollector("project", nil, MonitoringCollectorOptions{SampleSink: sink}, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptor := &monitoring.MetricDescriptor{Type: "test.googleapis.com/usage", MetricKind: "GAUGE", ValueType: "DOUBLE"}
+	settings := CollectionSettings{AllPoints: true}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	report := func(ts *monitoring.TimeSeries) []prometheus.Metric {
+		ch := make(chan prometheus.Metric, 10)
+		page := &monitoring.ListTimeSeriesResponse{TimeSeries: []*monitoring.TimeSeries{ts}}
+		if err := c.reportTimeSeriesMetrics(page, descriptor, settings, newStringValueLimiter(0), ch, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		close(ch)
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		return metrics
+	}
+
+	if metrics := report(pointsTimeSeries(descriptor.Type, start, 1, 2, 3)); len(metrics) != 1 {
+		t.Errorf("expected only the newest point to be collected, got %d metrics", len(metrics))
+	}
+	// The next requested interval overlaps the previous one by two points
+	report(pointsTimeSeries(descriptor.Type, start.Add(time.Minute), 2, 3, 4, 5))
+
+	if len(sink.samples) != 5 {
+		t.Fatalf("expected 5 samples, got %d: %+v", len(sink.samples), sink.samples)
+	}
+	for i, s := range sink.samples {
+		if s.Value != float64(i+1) {
+			t.Errorf("sample %d: expected value %d, got %v", i, i+1, s.Value)
+		}
+		if expected := start.Add(time.Duration(i) * time.Minute); !s.Timestamp.Equal(expected) {
+			t.Errorf("sample %d: expected timestamp %s, got %s", i, expected, s.Timestamp)
+		}
+		if s.FqName != "stackdriver_gce_instance_test_googleapis_com_usage" || s.ValueType != prometheus.GaugeValue {
+			t.Errorf("sample %d: unexpected name or type %+v", i, s)
+		}
+	}
+}
+
+func TestSampleTracker(t *testing.T) {
+	tracker := NewSampleTracker()
+	sink := &recordingSampleSink{}
+	now := time.Now()
+	key := seriesKey("metric", []string{"a", "b"}, []string{"1", "2"})
+	if key != seriesKey("metric", []string{"b", "a"}, []string{"2", "1"}) {
+		t.Error("expected the series key not to depend on the label order")
+	}
+	samples := []Sample{{FqName: "metric", Timestamp: now}, {FqName: "metric", Timestamp: now}}
+
+	sink.failing = true
+	if err := tracker.export(sink, key, samples, now); err == nil {
+		t.Fatal("expected the error of the sink")
+	}
+	sink.failing = false
+	if err := tracker.export(sink, key, samples, now); err != nil || len(sink.samples) != 1 {
+		t.Errorf("expected the rejected sample to be exported once, got %d samples (%v)", len(sink.samples), err)
+	}
+	if err := tracker.export(sink, key, samples, now); err != nil || len(sink.samples) != 1 {
+		t.Errorf("expected an exported sample not to be exported again, got %d samples (%v)", len(sink.samples), err)
+	}
+	tracker.prune(now.Add(time.Second))
+	if err := tracker.export(sink, key, samples, now); err != nil || len(sink.samples) != 2 {
+		t.Errorf("expected the sample to be exported again once its series was pruned, got %d samples (%v)", len(sink.samples), err)
+	}
+}
+
+func TestSharedSampleTracker(t *testing.T) {
+	sink := &recordingSampleSink{}
+	tracker := NewSampleTracker()
+	descriptor := &monitoring.MetricDescriptor{Type: "test.googleapis.com/usage", MetricKind: "GAUGE", ValueType: "DOUBLE"}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// The collector of every prefix and a collector filtered to the prefix of the metric type
+	for _, prefixes := range [][]string{{"compute.googleapis.com", "test.googleapis.com"}, {"test.googleapis.com"}} {
+		c, err := NewMonitoringCollector("project", nil, MonitoringCollectorOptions{MetricTypePrefixes: prefixes, SampleSink: sink, SampleTracker: tracker}, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := &monitoring.ListTimeSeriesResponse{TimeSeries: []*monitoring.TimeSeries{pointsTimeSeries(descriptor.Type, start, 1, 2)}}
+		if err := c.reportTimeSeriesMetrics(page, descriptor, CollectionSettings{AllPoints: true}, newStringValueLimiter(0), make(chan prometheus.Metric, 10), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sink.samples) != 2 {
+		t.Errorf("expected the points collected by both collectors of the project to be exported once, got %d samples", len(sink.samples))
+	}
+}
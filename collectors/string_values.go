@@ -0,0 +1,58 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"strings"
+)
+
+const (
+	// stringValueLabel is the label holding the value of STRING metrics, which are exported as info-style gauges.
+	stringValueLabel = "value"
+	// currencyLabel is the label holding the currency of MONEY metrics.
+	currencyLabel = "currency"
+	// infoSuffix is appended to the name of STRING metrics.
+	infoSuffix = "info"
+)
+
+// stringValueLimiter caps the number of distinct values of a STRING metric type which are exported during a single
+// collection, as every distinct value is a new series.
+type stringValueLimiter struct {
+	limit   int
+	values  map[string]struct{}
+	dropped int
+}
+
+// newStringValueLimiter returns a stringValueLimiter allowing limit distinct values, 0 allows any number of values.
+func newStringValueLimiter(limit int) *stringValueLimiter {
+	return &stringValueLimiter{limit: limit, values: map[string]struct{}{}}
+}
+
+// allow reports whether a series with the given value can be exported, counting the dropped series.
+func (l *stringValueLimiter) allow(value string) bool {
+	if _, ok := l.values[value]; ok || l.limit == 0 {
+		return true
+	}
+	if len(l.values) >= l.limit {
+		l.dropped++
+		return false
+	}
+	l.values[value] = struct{}{}
+	return true
+}
+
+// currencyFromUnit returns the currency code of a MONEY metric from its unit, ie `USD` for a unit of `USD` or `{USD}`.
+func currencyFromUnit(unit string) string {
+	return strings.TrimSpace(strings.Trim(unit, "{}"))
+}
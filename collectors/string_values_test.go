@@ -0,0 +1,204 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/promlog"
+	"google.golang.org/api/monitoring/v3"
+
+	"github.com/prometheus-community/stackdriver_exporter/relabel"
+)
+
+func TestStringValueLimiter(t *testing.T) {
+	l := newStringValueLimiter(2)
+	for _, value := range []string{"a", "b", "a", "c", "b", "d"} {
+		l.allow(value)
+	}
+	if l.dropped != 2 {
+		t.Errorf("expected 2 dropped series, got %d", l.dropped)
+	}
+
+	unlimited := newStringValueLimiter(0)
+	for _, value := range []string{"a", "b", "c"} {
+		if !unlimited.allow(value) {
+			t.Errorf("expected %q to be allowed without a limit", value)
+		}
+	}
+}
+
+func TestCurrencyFromUnit(t *testing.T) {
+	for unit, expected := range map[string]string{"USD": "USD", "{EUR}": "EUR", "": ""} {
+		if currency := currencyFromUnit(unit); currency != expected {
+			t.Errorf("expected currency %q for unit %q, got %q", expected, unit, currency)
+		}
+	}
+}
+
+// collectTimeSeries reports the time series of descriptor with the given settings and returns the written metrics by
+// name.
+func collectTimeSeries(t *testing.T, descriptor *monitoring.MetricDescriptor, settings CollectionSettings, series ...*monitoring.TimeSeries) map[string][]*dto.Metric {
+	t.Helper()
+	return collectTimeSeriesWith(t, MonitoringCollectorOptions{}, descriptor, settings, series...)
+}
+
+// collectTimeSeriesWith is like collectTimeSeries with a collector created with opts.
+func collectTimeSeriesWith(t *testing.T, opts MonitoringCollectorOptions, descriptor *monitoring.MetricDescriptor, settings CollectionSettings, series ...*monitoring.TimeSeries) map[string][]*dto.Metric {
+	t.Helper()
+	c, err := NewMonitoringCollector("project", nil, opts, promlog.New(&promlog.Config{}), noopDeltaStore{}, noopDeltaHistogramStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan prometheus.Metric, 100)
+	page := &monitoring.ListTimeSeriesResponse{TimeSeries: series}
+	if err := c.reportTimeSeriesMetrics(page, descriptor, settings, newStringValueLimiter(settings.StringValuesLimit), ch, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+
+	metrics := map[string][]*dto.Metric{}
+	for m := range ch {
+		out := &dto.Metric{}
+		if err := m.Write(out); err != nil {
+			t.Fatal(err)
+		}
+		name := m.Desc().String()
+		metrics[name] = append(metrics[name], out)
+	}
+	return metrics
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.Label {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+func newTimeSeries(metricType, valueType string, value *monitoring.TypedValue, labels map[string]string) *monitoring.TimeSeries {
+	return &monitoring.TimeSeries{
+		Metric:     &monitoring.Metric{Type: metricType, Labels: labels},
+		Resource:   &monitoring.MonitoredResource{Type: "gce_instance", Labels: map[string]string{"instance_id": "1"}},
+		MetricKind: "GAUGE",
+		ValueType:  valueType,
+		Points: []*monitoring.Point{{
+			Interval: &monitoring.TimeInterval{EndTime: time.Now().Format(time.RFC3339Nano)},
+			Value:    value,
+		}},
+	}
+}
+
+func TestReportStringTimeSeries(t *testing.T) {
+	descriptor := &monitoring.MetricDescriptor{Type: "test.googleapis.com/version", MetricKind: "GAUGE", ValueType: "STRING"}
+	stringValue := func(v string) *monitoring.TypedValue { return &monitoring.TypedValue{StringValue: &v} }
+
+	metrics := collectTimeSeries(t, descriptor, CollectionSettings{StringValuesLimit: 2},
+		newTimeSeries(descriptor.Type, "STRING", stringValue("1.0"), map[string]string{"node": "a"}),
+		newTimeSeries(descriptor.Type, "STRING", stringValue("1.1"), map[string]string{"node": "b"}),
+		newTimeSeries(descriptor.Type, "STRING", stringValue("1.1"), map[string]string{"node": "c"}),
+		newTimeSeries(descriptor.Type, "STRING", stringValue("2.0"), map[string]string{"node": "d"}),
+	)
+
+	if len(metrics) != 1 {
+		t.Fatalf("expected a single metric, got %v", metrics)
+	}
+	for name, ms := range metrics {
+		if want := `fqName: "stackdriver_gce_instance_test_googleapis_com_version_info"`; !strings.Contains(name, want) {
+			t.Errorf("expected the metric name to end with _info, got %s", name)
+		}
+		if len(ms) != 3 {
+			t.Fatalf("expected the series with the value over the limit to be dropped, got %d series", len(ms))
+		}
+		for _, m := range ms {
+			if m.GetGauge().GetValue() != 1 {
+				t.Errorf("expected info gauge value 1, got %v", m.GetGauge().GetValue())
+			}
+			if v := labelValue(m, "value"); v != "1.0" && v != "1.1" {
+				t.Errorf("unexpected value label %q", v)
+			}
+		}
+	}
+}
+
+func TestReportStringTimeSeriesWithValueLabels(t *testing.T) {
+	descriptor := &monitoring.MetricDescriptor{Type: "test.googleapis.com/version", MetricKind: "GAUGE", ValueType: "STRING"}
+	v := "1.0"
+
+	metrics := collectTimeSeries(t, descriptor, CollectionSettings{},
+		newTimeSeries(descriptor.Type, "STRING", &monitoring.TypedValue{StringValue: &v}, map[string]string{"value": "a", "string_value": "b"}),
+	)
+
+	if len(metrics) != 1 {
+		t.Fatalf("expected a single metric, got %v", metrics)
+	}
+	for _, ms := range metrics {
+		m := ms[0]
+		if labelValue(m, "value") != "a" || labelValue(m, "string_value") != "b" || labelValue(m, "string_string_value") != "1.0" {
+			t.Errorf("expected the string value in the first free prefixed label, got %v", m.Label)
+		}
+	}
+}
+
+func TestReportMoneyTimeSeries(t *testing.T) {
+	descriptor := &monitoring.MetricDescriptor{Type: "test.googleapis.com/cost", MetricKind: "GAUGE", ValueType: "MONEY", Unit: "USD"}
+	amount := 12.5
+
+	metrics := collectTimeSeries(t, descriptor, CollectionSettings{},
+		newTimeSeries(descriptor.Type, "MONEY", &monitoring.TypedValue{DoubleValue: &amount}, nil),
+	)
+
+	if len(metrics) != 1 {
+		t.Fatalf("expected a single metric, got %v", metrics)
+	}
+	for _, ms := range metrics {
+		if ms[0].GetGauge().GetValue() != amount {
+			t.Errorf("expected gauge value %v, got %v", amount, ms[0].GetGauge().GetValue())
+		}
+		if currency := labelValue(ms[0], "currency"); currency != "USD" {
+			t.Errorf("expected currency USD, got %q", currency)
+		}
+	}
+}
+
+func TestRelabelValueLabels(t *testing.T) {
+	stringDescriptor := &monitoring.MetricDescriptor{Type: "test.googleapis.com/version", MetricKind: "GAUGE", ValueType: "STRING"}
+	moneyDescriptor := &monitoring.MetricDescriptor{Type: "test.googleapis.com/cost", MetricKind: "GAUGE", ValueType: "MONEY", Unit: "USD"}
+	version, amount := "1.0", 12.5
+	opts := MonitoringCollectorOptions{RelabelConfigs: []RelabelConfig{{Prefix: "test.googleapis.com/", Rules: []*relabel.Config{
+		{SourceLabels: []string{"value"}, Separator: ";", Regex: relabel.MustNewRegexp("(.*)"), TargetLabel: "version", Replacement: "v$1", Action: relabel.Replace},
+		{Regex: relabel.MustNewRegexp("currency"), Action: relabel.LabelDrop},
+	}}}}
+
+	for descriptor, ts := range map[*monitoring.MetricDescriptor]*monitoring.TimeSeries{
+		stringDescriptor: newTimeSeries(stringDescriptor.Type, "STRING", &monitoring.TypedValue{StringValue: &version}, nil),
+		moneyDescriptor:  newTimeSeries(moneyDescriptor.Type, "MONEY", &monitoring.TypedValue{DoubleValue: &amount}, nil),
+	} {
+		for _, ms := range collectTimeSeriesWith(t, opts, descriptor, CollectionSettings{}, ts) {
+			if descriptor == stringDescriptor && labelValue(ms[0], "version") != "v1.0" {
+				t.Errorf("expected the value label to be relabeled, got %v", ms[0].Label)
+			}
+			if descriptor == moneyDescriptor && labelValue(ms[0], "currency") != "" {
+				t.Errorf("expected the currency label to be dropped, got %v", ms[0].Label)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/monitoring/v3"
+)
+
+// baseUnit is the Prometheus base unit of a metric and the factor converting its values to it.
+// @see https://prometheus.io/docs/practices/naming/#base-units
+type baseUnit struct {
+	name  string
+	scale float64
+}
+
+// ucumUnits maps the UCUM units used by metric descriptors to their base unit.
+// @see https://cloud.google.com/monitoring/api/ref_v3/rest/v3/projects.metricDescriptors#MetricDescriptor.FIELDS.unit
+var ucumUnits = map[string]baseUnit{
+	"s":    {"seconds", 1},
+	"ms":   {"seconds", 1e-3},
+	"us":   {"seconds", 1e-6},
+	"ns":   {"seconds", 1e-9},
+	"min":  {"seconds", 60},
+	"h":    {"seconds", 60 * 60},
+	"d":    {"seconds", 24 * 60 * 60},
+	"bit":  {"bytes", 1.0 / 8},
+	"kbit": {"bytes", 1e3 / 8},
+	"Mbit": {"bytes", 1e6 / 8},
+	"Gbit": {"bytes", 1e9 / 8},
+	"By":   {"bytes", 1},
+	"kBy":  {"bytes", 1e3},
+	"MBy":  {"bytes", 1e6},
+	"GBy":  {"bytes", 1e9},
+	"TBy":  {"bytes", 1e12},
+	"PBy":  {"bytes", 1e15},
+	"KiBy": {"bytes", 1 << 10},
+	"MiBy": {"bytes", 1 << 20},
+	"GiBy": {"bytes", 1 << 30},
+	"TiBy": {"bytes", 1 << 40},
+	"PiBy": {"bytes", 1 << 50},
+	"%":    {"ratio", 1e-2},
+	"Hz":   {"hertz", 1},
+	"W":    {"watts", 1},
+	"kW":   {"watts", 1e3},
+	"J":    {"joules", 1},
+	"kWh":  {"joules", 3.6e6},
+	"Cel":  {"celsius", 1},
+	"m":    {"meters", 1},
+	"km":   {"meters", 1e3},
+}
+
+// ucumAnnotation matches the curly braces annotations of UCUM units, ie `{packet}`, which do not affect the unit.
+var ucumAnnotation = regexp.MustCompile(`\{[^}]*\}`)
+
+// toBaseUnit returns the base unit of a UCUM unit. Rates such as `By/s` become `bytes_per_second`. It returns false
+// for the unitless `1`, dimensionless counts, ie `{request}`, and units which are not known.
+func toBaseUnit(unit string) (baseUnit, bool) {
+	unit = strings.Trim(ucumAnnotation.ReplaceAllString(unit, ""), ".")
+	if unit == "" || unit == "1" {
+		return baseUnit{}, false
+	}
+	numerator, denominator, isRate := strings.Cut(unit, "/")
+	if !isRate {
+		u, ok := ucumUnits[unit]
+		return u, ok
+	}
+
+	per, ok := ucumUnits[denominator]
+	if !ok || per.name != "seconds" {
+		return baseUnit{}, false
+	}
+	// Rates of counts, ie `{request}/s` or `1/s`, are per second
+	if numerator == "" || numerator == "1" {
+		return baseUnit{name: "per_second", scale: 1 / per.scale}, true
+	}
+	u, ok := ucumUnits[numerator]
+	if !ok || u.name == "ratio" {
+		return baseUnit{}, false
+	}
+	return baseUnit{name: u.name + "_per_second", scale: u.scale / per.scale}, true
+}
+
+// scaleBuckets returns the buckets of a histogram with their bounds converted to the base unit.
+func (u baseUnit) scaleBuckets(buckets map[float64]uint64) map[float64]uint64 {
+	if u.scale == 1 {
+		return buckets
+	}
+	scaled := make(map[float64]uint64, len(buckets))
+	for bound, count := range buckets {
+		scaled[bound*u.scale] = count
+	}
+	return scaled
+}
+
+// metricBaseUnit returns the base unit the values of a metric descriptor are converted to. STRING metrics are info
+// metrics and the unit of MONEY metrics is a currency, so neither has one.
+func metricBaseUnit(descriptor *monitoring.MetricDescriptor) (baseUnit, bool) {
+	switch descriptor.ValueType {
+	case "STRING", "MONEY":
+		return baseUnit{}, false
+	}
+	return toBaseUnit(descriptor.Unit)
+}
@@ -0,0 +1,43 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"testing"
+)
+
+func TestToBaseUnit(t *testing.T) {
+	for unit, expected := range map[string]baseUnit{
+		"ms":          {"seconds", 1e-3},
+		"s":           {"seconds", 1},
+		"GiBy":        {"bytes", 1 << 30},
+		"{Bytes}.By":  {"bytes", 1},
+		"%":           {"ratio", 1e-2},
+		"By/s":        {"bytes_per_second", 1},
+		"kbit/min":    {"bytes_per_second", 1e3 / 8 / 60},
+		"{request}/s": {"per_second", 1},
+		"1/min":       {"per_second", 1.0 / 60},
+	} {
+		u, ok := toBaseUnit(unit)
+		if !ok || u != expected {
+			t.Errorf("%s: expected %v, got %v (%t)", unit, expected, u, ok)
+		}
+	}
+
+	for _, unit := range []string{"", "1", "{request}", "{request}.1", "furlong", "By/By", "%/s"} {
+		if u, ok := toBaseUnit(unit); ok {
+			t.Errorf("%s: expected no base unit, got %v", unit, u)
+		}
+	}
+}
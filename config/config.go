@@ -0,0 +1,278 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+
+	"github.com/prometheus-community/stackdriver_exporter/relabel"
+	"github.com/prometheus-community/stackdriver_exporter/utils"
+)
+
+// Config is the exporter configuration which can be provided through a YAML file instead of command line flags.
+type Config struct {
+	// ProjectIDs is the list of Google Project IDs to collect metrics from.
+	ProjectIDs []string `yaml:"project_ids,omitempty"`
+	// ProjectsFilter is a Google projects search filter used to discover additional projects.
+	ProjectsFilter string `yaml:"projects_filter,omitempty"`
+	// ProjectsFilterRefreshInterval is how often the projects matching ProjectsFilter are re-discovered, 0 disables it.
+	ProjectsFilterRefreshInterval model.Duration `yaml:"projects_filter_refresh_interval,omitempty"`
+
+	// MetricsTypePrefixes are the Google Stackdriver Monitoring metric type prefixes to collect.
+	MetricsTypePrefixes []string `yaml:"metrics_type_prefixes"`
+	// ExtraFilters are additional filters 'AND' concatenated to the queries of matching metric types.
+	ExtraFilters []ExtraFilter `yaml:"extra_filters,omitempty"`
+	// MetricsInterval is the time interval requested for every metric type.
+	MetricsInterval model.Duration `yaml:"metrics_interval"`
+	// MetricsOffset offsets the requested interval into the past.
+	MetricsOffset model.Duration `yaml:"metrics_offset"`
+	// MetricsIngestDelay offsets the requested interval by the ingest delay found in the metric's metadata.
+	MetricsIngestDelay bool `yaml:"metrics_ingest_delay"`
+	// FillMissingLabels fills missing metric labels with an empty string.
+	FillMissingLabels bool `yaml:"fill_missing_labels"`
+	// DropDelegatedProjects drops metrics from attached projects.
+	DropDelegatedProjects bool `yaml:"drop_delegated_projects"`
+	// AggregateDeltas treats DELTA metrics as in-memory counters instead of gauges.
+	AggregateDeltas bool `yaml:"aggregate_deltas"`
+	// AggregateDeltasTTL is how long an aggregated DELTA metric is exported after GCP stops producing it.
+	AggregateDeltasTTL model.Duration `yaml:"aggregate_deltas_ttl"`
+	// DescriptorCacheTTL is how long metric descriptors for a prefix are cached for.
+	DescriptorCacheTTL model.Duration `yaml:"descriptor_cache_ttl"`
+	// DescriptorCacheOnlyGoogle only caches descriptors for *.googleapis.com metrics.
+	DescriptorCacheOnlyGoogle bool `yaml:"descriptor_cache_only_google"`
+	// PollInterval fetches metrics in the background at this interval instead of on every scrape, 0 disables it.
+	PollInterval model.Duration `yaml:"poll_interval,omitempty"`
+	// NativeHistograms also exposes DISTRIBUTION metrics with matching exponential buckets as native histograms.
+	NativeHistograms bool `yaml:"native_histograms,omitempty"`
+	// StringValuesLimit is the maximum number of distinct values of a STRING metric type exported per scrape, 0 means
+	// unlimited.
+	StringValuesLimit int `yaml:"string_values_limit"`
+	// AllPoints exports every point of the requested interval with its own timestamp through the backfill endpoint,
+	// instead of only the newest one.
+	AllPoints bool `yaml:"all_points,omitempty"`
+	// UnitLabel adds the unit of the metric descriptor as `unit` label to every metric.
+	UnitLabel bool `yaml:"unit_label"`
+	// BaseUnits converts values to the base unit of their metric descriptor unit and appends it to the metric names.
+	BaseUnits bool `yaml:"base_units,omitempty"`
+	// MetricNameTemplate builds the metric names from the monitored resource type and the metric type.
+	MetricNameTemplate string `yaml:"metric_name_template,omitempty"`
+	// LabelCollisions is how metric and resource labels sharing a key are handled: drop, suffix or prefix.
+	LabelCollisions string `yaml:"label_collisions,omitempty"`
+	// MetadataSystemLabels are the keys of the metadata system labels added to the series, `*` selects all of them.
+	MetadataSystemLabels []string `yaml:"metadata_system_labels,omitempty"`
+	// MetadataUserLabels are the keys of the metadata user labels added to the series, `*` selects all of them.
+	MetadataUserLabels []string `yaml:"metadata_user_labels,omitempty"`
+
+	// CollectionOverrides replace the global collection settings for the metric types they match.
+	CollectionOverrides []CollectionOverride `yaml:"collection_overrides,omitempty"`
+	// RelabelConfigs relabel the series of the metric types they match before they are exported.
+	RelabelConfigs []RelabelConfig `yaml:"relabel_configs,omitempty"`
+}
+
+// RelabelConfig applies Prometheus relabel_configs rules to the series of every metric type starting with Prefix, or
+// of every metric type if Prefix is empty.
+type RelabelConfig struct {
+	Prefix string            `yaml:"prefix,omitempty"`
+	Rules  []*relabel.Config `yaml:"rules"`
+}
+
+// CollectionOverride replaces the global collection settings for every metric type matching Match. Settings which are
+// not set keep their global value.
+type CollectionOverride struct {
+	// Match is either a metric type prefix or, when it contains any of the characters `*?[`, a glob pattern.
+	Match string `yaml:"match"`
+
+	MetricsInterval       *model.Duration `yaml:"metrics_interval,omitempty"`
+	MetricsOffset         *model.Duration `yaml:"metrics_offset,omitempty"`
+	MetricsIngestDelay    *bool           `yaml:"metrics_ingest_delay,omitempty"`
+	DropDelegatedProjects *bool           `yaml:"drop_delegated_projects,omitempty"`
+	AggregateDeltas       *bool           `yaml:"aggregate_deltas,omitempty"`
+	// PollInterval only applies to overrides matching a whole metric type prefix.
+	PollInterval      *model.Duration `yaml:"poll_interval,omitempty"`
+	NativeHistograms  *bool           `yaml:"native_histograms,omitempty"`
+	StringValuesLimit *int            `yaml:"string_values_limit,omitempty"`
+	AllPoints         *bool           `yaml:"all_points,omitempty"`
+
+	// Aggregation requests the matching time series to be aligned and reduced by the Monitoring API.
+	Aggregation *Aggregation `yaml:"aggregation,omitempty"`
+}
+
+// Aggregation is the server-side aggregation of the Monitoring API.
+// @see https://cloud.google.com/monitoring/api/ref_v3/rest/v3/projects.timeSeries/list#aggregation
+type Aggregation struct {
+	AlignmentPeriod    model.Duration `yaml:"alignment_period,omitempty"`
+	PerSeriesAligner   string         `yaml:"per_series_aligner,omitempty"`
+	CrossSeriesReducer string         `yaml:"cross_series_reducer,omitempty"`
+	GroupByFields      []string       `yaml:"group_by_fields,omitempty"`
+}
+
+// ExtraFilter is a Stackdriver Monitoring filter applied to every metric type containing Prefix.
+type ExtraFilter struct {
+	Prefix string `yaml:"prefix"`
+	Filter string `yaml:"filter"`
+}
+
+// Load parses the YAML input s on top of base and validates the result.
+func Load(s string, base Config) (*Config, error) {
+	cfg := base
+	if err := yaml.UnmarshalStrict([]byte(s), &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// LoadFile parses the given YAML file on top of base and validates the result. Any value which is not set in the
+// file keeps the value from base.
+func LoadFile(filename string, base Config) (*Config, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Load(string(content), base)
+	if err != nil {
+		return nil, fmt.Errorf("parsing YAML file %s: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks the configuration for inconsistencies.
+func (c *Config) Validate() error {
+	if len(c.MetricsTypePrefixes) == 0 {
+		return errors.New("at least one metrics type prefix is required")
+	}
+	for _, prefix := range c.MetricsTypePrefixes {
+		if strings.TrimSpace(prefix) == "" {
+			return errors.New("metrics type prefixes must not be empty")
+		}
+	}
+	for _, ef := range c.ExtraFilters {
+		if ef.Prefix == "" || ef.Filter == "" {
+			return fmt.Errorf("extra filter %+v must define both a prefix and a filter", ef)
+		}
+	}
+	if c.ProjectsFilterRefreshInterval < 0 {
+		return errors.New("projects filter refresh interval must not be negative")
+	}
+	if c.PollInterval < 0 {
+		return errors.New("poll interval must not be negative")
+	}
+	if c.MetricsInterval <= 0 {
+		return errors.New("metrics interval must be greater than 0")
+	}
+	if c.MetricsOffset < 0 {
+		return errors.New("metrics offset must not be negative")
+	}
+	if c.AggregateDeltasTTL < 0 {
+		return errors.New("aggregate deltas TTL must not be negative")
+	}
+	if c.DescriptorCacheTTL < 0 {
+		return errors.New("descriptor cache TTL must not be negative")
+	}
+	if c.StringValuesLimit < 0 {
+		return errors.New("string values limit must not be negative")
+	}
+	if c.MetricNameTemplate != "" {
+		if _, err := utils.ParseMetricNameTemplate(c.MetricNameTemplate); err != nil {
+			return err
+		}
+	}
+	switch c.LabelCollisions {
+	case "", "drop", "suffix", "prefix":
+	default:
+		return fmt.Errorf("unknown label collisions strategy %q", c.LabelCollisions)
+	}
+	for _, o := range c.CollectionOverrides {
+		if err := o.validate(); err != nil {
+			return fmt.Errorf("collection override %q: %w", o.Match, err)
+		}
+	}
+	for _, rc := range c.RelabelConfigs {
+		if err := rc.validate(); err != nil {
+			return fmt.Errorf("relabel config %q: %w", rc.Prefix, err)
+		}
+	}
+	return nil
+}
+
+func (rc *RelabelConfig) validate() error {
+	if len(rc.Rules) == 0 {
+		return errors.New("at least one rule is required")
+	}
+	for _, rule := range rc.Rules {
+		if rule == nil {
+			return errors.New("rules must not be empty")
+		}
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *CollectionOverride) validate() error {
+	if o.Match == "" {
+		return errors.New("match must not be empty")
+	}
+	if _, err := path.Match(o.Match, ""); err != nil {
+		return fmt.Errorf("invalid glob pattern: %w", err)
+	}
+	if o.MetricsInterval != nil && *o.MetricsInterval <= 0 {
+		return errors.New("metrics interval must be greater than 0")
+	}
+	if o.StringValuesLimit != nil && *o.StringValuesLimit < 0 {
+		return errors.New("string values limit must not be negative")
+	}
+	if o.Aggregation != nil {
+		if err := o.Aggregation.validate(); err != nil {
+			return fmt.Errorf("aggregation: %w", err)
+		}
+	}
+	return nil
+}
+
+func (a *Aggregation) validate() error {
+	aligned := a.PerSeriesAligner != "" && a.PerSeriesAligner != "ALIGN_NONE"
+	reduced := a.CrossSeriesReducer != "" && a.CrossSeriesReducer != "REDUCE_NONE"
+
+	if a.PerSeriesAligner != "" && !strings.HasPrefix(a.PerSeriesAligner, "ALIGN_") {
+		return fmt.Errorf("unknown per series aligner %q", a.PerSeriesAligner)
+	}
+	if a.CrossSeriesReducer != "" && !strings.HasPrefix(a.CrossSeriesReducer, "REDUCE_") {
+		return fmt.Errorf("unknown cross series reducer %q", a.CrossSeriesReducer)
+	}
+	if !aligned && !reduced {
+		return errors.New("a per series aligner or a cross series reducer is required")
+	}
+	if reduced && !aligned {
+		return errors.New("a cross series reducer requires a per series aligner")
+	}
+	if aligned && a.AlignmentPeriod < model.Duration(time.Minute) {
+		return errors.New("alignment period must be at least 60s when a per series aligner is used")
+	}
+	if len(a.GroupByFields) > 0 && !reduced {
+		return errors.New("group by fields require a cross series reducer")
+	}
+	return nil
+}
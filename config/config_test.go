@@ -0,0 +1,172 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/prometheus-community/stackdriver_exporter/relabel"
+)
+
+var defaultTestConfig = Config{
+	MetricsInterval:           model.Duration(5 * time.Minute),
+	FillMissingLabels:         true,
+	AggregateDeltasTTL:        model.Duration(30 * time.Minute),
+	DescriptorCacheOnlyGoogle: true,
+}
+
+func TestLoad(t *testing.T) {
+	cfg, err := Load(`
+project_ids:
+  - project-a
+  - project-b
+metrics_type_prefixes:
+  - compute.googleapis.com/instance/cpu
+  - pubsub.googleapis.com/subscription
+extra_filters:
+  - prefix: pubsub.googleapis.com/subscription
+    filter: resource.labels.subscription_id=monitoring.regex.full_match("my-subs-prefix.*")
+metrics_offset: 1m
+aggregate_deltas: true
+metadata_system_labels: [name, machine_type]
+metadata_user_labels: ['*']
+collection_overrides:
+  - match: bigquery.googleapis.com/
+    metrics_interval: 20m
+    metrics_ingest_delay: true
+  - match: loadbalancing.googleapis.com/https/request_count
+    aggregation:
+      alignment_period: 1m
+      per_series_aligner: ALIGN_RATE
+      cross_series_reducer: REDUCE_SUM
+      group_by_fields: [resource.label.backend_target_name]
+`, defaultTestConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := defaultTestConfig
+	expected.ProjectIDs = []string{"project-a", "project-b"}
+	expected.MetricsTypePrefixes = []string{"compute.googleapis.com/instance/cpu", "pubsub.googleapis.com/subscription"}
+	expected.ExtraFilters = []ExtraFilter{{
+		Prefix: "pubsub.googleapis.com/subscription",
+		Filter: `resource.labels.subscription_id=monitoring.regex.full_match("my-subs-prefix.*")`,
+	}}
+	expected.MetricsOffset = model.Duration(time.Minute)
+	expected.AggregateDeltas = true
+	expected.MetadataSystemLabels = []string{"name", "machine_type"}
+	expected.MetadataUserLabels = []string{"*"}
+	interval := model.Duration(20 * time.Minute)
+	ingestDelay := true
+	expected.CollectionOverrides = []CollectionOverride{{
+		Match:              "bigquery.googleapis.com/",
+		MetricsInterval:    &interval,
+		MetricsIngestDelay: &ingestDelay,
+	}, {
+		Match: "loadbalancing.googleapis.com/https/request_count",
+		Aggregation: &Aggregation{
+			AlignmentPeriod:    model.Duration(time.Minute),
+			PerSeriesAligner:   "ALIGN_RATE",
+			CrossSeriesReducer: "REDUCE_SUM",
+			GroupByFields:      []string{"resource.label.backend_target_name"},
+		},
+	}}
+
+	if !reflect.DeepEqual(*cfg, expected) {
+		t.Errorf("unexpected config\nexpected: %+v\ngot:      %+v", expected, *cfg)
+	}
+}
+
+func TestLoadRelabelConfigs(t *testing.T) {
+	cfg, err := Load(`
+metrics_type_prefixes: [compute.googleapis.com/instance]
+relabel_configs:
+  - prefix: compute.googleapis.com/instance
+    rules:
+      - action: labeldrop
+        regex: instance_id
+      - source_labels: [zone]
+        regex: (.*)-[a-z]
+        target_label: region
+`, defaultTestConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(cfg.RelabelConfigs) != 1 || len(cfg.RelabelConfigs[0].Rules) != 2 {
+		t.Fatalf("expected a relabel config with 2 rules, got %+v", cfg.RelabelConfigs)
+	}
+	replace := cfg.RelabelConfigs[0].Rules[1]
+	if replace.Action != relabel.Replace || replace.Separator != ";" || replace.Replacement != "$1" || !replace.Regex.MatchString("us-central1-a") {
+		t.Errorf("expected the Prometheus defaults, got %+v", replace)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for name, input := range map[string]string{
+		"unknown field":            "metrics_type_prefixes: [a]\nunknown: true\n",
+		"no prefixes":              "project_ids: [a]\n",
+		"empty prefix":             "metrics_type_prefixes: ['']\n",
+		"incomplete filter":        "metrics_type_prefixes: [a]\nextra_filters: [{prefix: a}]\n",
+		"zero interval":            "metrics_type_prefixes: [a]\nmetrics_interval: 0s\n",
+		"invalid duration":         "metrics_type_prefixes: [a]\nmetrics_offset: soon\n",
+		"malformed yaml syntax":    "metrics_type_prefixes: [a\n",
+		"empty override match":     "metrics_type_prefixes: [a]\ncollection_overrides: [{aggregate_deltas: true}]\n",
+		"invalid override glob":    "metrics_type_prefixes: [a]\ncollection_overrides: [{match: 'a/[b'}]\n",
+		"zero override interval":   "metrics_type_prefixes: [a]\ncollection_overrides: [{match: a, metrics_interval: 0s}]\n",
+		"negative string limit":    "metrics_type_prefixes: [a]\nstring_values_limit: -1\n",
+		"empty aggregation":        "metrics_type_prefixes: [a]\ncollection_overrides: [{match: a, aggregation: {alignment_period: 1m}}]\n",
+		"unknown aligner":          "metrics_type_prefixes: [a]\ncollection_overrides: [{match: a, aggregation: {alignment_period: 1m, per_series_aligner: RATE}}]\n",
+		"reducer without aligner":  "metrics_type_prefixes: [a]\ncollection_overrides: [{match: a, aggregation: {cross_series_reducer: REDUCE_SUM}}]\n",
+		"short alignment period":   "metrics_type_prefixes: [a]\ncollection_overrides: [{match: a, aggregation: {alignment_period: 10s, per_series_aligner: ALIGN_RATE}}]\n",
+		"group by without reducer": "metrics_type_prefixes: [a]\ncollection_overrides: [{match: a, aggregation: {alignment_period: 1m, per_series_aligner: ALIGN_RATE, group_by_fields: [metric.label.a]}}]\n",
+		"unknown placeholder":      "metrics_type_prefixes: [a]\nmetric_name_template: 'gcp_{metric}'\n",
+		"template without metric":  "metrics_type_prefixes: [a]\nmetric_name_template: 'gcp_{resource_type}'\n",
+		"template without service": "metrics_type_prefixes: [a]\nmetric_name_template: 'gcp_{metric_path}'\n",
+		"unknown label collisions": "metrics_type_prefixes: [a]\nlabel_collisions: rename\n",
+		"relabel without rules":    "metrics_type_prefixes: [a]\nrelabel_configs: [{prefix: a}]\n",
+		"invalid relabel rule":     "metrics_type_prefixes: [a]\nrelabel_configs: [{prefix: a, rules: [{action: hashmod}]}]\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Load(input, defaultTestConfig); err == nil {
+				t.Errorf("expected an error for %q", input)
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(filename, []byte("metrics_type_prefixes: [compute.googleapis.com]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadFile(filename, defaultTestConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.MetricsInterval != defaultTestConfig.MetricsInterval {
+		t.Errorf("expected base interval %v to be kept, got %v", defaultTestConfig.MetricsInterval, cfg.MetricsInterval)
+	}
+
+	if _, err := LoadFile(filepath.Join(t.TempDir(), "missing.yml"), defaultTestConfig); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
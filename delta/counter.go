@@ -76,6 +76,8 @@ func (s *InMemoryCounterStore) Increment(metricDescriptor *monitoring.MetricDesc
 	if existing.ReportTime.Before(currentValue.ReportTime) {
 		level.Debug(s.logger).Log("msg", "Incrementing existing counter", "fqName", currentValue.FqName, "key", key, "current_value", existing.Value, "adding", currentValue.Value, "last_reported_time", existing.ReportTime, "incoming_time", currentValue.ReportTime)
 		currentValue.Value = currentValue.Value + existing.Value
+		// The accumulated counter was created with its first delta
+		currentValue.StartTime = existing.StartTime
 		entry.Collected[key] = currentValue
 		return
 	}
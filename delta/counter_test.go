@@ -73,6 +73,20 @@ var _ = Describe("Counter", func() {
 		Expect(metrics[0].Value).To(Equal(float64(30)))
 	})
 
+	It("keeps the start time of the first delta", func() {
+		metric.StartTime = metric.ReportTime.Add(-time.Minute)
+		store.Increment(descriptor, metric)
+
+		metric2 := *metric
+		metric2.StartTime = metric.ReportTime
+		metric2.ReportTime = metric.ReportTime.Add(time.Minute)
+		store.Increment(descriptor, &metric2)
+
+		metrics := store.ListMetrics(descriptor.Name)
+		Expect(len(metrics)).To(Equal(1))
+		Expect(metrics[0].StartTime).To(Equal(metric.StartTime))
+	})
+
 	It("will remove counters outside of TTL", func() {
 		metric.CollectionTime = metric.CollectionTime.Add(-time.Hour)
 
@@ -0,0 +1,206 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package delta
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"golang.org/x/net/context"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+)
+
+// FileCounterStore is an InMemoryCounterStore which is periodically snapshotted to a file and restored from it when
+// created, so that aggregated DELTA counters survive restarts.
+type FileCounterStore struct {
+	*InMemoryCounterStore
+	path string
+}
+
+// NewFileCounterStore returns a FileCounterStore snapshotted to path. The counters of an existing snapshot which were
+// collected within the ttl are restored.
+func NewFileCounterStore(logger log.Logger, path string, ttl time.Duration) (*FileCounterStore, error) {
+	s := &FileCounterStore{
+		InMemoryCounterStore: NewInMemoryCounterStore(logger, ttl),
+		path:                 path,
+	}
+
+	entries := map[string][]*collectors.ConstMetric{}
+	if err := readSnapshot(path, &entries); err != nil {
+		return nil, err
+	}
+	ttlWindowStart := time.Now().Add(-ttl)
+	restored := 0
+	for name, metrics := range entries {
+		entry := &MetricEntry{Collected: map[uint64]*collectors.ConstMetric{}, mutex: &sync.RWMutex{}}
+		for _, m := range metrics {
+			if ttlWindowStart.After(m.CollectionTime) {
+				continue
+			}
+			entry.Collected[toCounterKey(m)] = m
+			restored++
+		}
+		s.store.Store(name, entry)
+	}
+	level.Info(logger).Log("msg", "Restored aggregated DELTA counters", "path", path, "counters", restored)
+
+	return s, nil
+}
+
+// Snapshot writes the current counters to the file.
+func (s *FileCounterStore) Snapshot() error {
+	entries := map[string][]*collectors.ConstMetric{}
+	s.store.Range(func(key, value any) bool {
+		entry := value.(*MetricEntry)
+		entry.mutex.RLock()
+		defer entry.mutex.RUnlock()
+		for _, m := range entry.Collected {
+			metricCopy := *m
+			entries[key.(string)] = append(entries[key.(string)], &metricCopy)
+		}
+		return true
+	})
+	return writeSnapshot(s.path, entries)
+}
+
+// Run snapshots the store every interval until ctx is cancelled, and a last time when it is.
+func (s *FileCounterStore) Run(ctx context.Context, interval time.Duration) {
+	runSnapshots(ctx, s.logger, s.path, interval, s.Snapshot)
+}
+
+// FileHistogramStore is an InMemoryHistogramStore which is periodically snapshotted to a file and restored from it
+// when created, so that aggregated DELTA histograms survive restarts.
+type FileHistogramStore struct {
+	*InMemoryHistogramStore
+	path string
+}
+
+// NewFileHistogramStore returns a FileHistogramStore snapshotted to path. The histograms of an existing snapshot which
+// were collected within the ttl are restored.
+func NewFileHistogramStore(logger log.Logger, path string, ttl time.Duration) (*FileHistogramStore, error) {
+	s := &FileHistogramStore{
+		InMemoryHistogramStore: NewInMemoryHistogramStore(logger, ttl),
+		path:                   path,
+	}
+
+	entries := map[string][]*collectors.HistogramMetric{}
+	if err := readSnapshot(path, &entries); err != nil {
+		return nil, err
+	}
+	ttlWindowStart := time.Now().Add(-ttl)
+	restored := 0
+	for name, metrics := range entries {
+		entry := &HistogramEntry{Collected: map[uint64]*collectors.HistogramMetric{}, mutex: &sync.RWMutex{}}
+		for _, m := range metrics {
+			if ttlWindowStart.After(m.CollectionTime) {
+				continue
+			}
+			entry.Collected[toHistogramKey(m)] = m
+			restored++
+		}
+		s.store.Store(name, entry)
+	}
+	level.Info(logger).Log("msg", "Restored aggregated DELTA histograms", "path", path, "histograms", restored)
+
+	return s, nil
+}
+
+// Snapshot writes the current histograms to the file.
+func (s *FileHistogramStore) Snapshot() error {
+	entries := map[string][]*collectors.HistogramMetric{}
+	s.store.Range(func(key, value any) bool {
+		entry := value.(*HistogramEntry)
+		entry.mutex.RLock()
+		defer entry.mutex.RUnlock()
+		for _, m := range entry.Collected {
+			metricCopy := *m
+			metricCopy.Buckets = make(map[float64]uint64, len(m.Buckets))
+			for bound, count := range m.Buckets {
+				metricCopy.Buckets[bound] = count
+			}
+			entries[key.(string)] = append(entries[key.(string)], &metricCopy)
+		}
+		return true
+	})
+	return writeSnapshot(s.path, entries)
+}
+
+// Run snapshots the store every interval until ctx is cancelled, and a last time when it is.
+func (s *FileHistogramStore) Run(ctx context.Context, interval time.Duration) {
+	runSnapshots(ctx, s.logger, s.path, interval, s.Snapshot)
+}
+
+func runSnapshots(ctx context.Context, logger log.Logger, path string, interval time.Duration, snapshot func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := snapshot(); err != nil {
+				level.Error(logger).Log("msg", "Error writing final delta store snapshot", "path", path, "err", err)
+			}
+			return
+		case <-ticker.C:
+			if err := snapshot(); err != nil {
+				level.Error(logger).Log("msg", "Error writing delta store snapshot", "path", path, "err", err)
+			}
+		}
+	}
+}
+
+// readSnapshot decodes the snapshot at path into v. A missing snapshot is not an error.
+func readSnapshot(path string, v any) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := gob.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("decoding delta store snapshot %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeSnapshot encodes v to a temporary file which then atomically replaces the snapshot at path, so that a crash
+// while writing never leaves a truncated snapshot behind.
+func writeSnapshot(path string, v any) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := gob.NewEncoder(f).Encode(v); err != nil {
+		f.Close()
+		return fmt.Errorf("encoding delta store snapshot %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
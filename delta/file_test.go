@@ -0,0 +1,148 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package delta_test
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/common/promlog"
+	"golang.org/x/net/context"
+	"google.golang.org/api/monitoring/v3"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+	"github.com/prometheus-community/stackdriver_exporter/delta"
+)
+
+var _ = Describe("FileStore", func() {
+	var dir string
+	logger := promlog.New(&promlog.Config{})
+	descriptor := &monitoring.MetricDescriptor{Name: "This is a metric"}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "delta")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	newCounter := func(value float64, collectionTime time.Time) *collectors.ConstMetric {
+		return &collectors.ConstMetric{
+			FqName:         "counter_name",
+			LabelKeys:      []string{"labelKey"},
+			ValueType:      1,
+			Value:          value,
+			LabelValues:    []string{"labelValue"},
+			ReportTime:     collectionTime,
+			CollectionTime: collectionTime,
+		}
+	}
+
+	It("starts empty without a snapshot", func() {
+		store, err := delta.NewFileCounterStore(logger, filepath.Join(dir, "counters.gob"), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store.ListMetrics(descriptor.Name)).To(BeEmpty())
+	})
+
+	It("restores counters from a snapshot and keeps incrementing them", func() {
+		path := filepath.Join(dir, "counters.gob")
+		now := time.Now().Truncate(time.Second)
+
+		store, err := delta.NewFileCounterStore(logger, path, time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		store.Increment(descriptor, newCounter(10, now))
+		Expect(store.Snapshot()).To(Succeed())
+
+		restored, err := delta.NewFileCounterStore(logger, path, time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		restored.Increment(descriptor, newCounter(5, now.Add(time.Second)))
+
+		metrics := restored.ListMetrics(descriptor.Name)
+		Expect(len(metrics)).To(Equal(1))
+		Expect(metrics[0].Value).To(Equal(float64(15)))
+	})
+
+	It("does not restore counters outside of TTL", func() {
+		path := filepath.Join(dir, "counters.gob")
+
+		store, err := delta.NewFileCounterStore(logger, path, time.Hour)
+		Expect(err).ToNot(HaveOccurred())
+		store.Increment(descriptor, newCounter(10, time.Now().Add(-30*time.Minute)))
+		Expect(store.Snapshot()).To(Succeed())
+
+		restored, err := delta.NewFileCounterStore(logger, path, time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(restored.ListMetrics(descriptor.Name)).To(BeEmpty())
+	})
+
+	It("fails on a corrupt snapshot", func() {
+		path := filepath.Join(dir, "counters.gob")
+		Expect(os.WriteFile(path, []byte("not a snapshot"), 0o600)).To(Succeed())
+
+		_, err := delta.NewFileCounterStore(logger, path, time.Minute)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("restores histograms including the +Inf bucket", func() {
+		path := filepath.Join(dir, "histograms.gob")
+		now := time.Now().Truncate(time.Second)
+
+		store, err := delta.NewFileHistogramStore(logger, path, time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		store.Increment(descriptor, &collectors.HistogramMetric{
+			FqName:         "histogram_name",
+			LabelKeys:      []string{"labelKey"},
+			Sum:            3,
+			Count:          3,
+			Buckets:        map[float64]uint64{1: 2, math.Inf(1): 3},
+			LabelValues:    []string{"labelValue"},
+			ReportTime:     now,
+			CollectionTime: now,
+		})
+		Expect(store.Snapshot()).To(Succeed())
+
+		restored, err := delta.NewFileHistogramStore(logger, path, time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		metrics := restored.ListMetrics(descriptor.Name)
+		Expect(len(metrics)).To(Equal(1))
+		Expect(metrics[0].Buckets).To(Equal(map[float64]uint64{1: 2, math.Inf(1): 3}))
+		Expect(metrics[0].Count).To(Equal(uint64(3)))
+	})
+
+	It("writes a final snapshot when stopped", func() {
+		path := filepath.Join(dir, "counters.gob")
+
+		store, err := delta.NewFileCounterStore(logger, path, time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		store.Increment(descriptor, newCounter(10, time.Now()))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			store.Run(ctx, time.Hour)
+			close(done)
+		}()
+		cancel()
+		Eventually(done).Should(BeClosed())
+
+		Expect(path).To(BeAnExistingFile())
+	})
+})
@@ -101,23 +101,17 @@ func toHistogramKey(hist *collectors.HistogramMetric) uint64 {
 	return h
 }
 
+// mergeHistograms adds the delta current to the accumulated existing histogram and returns current. The buckets are
+// cumulative, so they can be added bound by bound, while the count and sum are running totals. The accumulated
+// histogram keeps the start time of its first delta.
 func mergeHistograms(existing *collectors.HistogramMetric, current *collectors.HistogramMetric) *collectors.HistogramMetric {
 	for key, value := range existing.Buckets {
 		current.Buckets[key] += value
 	}
 
-	// Calculate a new mean and overall count
-	mean := existing.Mean
-	mean += current.Mean
-	mean /= 2
-
-	var count uint64
-	for _, v := range current.Buckets {
-		count += v
-	}
-
-	current.Mean = mean
-	current.Count = count
+	current.Count += existing.Count
+	current.Sum += existing.Sum
+	current.StartTime = existing.StartTime
 
 	return current
 }
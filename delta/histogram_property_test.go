@@ -0,0 +1,173 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package delta_test
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing/quick"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/common/promlog"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/api/monitoring/v3"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+	"github.com/prometheus-community/stackdriver_exporter/delta"
+)
+
+// bucketBounds are the upper bounds of the histograms generated by the property tests.
+var bucketBounds = []float64{1, 10, 100, math.Inf(1)}
+
+// histogramDelta is a single DELTA distribution, as the number of observations in each bucket. Every observation is
+// given the value of its bucket's lower bound, so the sum of the distribution is known exactly.
+type histogramDelta [4]uint16
+
+func (d histogramDelta) toMetric(reportTime time.Time) *collectors.HistogramMetric {
+	buckets := map[float64]uint64{}
+	var cumulative uint64
+	var sum float64
+	for i, n := range d {
+		cumulative += uint64(n)
+		buckets[bucketBounds[i]] = cumulative
+		if i > 0 {
+			sum += float64(n) * bucketBounds[i-1]
+		}
+	}
+	return &collectors.HistogramMetric{
+		FqName:         "histogram_name",
+		LabelKeys:      []string{"labelKey"},
+		Sum:            sum,
+		Count:          cumulative,
+		Buckets:        buckets,
+		LabelValues:    []string{"labelValue"},
+		ReportTime:     reportTime,
+		CollectionTime: time.Now(),
+	}
+}
+
+// mergedEqualsSumOfDeltas returns a property checking that a store fed with a sequence of deltas reports a histogram
+// whose buckets, count and sum are the totals of the deltas.
+func mergedEqualsSumOfDeltas(newStore func() collectors.DeltaHistogramStore) func([]histogramDelta) error {
+	descriptor := &monitoring.MetricDescriptor{Name: "This is a metric"}
+
+	return func(deltas []histogramDelta) error {
+		if len(deltas) == 0 {
+			return nil
+		}
+
+		store := newStore()
+		reportTime := time.Now().Truncate(time.Second)
+		expected := &collectors.HistogramMetric{Buckets: map[float64]uint64{}}
+		for _, d := range deltas {
+			metric := d.toMetric(reportTime)
+			for bound, count := range metric.Buckets {
+				expected.Buckets[bound] += count
+			}
+			expected.Count += metric.Count
+			expected.Sum += metric.Sum
+
+			store.Increment(descriptor, metric)
+			reportTime = reportTime.Add(time.Minute)
+		}
+
+		metrics := store.ListMetrics(descriptor.Name)
+		if len(metrics) != 1 {
+			return fmt.Errorf("expected a single histogram, got %d", len(metrics))
+		}
+		merged := metrics[0]
+		for bound, count := range expected.Buckets {
+			if merged.Buckets[bound] != count {
+				return fmt.Errorf("bucket %v: expected %d, got %d", bound, count, merged.Buckets[bound])
+			}
+		}
+		if merged.Count != expected.Count {
+			return fmt.Errorf("expected count %d, got %d", expected.Count, merged.Count)
+		}
+		if merged.Count != merged.Buckets[math.Inf(1)] {
+			return fmt.Errorf("count %d does not match the +Inf bucket %d", merged.Count, merged.Buckets[math.Inf(1)])
+		}
+		if math.Abs(merged.Sum-expected.Sum) > 1e-9*math.Max(1, expected.Sum) {
+			return fmt.Errorf("expected sum %v, got %v", expected.Sum, merged.Sum)
+		}
+		return nil
+	}
+}
+
+var _ = Describe("Merged histograms", func() {
+	logger := promlog.New(&promlog.Config{})
+
+	check := func(newStore func() collectors.DeltaHistogramStore) {
+		property := mergedEqualsSumOfDeltas(newStore)
+		Expect(quick.Check(func(deltas []histogramDelta) bool {
+			if err := property(deltas); err != nil {
+				GinkgoWriter.Write([]byte(err.Error() + "\n"))
+				return false
+			}
+			return true
+		}, &quick.Config{MaxCount: 200})).To(Succeed())
+	}
+
+	It("match the sum of their deltas in memory", func() {
+		check(func() collectors.DeltaHistogramStore {
+			return delta.NewInMemoryHistogramStore(logger, time.Hour)
+		})
+	})
+
+	It("match the sum of their deltas when restored from a file", func() {
+		dir, err := os.MkdirTemp("", "delta")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		check(func() collectors.DeltaHistogramStore {
+			path := filepath.Join(dir, "histograms.gob")
+			os.Remove(path)
+			store, err := delta.NewFileHistogramStore(logger, path, time.Hour)
+			Expect(err).ToNot(HaveOccurred())
+			return &restoringHistogramStore{FileHistogramStore: store, path: path}
+		})
+	})
+
+	It("match the sum of their deltas in Redis", func() {
+		server, err := miniredis.Run()
+		Expect(err).ToNot(HaveOccurred())
+		defer server.Close()
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		defer client.Close()
+
+		check(func() collectors.DeltaHistogramStore {
+			server.FlushAll()
+			return delta.NewRedisHistogramStore(logger, client, "test:", time.Hour)
+		})
+	})
+})
+
+// restoringHistogramStore snapshots and restores the store before listing its metrics, so that the property also
+// covers the round trip through the snapshot file.
+type restoringHistogramStore struct {
+	*delta.FileHistogramStore
+	path string
+}
+
+func (s *restoringHistogramStore) ListMetrics(metricDescriptorName string) []*collectors.HistogramMetric {
+	Expect(s.Snapshot()).To(Succeed())
+	restored, err := delta.NewFileHistogramStore(promlog.New(&promlog.Config{}), s.path, time.Hour)
+	Expect(err).ToNot(HaveOccurred())
+	return restored.ListMetrics(metricDescriptorName)
+}
@@ -35,7 +35,7 @@ var _ = Describe("HistogramStore", func() {
 		histogram = &collectors.HistogramMetric{
 			FqName:         "histogram_name",
 			LabelKeys:      []string{"labelKey"},
-			Mean:           10,
+			Sum:            1000,
 			Count:          100,
 			Buckets:        map[float64]uint64{1.00000000000000000001: 1000},
 			LabelValues:    []string{"labelValue"},
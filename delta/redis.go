@@ -0,0 +1,233 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package delta
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+	"google.golang.org/api/monitoring/v3"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+)
+
+// redisMaxRetries is how often an update is retried when another replica modified the same metric descriptor
+// concurrently.
+const redisMaxRetries = 10
+
+// redisStore keeps the tracked metrics of every metric descriptor in a Redis hash, keyed by the hash of their labels.
+// Updates are optimistic transactions so that a sample reported by several replicas is only added once.
+type redisStore struct {
+	client    redis.UniversalClient
+	keyPrefix string
+	ttl       time.Duration
+	logger    log.Logger
+}
+
+func (s *redisStore) key(metricDescriptorName string) string {
+	return s.keyPrefix + metricDescriptorName
+}
+
+// update replaces the field of key with the result of merge, which receives the current value or nil. When merge
+// returns nil the field is left untouched.
+func (s *redisStore) update(key, field string, merge func(existing []byte) ([]byte, error)) error {
+	ctx := context.Background()
+	for i := 0; i < redisMaxRetries; i++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			existing, err := tx.HGet(ctx, key, field).Bytes()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return err
+			}
+			updated, err := merge(existing)
+			if err != nil || updated == nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, key, field, updated)
+				if s.ttl > 0 {
+					// Metric descriptors which are no longer reported expire as a whole
+					pipe.Expire(ctx, key, s.ttl)
+				}
+				return nil
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("giving up updating %s after %d concurrent modifications", key, redisMaxRetries)
+}
+
+// listRedis decodes every field of key. Fields which were collected outside of the TTL of the store, or cannot be
+// decoded, are deleted.
+func listRedis[T any](s *redisStore, key string, collectionTime func(*T) time.Time) ([]*T, error) {
+	ctx := context.Background()
+	values, err := s.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var output []*T
+	var expired []string
+	ttlWindowStart := time.Now().Add(-s.ttl)
+	for field, value := range values {
+		metric := new(T)
+		if err := decodeGob([]byte(value), metric); err != nil {
+			level.Error(s.logger).Log("msg", "Error decoding delta store entry, deleting it", "key", key, "field", field, "err", err)
+			expired = append(expired, field)
+			continue
+		}
+		if ttlWindowStart.After(collectionTime(metric)) {
+			level.Debug(s.logger).Log("msg", "Deleting delta store entry outside of TTL", "key", key, "field", field)
+			expired = append(expired, field)
+			continue
+		}
+		output = append(output, metric)
+	}
+	if len(expired) > 0 {
+		if err := s.client.HDel(ctx, key, expired...).Err(); err != nil {
+			return output, err
+		}
+	}
+	return output, nil
+}
+
+func encodeGob(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeGob(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// RedisCounterStore is a DeltaCounterStore kept in Redis, so that every exporter replica using the same Redis and key
+// prefix exports the same accumulated values.
+type RedisCounterStore struct {
+	redisStore
+}
+
+// NewRedisCounterStore returns a RedisCounterStore storing its counters under keys starting with keyPrefix.
+func NewRedisCounterStore(logger log.Logger, client redis.UniversalClient, keyPrefix string, ttl time.Duration) *RedisCounterStore {
+	return &RedisCounterStore{
+		redisStore: redisStore{client: client, keyPrefix: keyPrefix + "counters:", ttl: ttl, logger: logger},
+	}
+}
+
+func (s *RedisCounterStore) Increment(metricDescriptor *monitoring.MetricDescriptor, currentValue *collectors.ConstMetric) {
+	if currentValue == nil {
+		return
+	}
+
+	key := toCounterKey(currentValue)
+	err := s.update(s.key(metricDescriptor.Name), strconv.FormatUint(key, 10), func(data []byte) ([]byte, error) {
+		updated := *currentValue
+		if data != nil {
+			var existing collectors.ConstMetric
+			if err := decodeGob(data, &existing); err != nil {
+				return nil, err
+			}
+			if !existing.ReportTime.Before(currentValue.ReportTime) {
+				level.Debug(s.logger).Log("msg", "Ignoring old sample for counter", "fqName", currentValue.FqName, "key", key, "last_reported_time", existing.ReportTime, "incoming_time", currentValue.ReportTime)
+				return nil, nil
+			}
+			level.Debug(s.logger).Log("msg", "Incrementing existing counter", "fqName", currentValue.FqName, "key", key, "current_value", existing.Value, "adding", currentValue.Value, "last_reported_time", existing.ReportTime, "incoming_time", currentValue.ReportTime)
+			updated.Value += existing.Value
+			updated.StartTime = existing.StartTime
+		} else {
+			level.Debug(s.logger).Log("msg", "Tracking new counter", "fqName", currentValue.FqName, "key", key, "current_value", currentValue.Value, "incoming_time", currentValue.ReportTime)
+		}
+		return encodeGob(&updated)
+	})
+	if err != nil {
+		level.Error(s.logger).Log("msg", "Error incrementing counter in Redis", "fqName", currentValue.FqName, "key", key, "err", err)
+	}
+}
+
+func (s *RedisCounterStore) ListMetrics(metricDescriptorName string) []*collectors.ConstMetric {
+	output, err := listRedis(&s.redisStore, s.key(metricDescriptorName), func(m *collectors.ConstMetric) time.Time {
+		return m.CollectionTime
+	})
+	if err != nil {
+		level.Error(s.logger).Log("msg", "Error listing counters from Redis", "descriptor", metricDescriptorName, "err", err)
+	}
+	return output
+}
+
+// RedisHistogramStore is a DeltaHistogramStore kept in Redis, so that every exporter replica using the same Redis and
+// key prefix exports the same accumulated values.
+type RedisHistogramStore struct {
+	redisStore
+}
+
+// NewRedisHistogramStore returns a RedisHistogramStore storing its histograms under keys starting with keyPrefix.
+func NewRedisHistogramStore(logger log.Logger, client redis.UniversalClient, keyPrefix string, ttl time.Duration) *RedisHistogramStore {
+	return &RedisHistogramStore{
+		redisStore: redisStore{client: client, keyPrefix: keyPrefix + "histograms:", ttl: ttl, logger: logger},
+	}
+}
+
+func (s *RedisHistogramStore) Increment(metricDescriptor *monitoring.MetricDescriptor, currentValue *collectors.HistogramMetric) {
+	if currentValue == nil {
+		return
+	}
+
+	key := toHistogramKey(currentValue)
+	err := s.update(s.key(metricDescriptor.Name), strconv.FormatUint(key, 10), func(data []byte) ([]byte, error) {
+		updated := *currentValue
+		updated.Buckets = make(map[float64]uint64, len(currentValue.Buckets))
+		for bound, count := range currentValue.Buckets {
+			updated.Buckets[bound] = count
+		}
+		if data != nil {
+			var existing collectors.HistogramMetric
+			if err := decodeGob(data, &existing); err != nil {
+				return nil, err
+			}
+			if !existing.ReportTime.Before(currentValue.ReportTime) {
+				level.Debug(s.logger).Log("msg", "Ignoring old sample for histogram", "fqName", currentValue.FqName, "key", key, "last_reported_time", existing.ReportTime, "incoming_time", currentValue.ReportTime)
+				return nil, nil
+			}
+			level.Debug(s.logger).Log("msg", "Incrementing existing histogram", "fqName", currentValue.FqName, "key", key, "last_reported_time", existing.ReportTime, "incoming_time", currentValue.ReportTime)
+			return encodeGob(mergeHistograms(&existing, &updated))
+		}
+		level.Debug(s.logger).Log("msg", "Tracking new histogram", "fqName", currentValue.FqName, "key", key, "incoming_time", currentValue.ReportTime)
+		return encodeGob(&updated)
+	})
+	if err != nil {
+		level.Error(s.logger).Log("msg", "Error incrementing histogram in Redis", "fqName", currentValue.FqName, "key", key, "err", err)
+	}
+}
+
+func (s *RedisHistogramStore) ListMetrics(metricDescriptorName string) []*collectors.HistogramMetric {
+	output, err := listRedis(&s.redisStore, s.key(metricDescriptorName), func(m *collectors.HistogramMetric) time.Time {
+		return m.CollectionTime
+	})
+	if err != nil {
+		level.Error(s.logger).Log("msg", "Error listing histograms from Redis", "descriptor", metricDescriptorName, "err", err)
+	}
+	return output
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package delta_test
+
+import (
+	"math"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/common/promlog"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/api/monitoring/v3"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+	"github.com/prometheus-community/stackdriver_exporter/delta"
+)
+
+var _ = Describe("RedisStore", func() {
+	var server *miniredis.Miniredis
+	var client *redis.Client
+	logger := promlog.New(&promlog.Config{})
+	descriptor := &monitoring.MetricDescriptor{Name: "This is a metric"}
+
+	BeforeEach(func() {
+		var err error
+		server, err = miniredis.Run()
+		Expect(err).ToNot(HaveOccurred())
+		client = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	})
+
+	AfterEach(func() {
+		client.Close()
+		server.Close()
+	})
+
+	newCounter := func(value float64, reportTime time.Time) *collectors.ConstMetric {
+		return &collectors.ConstMetric{
+			FqName:         "counter_name",
+			LabelKeys:      []string{"labelKey"},
+			ValueType:      1,
+			Value:          value,
+			LabelValues:    []string{"labelValue"},
+			ReportTime:     reportTime,
+			CollectionTime: time.Now(),
+		}
+	}
+
+	It("shares counters between replicas and only adds a sample once", func() {
+		replicaA := delta.NewRedisCounterStore(logger, client, "test:", time.Minute)
+		replicaB := delta.NewRedisCounterStore(logger, client, "test:", time.Minute)
+		now := time.Now().Truncate(time.Second)
+
+		replicaA.Increment(descriptor, newCounter(10, now))
+		replicaB.Increment(descriptor, newCounter(10, now))
+		replicaB.Increment(descriptor, newCounter(5, now.Add(time.Second)))
+		replicaA.Increment(descriptor, newCounter(5, now.Add(time.Second)))
+
+		for _, store := range []*delta.RedisCounterStore{replicaA, replicaB} {
+			metrics := store.ListMetrics(descriptor.Name)
+			Expect(len(metrics)).To(Equal(1))
+			Expect(metrics[0].Value).To(Equal(float64(15)))
+		}
+	})
+
+	It("does not mutate the incremented counter", func() {
+		store := delta.NewRedisCounterStore(logger, client, "test:", time.Minute)
+		now := time.Now().Truncate(time.Second)
+
+		store.Increment(descriptor, newCounter(10, now))
+		metric := newCounter(5, now.Add(time.Second))
+		store.Increment(descriptor, metric)
+
+		Expect(metric.Value).To(Equal(float64(5)))
+	})
+
+	It("isolates key prefixes", func() {
+		store := delta.NewRedisCounterStore(logger, client, "a:", time.Minute)
+		other := delta.NewRedisCounterStore(logger, client, "b:", time.Minute)
+
+		store.Increment(descriptor, newCounter(10, time.Now()))
+
+		Expect(other.ListMetrics(descriptor.Name)).To(BeEmpty())
+	})
+
+	It("will remove counters outside of TTL", func() {
+		store := delta.NewRedisCounterStore(logger, client, "test:", time.Minute)
+		metric := newCounter(10, time.Now())
+		metric.CollectionTime = metric.CollectionTime.Add(-time.Hour)
+
+		store.Increment(descriptor, metric)
+
+		Expect(store.ListMetrics(descriptor.Name)).To(BeEmpty())
+		Expect(server.Keys()).To(BeEmpty())
+	})
+
+	It("expires metric descriptors which are no longer reported", func() {
+		store := delta.NewRedisCounterStore(logger, client, "test:", time.Minute)
+		store.Increment(descriptor, newCounter(10, time.Now()))
+
+		server.FastForward(2 * time.Minute)
+
+		Expect(server.Keys()).To(BeEmpty())
+	})
+
+	It("merges histograms including the +Inf bucket", func() {
+		replicaA := delta.NewRedisHistogramStore(logger, client, "test:", time.Minute)
+		replicaB := delta.NewRedisHistogramStore(logger, client, "test:", time.Minute)
+		now := time.Now().Truncate(time.Second)
+		newHistogram := func(reportTime time.Time) *collectors.HistogramMetric {
+			return &collectors.HistogramMetric{
+				FqName:         "histogram_name",
+				LabelKeys:      []string{"labelKey"},
+				Sum:            3,
+				Count:          3,
+				Buckets:        map[float64]uint64{1: 2, math.Inf(1): 3},
+				LabelValues:    []string{"labelValue"},
+				ReportTime:     reportTime,
+				CollectionTime: time.Now(),
+			}
+		}
+
+		replicaA.Increment(descriptor, newHistogram(now))
+		replicaB.Increment(descriptor, newHistogram(now))
+		replicaB.Increment(descriptor, newHistogram(now.Add(time.Second)))
+
+		metrics := replicaA.ListMetrics(descriptor.Name)
+		Expect(len(metrics)).To(Equal(1))
+		Expect(metrics[0].Buckets).To(Equal(map[float64]uint64{1: 4, math.Inf(1): 6}))
+	})
+})
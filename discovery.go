@@ -0,0 +1,83 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+
+	"github.com/prometheus-community/stackdriver_exporter/utils"
+)
+
+var (
+	projectsDiscoveredMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "stackdriver",
+		Subsystem: "projects",
+		Name:      "discovered",
+		Help:      "Number of Google Projects matching the projects filter in the last successful discovery.",
+	})
+
+	projectsDiscoveryErrorsMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "stackdriver",
+		Subsystem: "projects",
+		Name:      "discovery_errors_total",
+		Help:      "Total number of errors while discovering Google Projects matching the projects filter.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(projectsDiscoveredMetric, projectsDiscoveryErrorsMetric)
+}
+
+// discoverProjectIDs returns the IDs of the projects matching the filter and records the outcome in the discovery
+// metrics.
+func discoverProjectIDs(ctx context.Context, filter string) ([]string, error) {
+	projectIDs, err := utils.GetProjectIDsFromFilter(ctx, filter)
+	if err != nil {
+		projectsDiscoveryErrorsMetric.Inc()
+		return nil, fmt.Errorf("failed to get project IDs from filter: %w", err)
+	}
+	projectsDiscoveredMetric.Set(float64(len(projectIDs)))
+	return projectIDs, nil
+}
+
+// refreshProjectIDs periodically re-resolves the project IDs with resolve and updates the projects served by h, until
+// ctx is cancelled.
+func refreshProjectIDs(ctx context.Context, h *handler, resolve func(context.Context) ([]string, error), interval time.Duration, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		projectIDs, err := resolve(ctx)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error refreshing Google Cloud Project IDs", "err", err)
+			continue
+		}
+		level.Debug(logger).Log("msg", "Refreshed Google Cloud Project IDs", "projectIDs", fmt.Sprintf("%v", projectIDs))
+		if err := h.setProjectIDs(projectIDs); err != nil {
+			level.Error(logger).Log("msg", "Error creating the collectors of the refreshed Google Cloud Project IDs", "err", err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/promlog"
+	"golang.org/x/net/context"
+)
+
+// fakeLister returns its results in turn, the last one repeatedly.
+type fakeLister struct {
+	mtx     sync.Mutex
+	results []fakeListResult
+}
+
+type fakeListResult struct {
+	projectIDs []string
+	err        error
+}
+
+func (l *fakeLister) resolve(context.Context) ([]string, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	result := l.results[0]
+	if len(l.results) > 1 {
+		l.results = l.results[1:]
+	}
+	return result.projectIDs, result.err
+}
+
+func TestRefreshProjectIDs(t *testing.T) {
+	factory := newCollectorFactory(t)
+	h, err := newTestHandler(t, []string{"project-a", "project-b"}, testConfig("compute.googleapis.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	collectorB := h.collectors[collectorKey("project-b", h.metricsPrefixes)]
+
+	lister := &fakeLister{results: []fakeListResult{
+		{err: errors.New("discovery failed")},
+		{projectIDs: []string{"project-b", "project-c"}},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		refreshProjectIDs(ctx, h, lister.resolve, 10*time.Millisecond, promlog.New(&promlog.Config{}))
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !reflect.DeepEqual(h.servedProjectIDs(), []string{"project-b", "project-c"}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the discovered projects to be served, got %v", h.servedProjectIDs())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if h.hasCollectors("project-a") {
+		t.Error("expected the collectors of a removed project to be dropped")
+	}
+	if _, ok := h.stores.counters["project-a"]; ok {
+		t.Error("expected the delta stores of a removed project to be dropped")
+	}
+	if _, ok := h.trackers["project-a"]; ok {
+		t.Error("expected the sample tracker of a removed project to be dropped")
+	}
+	if h.collectors[collectorKey("project-b", h.metricsPrefixes)] != collectorB || factory.created["project-b"] != 1 {
+		t.Error("expected the collector of a project which is still served to be kept")
+	}
+	if !h.hasCollectors("project-c") {
+		t.Error("expected a collector to be created for an added project")
+	}
+}
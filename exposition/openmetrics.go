@@ -0,0 +1,94 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exposition
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+const typePrefix = "# TYPE "
+
+// WriteOpenMetrics writes the metric families in the OpenMetrics text format, with the created timestamps of counters
+// and histograms as `_created` samples, adding `# UNIT` metadata to the families found in units by metric name. As
+// OpenMetrics requires the name of a family with a unit to end with it, the unit is left out of families which do not.
+// As it requires the samples of counters to end with `_total`, the suffix is appended to the names of counters which
+// do not end with it already, which would be typed unknown otherwise.
+// @see https://github.com/OpenObservability/OpenMetrics/blob/main/specification/OpenMetrics.md#unit
+func WriteOpenMetrics(w io.Writer, families []*dto.MetricFamily, units map[string]string) error {
+	bw := bufio.NewWriter(w)
+	var buf bytes.Buffer
+	for _, mf := range families {
+		unit, ok := units[mf.GetName()]
+		if mf.GetType() == dto.MetricType_COUNTER && !strings.HasSuffix(mf.GetName(), "_total") {
+			mf = withName(mf, mf.GetName()+"_total")
+		}
+		buf.Reset()
+		if _, err := expfmt.MetricFamilyToOpenMetrics(&buf, mf, expfmt.WithCreatedLines()); err != nil {
+			return err
+		}
+		if !ok {
+			if _, err := bw.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeWithUnit(bw, buf.Bytes(), unit); err != nil {
+			return err
+		}
+	}
+	if _, err := expfmt.FinalizeOpenMetrics(bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// withName returns a shallow copy of a metric family with another name.
+func withName(mf *dto.MetricFamily, name string) *dto.MetricFamily {
+	return &dto.MetricFamily{Name: &name, Help: mf.Help, Type: mf.Type, Unit: mf.Unit, Metric: mf.Metric}
+}
+
+// writeWithUnit writes an encoded metric family with a UNIT line after its TYPE line. The family name is taken from the
+// TYPE line, as the `_total` suffix of counters is not part of it.
+func writeWithUnit(w io.Writer, family []byte, unit string) error {
+	for len(family) > 0 {
+		line := family
+		if i := bytes.IndexByte(family, '\n'); i >= 0 {
+			line = family[:i+1]
+		}
+		family = family[len(line):]
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+
+		if !bytes.HasPrefix(line, []byte(typePrefix)) {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(string(line), typePrefix), " ")
+		if strings.HasSuffix(name, "_"+unit) {
+			if _, err := fmt.Fprintf(w, "# UNIT %s %s\n", name, unit); err != nil {
+				return err
+			}
+		}
+		_, err := w.Write(family)
+		return err
+	}
+	return nil
+}
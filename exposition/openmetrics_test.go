@@ -0,0 +1,83 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exposition
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWriteOpenMetrics(t *testing.T) {
+	created := time.Unix(1700000000, 0)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectorFunc(func(ch chan<- prometheus.Metric) {
+		ch <- prometheus.MustNewConstHistogramWithCreatedTimestamp(prometheus.NewDesc("latency_seconds", "Latency.", nil, nil), 1, 0.5, map[float64]uint64{1: 1}, created)
+		ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(prometheus.NewDesc("requests_bytes_total", "Requests.", nil, nil), prometheus.CounterValue, 0, created)
+		// Counter samples must end with _total
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("sent_bytes", "Sent.", nil, nil), prometheus.CounterValue, 2)
+		// The unit does not end the name, so it must be left out
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("usage", "Usage.", nil, nil), prometheus.GaugeValue, 0)
+	}))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	err = WriteOpenMetrics(&b, families, map[string]string{
+		"latency_seconds":      "seconds",
+		"requests_bytes_total": "bytes",
+		"sent_bytes":           "bytes",
+		"usage":                "ratio",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+# UNIT latency_seconds seconds
+latency_seconds_bucket{le="1.0"} 1
+latency_seconds_bucket{le="+Inf"} 1
+latency_seconds_sum 0.5
+latency_seconds_count 1
+latency_seconds_created 1.7e+09
+# HELP requests_bytes Requests.
+# TYPE requests_bytes counter
+# UNIT requests_bytes bytes
+requests_bytes_total 0.0
+requests_bytes_created 1.7e+09
+# HELP sent_bytes Sent.
+# TYPE sent_bytes counter
+# UNIT sent_bytes bytes
+sent_bytes_total 2.0
+# HELP usage Usage.
+# TYPE usage gauge
+usage 0.0
+# EOF
+`
+	if b.String() != expected {
+		t.Errorf("unexpected output\nexpected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
+
+// collectorFunc collects the metrics of a function, which it does not describe.
+type collectorFunc func(ch chan<- prometheus.Metric)
+
+func (f collectorFunc) Describe(ch chan<- *prometheus.Desc) {}
+
+func (f collectorFunc) Collect(ch chan<- prometheus.Metric) { f(ch) }
@@ -0,0 +1,133 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"path"
+	"strconv"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+)
+
+// Lister lists the Compute Engine resources of a project.
+type Lister interface {
+	Instances(ctx context.Context, projectID string) ([]*Instance, error)
+	Disks(ctx context.Context, projectID string) ([]*Disk, error)
+	// InstanceGroups returns the names of the instance groups of every instance, by instance key.
+	InstanceGroups(ctx context.Context, projectID string) (map[string][]string, error)
+}
+
+type computeLister struct {
+	service *compute.Service
+}
+
+// NewComputeLister returns a Lister using the Compute Engine API.
+func NewComputeLister(service *compute.Service) Lister {
+	return &computeLister{service: service}
+}
+
+func (l *computeLister) Instances(ctx context.Context, projectID string) ([]*Instance, error) {
+	var instances []*Instance
+	err := l.service.Instances.AggregatedList(projectID).Context(ctx).Pages(ctx, func(page *compute.InstanceAggregatedList) error {
+		for _, scoped := range page.Items {
+			for _, i := range scoped.Instances {
+				instance := &Instance{
+					ID:          strconv.FormatUint(i.Id, 10),
+					Name:        i.Name,
+					Zone:        path.Base(i.Zone),
+					MachineType: path.Base(i.MachineType),
+					Labels:      i.Labels,
+				}
+				if i.Tags != nil {
+					instance.Tags = i.Tags.Items
+				}
+				instances = append(instances, instance)
+			}
+		}
+		return nil
+	})
+	return instances, err
+}
+
+func (l *computeLister) Disks(ctx context.Context, projectID string) ([]*Disk, error) {
+	var disks []*Disk
+	err := l.service.Disks.AggregatedList(projectID).Context(ctx).Pages(ctx, func(page *compute.DiskAggregatedList) error {
+		for _, scoped := range page.Items {
+			for _, d := range scoped.Disks {
+				disk := &Disk{
+					ID:     strconv.FormatUint(d.Id, 10),
+					Name:   d.Name,
+					Zone:   path.Base(d.Zone),
+					Type:   path.Base(d.Type),
+					Labels: d.Labels,
+				}
+				// Regional disks have a region instead of a zone
+				if d.Zone == "" {
+					disk.Zone = path.Base(d.Region)
+				}
+				for _, user := range d.Users {
+					disk.Instances = append(disk.Instances, path.Base(user))
+				}
+				disks = append(disks, disk)
+			}
+		}
+		return nil
+	})
+	return disks, err
+}
+
+func (l *computeLister) InstanceGroups(ctx context.Context, projectID string) (map[string][]string, error) {
+	var groups []*compute.InstanceGroup
+	err := l.service.InstanceGroups.AggregatedList(projectID).Context(ctx).Pages(ctx, func(page *compute.InstanceGroupAggregatedList) error {
+		for _, scoped := range page.Items {
+			groups = append(groups, scoped.InstanceGroups...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string][]string)
+	addMembers := func(group string, items []*compute.InstanceWithNamedPorts) {
+		for _, item := range items {
+			key := urlInstanceKey(item.Instance)
+			members[key] = append(members[key], group)
+		}
+	}
+	for _, group := range groups {
+		if group.Zone != "" {
+			err = l.service.InstanceGroups.ListInstances(projectID, path.Base(group.Zone), group.Name, &compute.InstanceGroupsListInstancesRequest{}).Context(ctx).Pages(ctx, func(page *compute.InstanceGroupsListInstances) error {
+				addMembers(group.Name, page.Items)
+				return nil
+			})
+		} else {
+			err = l.service.RegionInstanceGroups.ListInstances(projectID, path.Base(group.Region), group.Name, &compute.RegionInstanceGroupsListInstancesRequest{}).Context(ctx).Pages(ctx, func(page *compute.RegionInstanceGroupsListInstances) error {
+				addMembers(group.Name, page.Items)
+				return nil
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return members, nil
+}
+
+// urlInstanceKey returns the key of the instance of a URL ending with `zones/<zone>/instances/<name>`.
+func urlInstanceKey(url string) string {
+	zone := path.Base(path.Dir(path.Dir(url)))
+	return instanceKey(zone, path.Base(url))
+}
@@ -0,0 +1,346 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package inventory caches the Compute Engine resources of the served projects, so that the metrics of instances can
+// be enriched with their name, machine type, network tags and labels.
+package inventory
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+
+	"github.com/prometheus-community/stackdriver_exporter/utils"
+)
+
+const (
+	namespace = "stackdriver"
+	subsystem = "gce_inventory"
+
+	// userLabelPrefix is prepended to the keys of the labels assigned to instances and disks.
+	userLabelPrefix = "label_"
+)
+
+// Instance is a Compute Engine instance.
+type Instance struct {
+	ID          string
+	Name        string
+	Zone        string
+	MachineType string
+	Tags        []string
+	Labels      map[string]string
+	// InstanceGroups are only listed with Options.InstanceGroups.
+	InstanceGroups []string
+}
+
+// Disk is a Compute Engine persistent disk.
+type Disk struct {
+	ID     string
+	Name   string
+	Zone   string
+	Type   string
+	Labels map[string]string
+	// Instances are the names of the instances the disk is attached to.
+	Instances []string
+}
+
+func instanceKey(zone, name string) string {
+	return zone + "/" + name
+}
+
+// Options control which resources are listed.
+type Options struct {
+	// Disks also lists the persistent disks of every project.
+	Disks bool
+	// InstanceGroups also lists the instance groups of every project and their instances, which costs an API call per
+	// instance group.
+	InstanceGroups bool
+}
+
+// projectInventory is the inventory of a project at its last successful refresh.
+type projectInventory struct {
+	instances map[string]*Instance
+	disks     []*Disk
+	// instanceLabelKeys and diskLabelKeys are the sanitized keys of every user label of the instances and disks.
+	instanceLabelKeys []string
+	diskLabelKeys     []string
+}
+
+// Inventory periodically lists the Compute Engine resources of the served projects. Projects which could not be
+// listed keep their previous inventory.
+type Inventory struct {
+	logger log.Logger
+	lister Lister
+	opts   Options
+
+	mtx      sync.RWMutex
+	projects map[string]*projectInventory
+
+	refreshErrorsTotal *prometheus.CounterVec
+	lastRefreshMetric  *prometheus.GaugeVec
+	instancesMetric    *prometheus.GaugeVec
+}
+
+func New(logger log.Logger, lister Lister, opts Options) *Inventory {
+	return &Inventory{
+		logger:   logger,
+		lister:   lister,
+		opts:     opts,
+		projects: make(map[string]*projectInventory),
+
+		refreshErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "refresh_errors_total",
+			Help:      "Total number of errors while listing the Compute Engine resources of a project.",
+		}, []string{"project_id"}),
+		lastRefreshMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "last_refresh_timestamp_seconds",
+			Help:      "Number of seconds since 1970 since the last successful listing of the Compute Engine resources of a project.",
+		}, []string{"project_id"}),
+		instancesMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "instances",
+			Help:      "Number of Compute Engine instances of a project in the inventory.",
+		}, []string{"project_id"}),
+	}
+}
+
+// Run refreshes the inventory of the projects returned by projectIDs every interval until ctx is cancelled.
+func (inv *Inventory) Run(ctx context.Context, interval time.Duration, projectIDs func() []string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		inv.Refresh(ctx, projectIDs())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh lists the resources of every project and forgets the projects which are not given anymore.
+func (inv *Inventory) Refresh(ctx context.Context, projectIDs []string) {
+	served := make(map[string]bool, len(projectIDs))
+	for _, projectID := range projectIDs {
+		served[projectID] = true
+		p, err := inv.list(ctx, projectID)
+		if err != nil {
+			inv.refreshErrorsTotal.WithLabelValues(projectID).Inc()
+			level.Error(inv.logger).Log("msg", "Error listing Compute Engine resources, keeping the previous inventory", "project_id", projectID, "err", err)
+			continue
+		}
+		inv.mtx.Lock()
+		inv.projects[projectID] = p
+		inv.mtx.Unlock()
+		inv.lastRefreshMetric.WithLabelValues(projectID).SetToCurrentTime()
+		inv.instancesMetric.WithLabelValues(projectID).Set(float64(len(p.instances)))
+	}
+
+	inv.mtx.Lock()
+	defer inv.mtx.Unlock()
+	for projectID := range inv.projects {
+		if !served[projectID] {
+			delete(inv.projects, projectID)
+			inv.lastRefreshMetric.DeleteLabelValues(projectID)
+			inv.instancesMetric.DeleteLabelValues(projectID)
+		}
+	}
+}
+
+func (inv *Inventory) list(ctx context.Context, projectID string) (*projectInventory, error) {
+	instances, err := inv.lister.Instances(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	var groups map[string][]string
+	if inv.opts.InstanceGroups {
+		if groups, err = inv.lister.InstanceGroups(ctx, projectID); err != nil {
+			return nil, err
+		}
+	}
+	p := &projectInventory{instances: make(map[string]*Instance, len(instances))}
+	collisions := 0
+	for _, instance := range instances {
+		instance.InstanceGroups = groups[instanceKey(instance.Zone, instance.Name)]
+		p.instances[instance.ID] = instance
+		p.instanceLabelKeys = appendLabelKeys(p.instanceLabelKeys, instance.Labels)
+		_, dropped := sanitizeLabels(instance.Labels)
+		collisions += dropped
+	}
+	if inv.opts.Disks {
+		if p.disks, err = inv.lister.Disks(ctx, projectID); err != nil {
+			return nil, err
+		}
+		for _, disk := range p.disks {
+			p.diskLabelKeys = appendLabelKeys(p.diskLabelKeys, disk.Labels)
+			_, dropped := sanitizeLabels(disk.Labels)
+			collisions += dropped
+		}
+	}
+	if collisions > 0 {
+		level.Warn(inv.logger).Log("msg", "Labels of Compute Engine resources have the same sanitized name, only the first one by key is kept in the info metrics", "project_id", projectID, "dropped", collisions)
+	}
+	sort.Strings(p.instanceLabelKeys)
+	sort.Strings(p.diskLabelKeys)
+	return p, nil
+}
+
+// appendLabelKeys appends the sanitized keys of labels which are not in keys yet.
+func appendLabelKeys(keys []string, labels map[string]string) []string {
+	for key := range labels {
+		name := userLabelPrefix + utils.SanitizeLabelName(key)
+		found := false
+		for _, k := range keys {
+			if k == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// sortedKeys returns the keys of labels in ascending order.
+func sortedKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sanitizeLabels returns the user labels by sanitized key. Of the labels whose keys are sanitized to the same name, ie
+// `team-a` and `team_a`, the first one by key is kept and the number of dropped ones is returned.
+func sanitizeLabels(labels map[string]string) (map[string]string, int) {
+	sanitized := make(map[string]string, len(labels))
+	dropped := 0
+	for _, key := range sortedKeys(labels) {
+		name := userLabelPrefix + utils.SanitizeLabelName(key)
+		if _, ok := sanitized[name]; ok {
+			dropped++
+			continue
+		}
+		sanitized[name] = labels[key]
+	}
+	return sanitized, dropped
+}
+
+// userLabels returns the values of the user labels for every sanitized key of keys, empty if a label is not set.
+func userLabels(keys []string, labels map[string]string) []string {
+	sanitized, _ := sanitizeLabels(labels)
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = sanitized[key]
+	}
+	return values
+}
+
+// InstanceLabels returns the labels joined onto the series of an instance as parallel keys and values slices, or nil
+// if the instance is unknown. User labels follow in the order of their keys. Their sanitized keys can repeat, so that
+// the caller handles the collisions.
+func (inv *Inventory) InstanceLabels(projectID, instanceID string) ([]string, []string) {
+	inv.mtx.RLock()
+	defer inv.mtx.RUnlock()
+
+	p, ok := inv.projects[projectID]
+	if !ok {
+		return nil, nil
+	}
+	instance, ok := p.instances[instanceID]
+	if !ok {
+		return nil, nil
+	}
+	keys := []string{"instance_name", "machine_type", "network_tags"}
+	values := []string{instance.Name, instance.MachineType, strings.Join(instance.Tags, ",")}
+	if inv.opts.InstanceGroups {
+		keys = append(keys, "instance_groups")
+		values = append(values, strings.Join(instance.InstanceGroups, ","))
+	}
+	for _, key := range sortedKeys(instance.Labels) {
+		keys = append(keys, userLabelPrefix+utils.SanitizeLabelName(key))
+		values = append(values, instance.Labels[key])
+	}
+	return keys, values
+}
+
+// CollectProject collects the stackdriver_gce_instance_info and stackdriver_gce_disk_info metrics of a project.
+func (inv *Inventory) CollectProject(projectID string, ch chan<- prometheus.Metric) {
+	inv.mtx.RLock()
+	defer inv.mtx.RUnlock()
+
+	p, ok := inv.projects[projectID]
+	if !ok {
+		return
+	}
+
+	instanceLabels := []string{"project_id", "zone", "instance_id", "instance_name", "machine_type", "network_tags"}
+	if inv.opts.InstanceGroups {
+		instanceLabels = append(instanceLabels, "instance_groups")
+	}
+	instanceDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "gce", "instance_info"),
+		"Information about a Compute Engine instance, always 1.",
+		append(instanceLabels, p.instanceLabelKeys...),
+		nil,
+	)
+	for _, instance := range p.instances {
+		values := []string{projectID, instance.Zone, instance.ID, instance.Name, instance.MachineType, strings.Join(instance.Tags, ",")}
+		if inv.opts.InstanceGroups {
+			values = append(values, strings.Join(instance.InstanceGroups, ","))
+		}
+		values = append(values, userLabels(p.instanceLabelKeys, instance.Labels)...)
+		ch <- prometheus.MustNewConstMetric(instanceDesc, prometheus.GaugeValue, 1, values...)
+	}
+
+	if !inv.opts.Disks {
+		return
+	}
+	diskDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "gce", "disk_info"),
+		"Information about a Compute Engine persistent disk, always 1.",
+		append([]string{"project_id", "zone", "disk_id", "disk_name", "disk_type", "instance_names"}, p.diskLabelKeys...),
+		nil,
+	)
+	for _, disk := range p.disks {
+		values := append([]string{projectID, disk.Zone, disk.ID, disk.Name, disk.Type, strings.Join(disk.Instances, ",")}, userLabels(p.diskLabelKeys, disk.Labels)...)
+		ch <- prometheus.MustNewConstMetric(diskDesc, prometheus.GaugeValue, 1, values...)
+	}
+}
+
+func (inv *Inventory) Describe(ch chan<- *prometheus.Desc) {
+	inv.refreshErrorsTotal.Describe(ch)
+	inv.lastRefreshMetric.Describe(ch)
+	inv.instancesMetric.Describe(ch)
+}
+
+func (inv *Inventory) Collect(ch chan<- prometheus.Metric) {
+	inv.refreshErrorsTotal.Collect(ch)
+	inv.lastRefreshMetric.Collect(ch)
+	inv.instancesMetric.Collect(ch)
+}
@@ -0,0 +1,163 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promlog"
+	"golang.org/x/net/context"
+)
+
+type fakeLister struct {
+	instances map[string][]*Instance
+	disks     map[string][]*Disk
+	groups    map[string]map[string][]string
+	err       error
+}
+
+func (l *fakeLister) Instances(_ context.Context, projectID string) ([]*Instance, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	// Return copies, as the inventory sets the instance groups of the instances
+	var instances []*Instance
+	for _, instance := range l.instances[projectID] {
+		i := *instance
+		instances = append(instances, &i)
+	}
+	return instances, nil
+}
+
+func (l *fakeLister) Disks(_ context.Context, projectID string) ([]*Disk, error) {
+	return l.disks[projectID], l.err
+}
+
+func (l *fakeLister) InstanceGroups(_ context.Context, projectID string) (map[string][]string, error) {
+	return l.groups[projectID], l.err
+}
+
+func testLister() *fakeLister {
+	return &fakeLister{
+		instances: map[string][]*Instance{
+			"project-a": {
+				{ID: "1", Name: "web-1", Zone: "europe-west1-b", MachineType: "e2-small", Tags: []string{"http", "ssh"}, Labels: map[string]string{"team": "search", "cost-center": "42"}},
+				{ID: "2", Name: "db-1", Zone: "europe-west1-c", MachineType: "n2-standard-4"},
+			},
+		},
+		disks: map[string][]*Disk{
+			"project-a": {
+				{ID: "10", Name: "web-1", Zone: "europe-west1-b", Type: "pd-balanced", Instances: []string{"web-1"}, Labels: map[string]string{"team": "search"}},
+			},
+		},
+		groups: map[string]map[string][]string{
+			"project-a": {"europe-west1-b/web-1": {"web"}},
+		},
+	}
+}
+
+func TestInstanceLabels(t *testing.T) {
+	inv := New(promlog.New(&promlog.Config{}), testLister(), Options{InstanceGroups: true})
+	inv.Refresh(context.Background(), []string{"project-a"})
+
+	keys, values := inv.InstanceLabels("project-a", "1")
+	expectedKeys := []string{"instance_name", "machine_type", "network_tags", "instance_groups", "label_cost_center", "label_team"}
+	expectedValues := []string{"web-1", "e2-small", "http,ssh", "web", "42", "search"}
+	if !reflect.DeepEqual(keys, expectedKeys) || !reflect.DeepEqual(values, expectedValues) {
+		t.Errorf("expected %v=%v, got %v=%v", expectedKeys, expectedValues, keys, values)
+	}
+	if keys, _ := inv.InstanceLabels("project-a", "3"); keys != nil {
+		t.Errorf("expected no labels for an unknown instance, got %v", keys)
+	}
+	if keys, _ := inv.InstanceLabels("project-b", "1"); keys != nil {
+		t.Errorf("expected no labels for an unknown project, got %v", keys)
+	}
+}
+
+func TestCollectProject(t *testing.T) {
+	inv := New(promlog.New(&promlog.Config{}), testLister(), Options{Disks: true})
+	inv.Refresh(context.Background(), []string{"project-a"})
+
+	collector := collectorFunc(func(ch chan<- prometheus.Metric) { inv.CollectProject("project-a", ch) })
+	expected := `
+# HELP stackdriver_gce_disk_info Information about a Compute Engine persistent disk, always 1.
+# TYPE stackdriver_gce_disk_info gauge
+stackdriver_gce_disk_info{disk_id="10",disk_name="web-1",disk_type="pd-balanced",instance_names="web-1",label_team="search",project_id="project-a",zone="europe-west1-b"} 1
+# HELP stackdriver_gce_instance_info Information about a Compute Engine instance, always 1.
+# TYPE stackdriver_gce_instance_info gauge
+stackdriver_gce_instance_info{instance_id="1",instance_name="web-1",label_cost_center="42",label_team="search",machine_type="e2-small",network_tags="http,ssh",project_id="project-a",zone="europe-west1-b"} 1
+stackdriver_gce_instance_info{instance_id="2",instance_name="db-1",label_cost_center="",label_team="",machine_type="n2-standard-4",network_tags="",project_id="project-a",zone="europe-west1-c"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLabelCollisions(t *testing.T) {
+	lister := &fakeLister{instances: map[string][]*Instance{
+		"project-a": {{ID: "1", Name: "web-1", Zone: "europe-west1-b", Labels: map[string]string{"team_a": "search", "team-a": "ads"}}},
+	}}
+	inv := New(promlog.New(&promlog.Config{}), lister, Options{})
+	inv.Refresh(context.Background(), []string{"project-a"})
+
+	// The colliding labels are left to the collision strategy of the collectors
+	keys, values := inv.InstanceLabels("project-a", "1")
+	if !reflect.DeepEqual(keys[3:], []string{"label_team_a", "label_team_a"}) || !reflect.DeepEqual(values[3:], []string{"ads", "search"}) {
+		t.Errorf("expected both colliding labels in the order of their keys, got %v=%v", keys, values)
+	}
+
+	// The info metric keeps the first one by key
+	collector := collectorFunc(func(ch chan<- prometheus.Metric) { inv.CollectProject("project-a", ch) })
+	expected := `
+# HELP stackdriver_gce_instance_info Information about a Compute Engine instance, always 1.
+# TYPE stackdriver_gce_instance_info gauge
+stackdriver_gce_instance_info{instance_id="1",instance_name="web-1",label_team_a="ads",machine_type="",network_tags="",project_id="project-a",zone="europe-west1-b"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefreshKeepsPreviousInventory(t *testing.T) {
+	lister := testLister()
+	inv := New(promlog.New(&promlog.Config{}), lister, Options{})
+	inv.Refresh(context.Background(), []string{"project-a"})
+
+	lister.err = errors.New("quota exceeded")
+	inv.Refresh(context.Background(), []string{"project-a"})
+	if keys, _ := inv.InstanceLabels("project-a", "1"); keys == nil {
+		t.Error("expected the previous inventory to be kept after an error")
+	}
+	if errs := testutil.ToFloat64(inv.refreshErrorsTotal.WithLabelValues("project-a")); errs != 1 {
+		t.Errorf("expected 1 refresh error, got %v", errs)
+	}
+
+	lister.err = nil
+	inv.Refresh(context.Background(), []string{"project-b"})
+	if keys, _ := inv.InstanceLabels("project-a", "1"); keys != nil {
+		t.Errorf("expected the inventory of a project not served anymore to be forgotten, got %v", keys)
+	}
+}
+
+// collectorFunc collects the metrics of a function, which it does not describe.
+type collectorFunc func(ch chan<- prometheus.Metric)
+
+func (f collectorFunc) Describe(ch chan<- *prometheus.Desc) {}
+
+func (f collectorFunc) Collect(ch chan<- prometheus.Metric) { f(ch) }
@@ -0,0 +1,145 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/common/version"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxErrorBodyBytes is how much of the body of a failed HTTP request is included in its error.
+const maxErrorBodyBytes = 512
+
+// Client sends export requests to an OTLP endpoint and returns the number of data points it rejected.
+type Client interface {
+	Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (rejected int64, err error)
+}
+
+// recoverableError is an error after which the request can be retried.
+type recoverableError struct {
+	error
+}
+
+type grpcClient struct {
+	client  colmetricspb.MetricsServiceClient
+	headers metadata.MD
+	timeout time.Duration
+}
+
+// NewGRPCClient returns a Client exporting over gRPC to endpoint, a host:port. TLS is used unless insecure is set.
+func NewGRPCClient(endpoint string, insecureTransport bool, headers map[string]string, timeout time.Duration) (Client, error) {
+	creds := credentials.NewTLS(&tls.Config{})
+	if insecureTransport {
+		creds = insecure.NewCredentials()
+	}
+	conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(creds), grpc.WithUserAgent("stackdriver_exporter/"+version.Version))
+	if err != nil {
+		return nil, err
+	}
+	return &grpcClient{
+		client:  colmetricspb.NewMetricsServiceClient(conn),
+		headers: metadata.New(headers),
+		timeout: timeout,
+	}, nil
+}
+
+func (c *grpcClient) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (int64, error) {
+	ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(ctx, c.headers), c.timeout)
+	defer cancel()
+
+	resp, err := c.client.Export(ctx, req)
+	if err != nil {
+		// @see https://opentelemetry.io/docs/specs/otlp/#failures
+		switch status.Code(err) {
+		case codes.Canceled, codes.DeadlineExceeded, codes.Aborted, codes.OutOfRange, codes.Unavailable, codes.DataLoss, codes.ResourceExhausted:
+			return 0, recoverableError{err}
+		}
+		return 0, err
+	}
+	return resp.GetPartialSuccess().GetRejectedDataPoints(), nil
+}
+
+type httpClient struct {
+	url        string
+	headers    map[string]string
+	timeout    time.Duration
+	httpClient *http.Client
+}
+
+// NewHTTPClient returns a Client exporting binary protobuf over HTTP to url, ie http://localhost:4318/v1/metrics.
+func NewHTTPClient(url string, headers map[string]string, timeout time.Duration) Client {
+	return &httpClient{url: url, headers: headers, timeout: timeout, httpClient: &http.Client{}}
+}
+
+func (c *httpClient) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return 0, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", "stackdriver_exporter/"+version.Version)
+	for key, value := range c.headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return 0, recoverableError{err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return 0, err
+		}
+		var exportResp colmetricspb.ExportMetricsServiceResponse
+		if err := proto.Unmarshal(respBody, &exportResp); err != nil {
+			return 0, fmt.Errorf("decoding export response: %w", err)
+		}
+		return exportResp.GetPartialSuccess().GetRejectedDataPoints(), nil
+	}
+
+	line, _ := bufio.NewReader(io.LimitReader(resp.Body, maxErrorBodyBytes)).ReadString('\n')
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace([]byte(line)))
+	switch resp.StatusCode {
+	// @see https://opentelemetry.io/docs/specs/otlp/#failures-1
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return 0, recoverableError{err}
+	}
+	return 0, err
+}
@@ -0,0 +1,383 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/api/monitoring/v3"
+
+	"github.com/prometheus-community/stackdriver_exporter/hash"
+)
+
+const (
+	// Native histogram schemas, which are the scales of OTLP exponential histograms.
+	minScale = -4
+	maxScale = 8
+
+	// scaleTolerance absorbs the rounding of the exponential bucket options returned by the API.
+	scaleTolerance = 1e-9
+
+	// trackerRetention is how long the newest exported point of a series which is no longer returned by the API is
+	// remembered.
+	trackerRetention = 24 * time.Hour
+)
+
+// pointTracker remembers the end time of the newest point exported for every series, so that a point is only exported
+// once although it is returned by the API for every requested interval it is part of.
+type pointTracker struct {
+	mtx    sync.Mutex
+	newest map[uint64]time.Time
+	seen   map[uint64]time.Time
+}
+
+func newPointTracker() *pointTracker {
+	return &pointTracker{newest: map[uint64]time.Time{}, seen: map[uint64]time.Time{}}
+}
+
+// newer returns the points ending after the newest exported point of the series with the given key, and records the
+// newest of them as exported. When all is false only the newest point is returned.
+func (t *pointTracker) newer(key uint64, points []point, all bool, now time.Time) []point {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.seen[key] = now
+	newest, ok := t.newest[key]
+	var output []point
+	for _, p := range points {
+		if !ok || p.end.After(newest) {
+			output = append(output, p)
+		}
+	}
+	if len(output) == 0 {
+		return nil
+	}
+	if !all {
+		output = output[len(output)-1:]
+	}
+	t.newest[key] = output[len(output)-1].end
+	return output
+}
+
+// prune forgets the series which were last seen before the given time.
+func (t *pointTracker) prune(before time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for key, seen := range t.seen {
+		if seen.Before(before) {
+			delete(t.seen, key)
+			delete(t.newest, key)
+		}
+	}
+}
+
+type point struct {
+	start, end time.Time
+	value      *monitoring.TypedValue
+}
+
+// sortedPoints returns the points of timeSeries in ascending end time order.
+func sortedPoints(timeSeries *monitoring.TimeSeries) ([]point, error) {
+	points := make([]point, 0, len(timeSeries.Points))
+	for _, p := range timeSeries.Points {
+		end, err := time.Parse(time.RFC3339Nano, p.Interval.EndTime)
+		if err != nil {
+			return nil, err
+		}
+		var start time.Time
+		if p.Interval.StartTime != "" {
+			if start, err = time.Parse(time.RFC3339Nano, p.Interval.StartTime); err != nil {
+				return nil, err
+			}
+		}
+		points = append(points, point{start: start, end: end, value: p.Value})
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].end.Before(points[j].end)
+	})
+	return points, nil
+}
+
+func seriesKey(timeSeries *monitoring.TimeSeries) uint64 {
+	h := hash.New()
+	h = hash.Add(h, timeSeries.Resource.Type)
+	h = addLabels(h, timeSeries.Resource.Labels)
+	h = hash.AddByte(h, hash.SeparatorByte)
+	h = hash.Add(h, timeSeries.Metric.Type)
+	return addLabels(h, timeSeries.Metric.Labels)
+}
+
+func addLabels(h uint64, labels map[string]string) uint64 {
+	for _, key := range sortedKeys(labels) {
+		h = hash.AddByte(h, hash.SeparatorByte)
+		h = hash.Add(h, key)
+		h = hash.AddByte(h, hash.SeparatorByte)
+		h = hash.Add(h, labels[key])
+	}
+	return h
+}
+
+func sortedKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func attributes(labels map[string]string) []*commonpb.KeyValue {
+	attrs := make([]*commonpb.KeyValue, 0, len(labels))
+	for _, key := range sortedKeys(labels) {
+		attrs = append(attrs, stringAttribute(key, labels[key]))
+	}
+	return attrs
+}
+
+func stringAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+// newResource returns the OTLP resource of a monitored resource. Its labels become attributes next to the resource
+// type and the project.
+func newResource(projectID string, resource *monitoring.MonitoredResource) *resourcepb.Resource {
+	attrs := []*commonpb.KeyValue{
+		stringAttribute("cloud.provider", "gcp"),
+		stringAttribute("cloud.account.id", projectID),
+		stringAttribute("gcp.resource_type", resource.Type),
+	}
+	return &resourcepb.Resource{Attributes: append(attrs, attributes(resource.Labels)...)}
+}
+
+// newMetric returns an OTLP metric holding points of timeSeries, or nil when its kind or value type has no OTLP
+// equivalent. GAUGE metrics become gauges and DELTA and CUMULATIVE metrics become sums with the matching temporality,
+// only CUMULATIVE ones being monotonic as DELTA points can be negative. DISTRIBUTION metrics become exponential
+// histograms when their buckets match an exponential histogram scale and explicit bucket histograms otherwise.
+func newMetric(descriptor *monitoring.MetricDescriptor, timeSeries *monitoring.TimeSeries, points []point) *metricspb.Metric {
+	metric := &metricspb.Metric{
+		Name:        timeSeries.Metric.Type,
+		Description: descriptor.Description,
+		Unit:        descriptor.Unit,
+	}
+	attrs := attributes(timeSeries.Metric.Labels)
+
+	temporality := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	switch timeSeries.MetricKind {
+	case "GAUGE", "DELTA":
+	case "CUMULATIVE":
+		temporality = metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	default:
+		return nil
+	}
+
+	switch timeSeries.ValueType {
+	case "BOOL", "INT64", "DOUBLE", "MONEY":
+		var dataPoints []*metricspb.NumberDataPoint
+		for _, p := range points {
+			dp := &metricspb.NumberDataPoint{Attributes: attrs, TimeUnixNano: unixNano(p.end)}
+			if timeSeries.MetricKind != "GAUGE" {
+				dp.StartTimeUnixNano = unixNano(p.start)
+			}
+			switch {
+			case p.value.BoolValue != nil:
+				dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: boolToInt(*p.value.BoolValue)}
+			case p.value.Int64Value != nil:
+				dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: *p.value.Int64Value}
+			case p.value.DoubleValue != nil:
+				dp.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: *p.value.DoubleValue}
+			default:
+				continue
+			}
+			dataPoints = append(dataPoints, dp)
+		}
+		if len(dataPoints) == 0 {
+			return nil
+		}
+		if timeSeries.MetricKind == "GAUGE" {
+			metric.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: dataPoints}}
+		} else {
+			metric.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				DataPoints:             dataPoints,
+				AggregationTemporality: temporality,
+				IsMonotonic:            timeSeries.MetricKind == "CUMULATIVE",
+			}}
+		}
+	case "DISTRIBUTION":
+		var (
+			histogramPoints   []*metricspb.HistogramDataPoint
+			exponentialPoints []*metricspb.ExponentialHistogramDataPoint
+		)
+		for _, p := range points {
+			dist := p.value.DistributionValue
+			if dist == nil || dist.BucketOptions == nil {
+				continue
+			}
+			if dp := newExponentialHistogramDataPoint(dist, attrs, p); dp != nil {
+				exponentialPoints = append(exponentialPoints, dp)
+				continue
+			}
+			// A metric has a single representation, so the whole series falls back to explicit buckets as soon as
+			// one of its points cannot be exported as an exponential histogram
+			exponentialPoints = nil
+			break
+		}
+		if exponentialPoints == nil {
+			for _, p := range points {
+				dist := p.value.DistributionValue
+				if dist == nil || dist.BucketOptions == nil {
+					continue
+				}
+				if dp := newHistogramDataPoint(dist, attrs, p); dp != nil {
+					histogramPoints = append(histogramPoints, dp)
+				}
+			}
+		}
+		switch {
+		case len(exponentialPoints) > 0:
+			metric.Data = &metricspb.Metric_ExponentialHistogram{ExponentialHistogram: &metricspb.ExponentialHistogram{
+				DataPoints:             exponentialPoints,
+				AggregationTemporality: temporality,
+			}}
+		case len(histogramPoints) > 0:
+			metric.Data = &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				DataPoints:             histogramPoints,
+				AggregationTemporality: temporality,
+			}}
+		default:
+			return nil
+		}
+	default:
+		return nil
+	}
+	return metric
+}
+
+// newHistogramDataPoint returns an explicit bucket histogram point. Stackdriver and OTLP share the same bucket layout:
+// N bounds delimit N+1 buckets, the first one being the underflow and the last one the overflow bucket.
+// @see https://cloud.google.com/monitoring/api/ref_v3/rest/v3/TypedValue#bucketoptions
+func newHistogramDataPoint(dist *monitoring.Distribution, attrs []*commonpb.KeyValue, p point) *metricspb.HistogramDataPoint {
+	opts := dist.BucketOptions
+	var bounds []float64
+	switch {
+	case opts.ExplicitBuckets != nil:
+		bounds = opts.ExplicitBuckets.Bounds
+	case opts.LinearBuckets != nil:
+		for i := int64(0); i <= opts.LinearBuckets.NumFiniteBuckets; i++ {
+			bounds = append(bounds, opts.LinearBuckets.Offset+float64(i)*opts.LinearBuckets.Width)
+		}
+	case opts.ExponentialBuckets != nil:
+		for i := int64(0); i <= opts.ExponentialBuckets.NumFiniteBuckets; i++ {
+			bounds = append(bounds, opts.ExponentialBuckets.Scale*math.Pow(opts.ExponentialBuckets.GrowthFactor, float64(i)))
+		}
+	default:
+		return nil
+	}
+
+	dp := &metricspb.HistogramDataPoint{
+		Attributes:        attrs,
+		StartTimeUnixNano: unixNano(p.start),
+		TimeUnixNano:      unixNano(p.end),
+		Count:             uint64(dist.Count),
+		BucketCounts:      bucketCounts(dist.BucketCounts, len(bounds)+1),
+		ExplicitBounds:    bounds,
+	}
+	setSumMinMax(dist, &dp.Sum, &dp.Min, &dp.Max)
+	return dp
+}
+
+// newExponentialHistogramDataPoint returns an exponential histogram point, or nil when the exponential buckets of the
+// distribution do not match an OTLP scale. Buckets match when the growth factor is 2^(2^-scale) and the scale of the
+// distribution, the lower bound of its first finite bucket, is one of the boundaries of that OTLP scale. The
+// underflow bucket becomes the zero bucket. The overflow bucket has no upper bound, which exponential buckets cannot
+// express, so distributions with observations in it are left to explicit bucket histograms.
+// @see https://opentelemetry.io/docs/specs/otel/metrics/data-model/#exponentialhistogram
+func newExponentialHistogramDataPoint(dist *monitoring.Distribution, attrs []*commonpb.KeyValue, p point) *metricspb.ExponentialHistogramDataPoint {
+	exp := dist.BucketOptions.ExponentialBuckets
+	if exp == nil || exp.GrowthFactor <= 1 || exp.Scale <= 0 {
+		return nil
+	}
+	scale := -math.Log2(math.Log2(exp.GrowthFactor))
+	if math.Abs(scale-math.Round(scale)) > scaleTolerance || math.Round(scale) < minScale || math.Round(scale) > maxScale {
+		return nil
+	}
+	scale = math.Round(scale)
+	// The first finite bucket starts at the scale, which is the lower boundary of the OTLP bucket with this index
+	offset := math.Log2(exp.Scale) * math.Exp2(scale)
+	if math.Abs(offset-math.Round(offset)) > scaleTolerance {
+		return nil
+	}
+
+	offset = math.Round(offset)
+
+	counts := bucketCounts(dist.BucketCounts, int(exp.NumFiniteBuckets)+2)
+	if counts[len(counts)-1] > 0 {
+		return nil
+	}
+	dp := &metricspb.ExponentialHistogramDataPoint{
+		Attributes:        attrs,
+		StartTimeUnixNano: unixNano(p.start),
+		TimeUnixNano:      unixNano(p.end),
+		Count:             uint64(dist.Count),
+		Scale:             int32(scale),
+		ZeroCount:         counts[0],
+		// The underflow observations are below the lower bound of the first positive bucket
+		ZeroThreshold: math.Exp2(offset / math.Exp2(scale)),
+		Positive: &metricspb.ExponentialHistogramDataPoint_Buckets{
+			Offset:       int32(offset),
+			BucketCounts: counts[1 : len(counts)-1],
+		},
+	}
+	setSumMinMax(dist, &dp.Sum, &dp.Min, &dp.Max)
+	return dp
+}
+
+// bucketCounts returns the bucket counts of a distribution padded to n buckets, as trailing empty buckets are omitted
+// by the API.
+func bucketCounts(counts []int64, n int) []uint64 {
+	output := make([]uint64, n)
+	for i := 0; i < n && i < len(counts); i++ {
+		output[i] = uint64(counts[i])
+	}
+	return output
+}
+
+func setSumMinMax(dist *monitoring.Distribution, sum, min, max **float64) {
+	s := dist.Mean * float64(dist.Count)
+	*sum = &s
+	if dist.Range != nil {
+		lo, hi := dist.Range.Min, dist.Range.Max
+		*min, *max = &lo, &hi
+	}
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/api/monitoring/v3"
+)
+
+func testTimeSeries(kind, valueType string, values ...*monitoring.TypedValue) *monitoring.TimeSeries {
+	ts := &monitoring.TimeSeries{
+		Metric:     &monitoring.Metric{Type: "compute.googleapis.com/instance/cpu/usage_time", Labels: map[string]string{"instance_name": "a"}},
+		Resource:   &monitoring.MonitoredResource{Type: "gce_instance", Labels: map[string]string{"zone": "us-central1-a"}},
+		MetricKind: kind,
+		ValueType:  valueType,
+	}
+	// The API returns the newest point first
+	for i := len(values) - 1; i >= 0; i-- {
+		ts.Points = append(ts.Points, &monitoring.Point{
+			Interval: &monitoring.TimeInterval{
+				StartTime: time.Unix(1000, 0).UTC().Format(time.RFC3339Nano),
+				EndTime:   time.Unix(int64(1060+60*i), 0).UTC().Format(time.RFC3339Nano),
+			},
+			Value: values[i],
+		})
+	}
+	return ts
+}
+
+func doubleValue(v float64) *monitoring.TypedValue {
+	return &monitoring.TypedValue{DoubleValue: &v}
+}
+
+func convert(t *testing.T, ts *monitoring.TimeSeries) *metricspb.Metric {
+	t.Helper()
+	points, err := sortedPoints(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newMetric(&monitoring.MetricDescriptor{Unit: "s"}, ts, points)
+}
+
+func TestNewMetricKinds(t *testing.T) {
+	gauge := convert(t, testTimeSeries("GAUGE", "DOUBLE", doubleValue(1)))
+	if gauge.GetGauge() == nil || gauge.Unit != "s" {
+		t.Fatalf("expected a gauge in seconds, got %v", gauge)
+	}
+	if dp := gauge.GetGauge().DataPoints[0]; dp.StartTimeUnixNano != 0 || dp.TimeUnixNano != uint64(time.Unix(1060, 0).UnixNano()) {
+		t.Errorf("unexpected gauge point times %d-%d", dp.StartTimeUnixNano, dp.TimeUnixNano)
+	}
+
+	for kind, temporality := range map[string]metricspb.AggregationTemporality{
+		"DELTA":      metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+		"CUMULATIVE": metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+	} {
+		sum := convert(t, testTimeSeries(kind, "DOUBLE", doubleValue(1), doubleValue(2))).GetSum()
+		if sum == nil || sum.AggregationTemporality != temporality {
+			t.Fatalf("%s: expected a sum with temporality %s, got %v", kind, temporality, sum)
+		}
+		if monotonic := kind == "CUMULATIVE"; sum.IsMonotonic != monotonic {
+			t.Errorf("%s: expected monotonic to be %v", kind, monotonic)
+		}
+		if len(sum.DataPoints) != 2 || sum.DataPoints[1].GetAsDouble() != 2 {
+			t.Fatalf("%s: expected the points in ascending order, got %v", kind, sum.DataPoints)
+		}
+		if start := sum.DataPoints[0].StartTimeUnixNano; start != uint64(time.Unix(1000, 0).UnixNano()) {
+			t.Errorf("%s: expected the start time of the interval, got %d", kind, start)
+		}
+	}
+
+	b := true
+	if v := convert(t, testTimeSeries("GAUGE", "BOOL", &monitoring.TypedValue{BoolValue: &b})).GetGauge().DataPoints[0].GetAsInt(); v != 1 {
+		t.Errorf("expected true to be exported as 1, got %d", v)
+	}
+	s := "value"
+	if m := convert(t, testTimeSeries("GAUGE", "STRING", &monitoring.TypedValue{StringValue: &s})); m != nil {
+		t.Errorf("expected STRING metrics not to be exported, got %v", m)
+	}
+}
+
+func TestNewMetricDistributions(t *testing.T) {
+	// Growth factor 2^(2^-1) matches scale 1, and 2 = 2^(2/2) is the lower boundary of the bucket with index 2
+	exponential := convert(t, testTimeSeries("DELTA", "DISTRIBUTION", &monitoring.TypedValue{DistributionValue: &monitoring.Distribution{
+		Count:         4,
+		Mean:          2.5,
+		BucketOptions: &monitoring.BucketOptions{ExponentialBuckets: &monitoring.Exponential{NumFiniteBuckets: 3, GrowthFactor: 1.4142135623730951, Scale: 2}},
+		BucketCounts:  []int64{1, 0, 3},
+	}})).GetExponentialHistogram()
+	if exponential == nil {
+		t.Fatal("expected an exponential histogram")
+	}
+	dp := exponential.DataPoints[0]
+	if dp.Scale != 1 || dp.ZeroCount != 1 || dp.ZeroThreshold != 2 || dp.Positive.Offset != 2 || dp.GetSum() != 10 {
+		t.Errorf("unexpected exponential histogram point %v", dp)
+	}
+	if !reflect.DeepEqual(dp.Positive.BucketCounts, []uint64{0, 3, 0}) {
+		t.Errorf("expected the finite buckets, got %v", dp.Positive.BucketCounts)
+	}
+
+	// Observations in the overflow bucket have no upper bound, which only explicit bucket histograms can express
+	overflow := convert(t, testTimeSeries("DELTA", "DISTRIBUTION", &monitoring.TypedValue{DistributionValue: &monitoring.Distribution{
+		Count:         5,
+		BucketOptions: &monitoring.BucketOptions{ExponentialBuckets: &monitoring.Exponential{NumFiniteBuckets: 3, GrowthFactor: 1.4142135623730951, Scale: 2}},
+		BucketCounts:  []int64{1, 0, 3, 0, 1},
+	}}))
+	if overflow.GetExponentialHistogram() != nil || overflow.GetHistogram() == nil {
+		t.Fatalf("expected an explicit bucket histogram for a distribution with overflow observations, got %v", overflow)
+	}
+	if counts := overflow.GetHistogram().DataPoints[0].BucketCounts; !reflect.DeepEqual(counts, []uint64{1, 0, 3, 0, 1}) {
+		t.Errorf("expected the overflow bucket to be kept, got %v", counts)
+	}
+
+	// A single point with overflow observations turns the whole series into an explicit bucket histogram
+	mixed := convert(t, testTimeSeries("DELTA", "DISTRIBUTION", &monitoring.TypedValue{DistributionValue: &monitoring.Distribution{
+		Count:         4,
+		BucketOptions: &monitoring.BucketOptions{ExponentialBuckets: &monitoring.Exponential{NumFiniteBuckets: 3, GrowthFactor: 1.4142135623730951, Scale: 2}},
+		BucketCounts:  []int64{1, 0, 3},
+	}}, &monitoring.TypedValue{DistributionValue: &monitoring.Distribution{
+		Count:         5,
+		BucketOptions: &monitoring.BucketOptions{ExponentialBuckets: &monitoring.Exponential{NumFiniteBuckets: 3, GrowthFactor: 1.4142135623730951, Scale: 2}},
+		BucketCounts:  []int64{1, 0, 3, 0, 1},
+	}}, &monitoring.TypedValue{DistributionValue: &monitoring.Distribution{
+		Count:         2,
+		BucketOptions: &monitoring.BucketOptions{ExponentialBuckets: &monitoring.Exponential{NumFiniteBuckets: 3, GrowthFactor: 1.4142135623730951, Scale: 2}},
+		BucketCounts:  []int64{0, 2},
+	}}))
+	if mixed.GetExponentialHistogram() != nil || mixed.GetHistogram() == nil {
+		t.Fatalf("expected an explicit bucket histogram for a series with overflow observations, got %v", mixed)
+	}
+	if points := mixed.GetHistogram().DataPoints; len(points) != 3 || points[0].Count != 4 || points[1].Count != 5 || points[2].Count != 2 {
+		t.Errorf("expected every point of the series to be kept, got %v", points)
+	}
+
+	explicit := convert(t, testTimeSeries("CUMULATIVE", "DISTRIBUTION", &monitoring.TypedValue{DistributionValue: &monitoring.Distribution{
+		Count:         3,
+		BucketOptions: &monitoring.BucketOptions{LinearBuckets: &monitoring.Linear{NumFiniteBuckets: 2, Width: 10, Offset: 0}},
+		BucketCounts:  []int64{0, 1, 2},
+	}})).GetHistogram()
+	if explicit == nil || explicit.AggregationTemporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		t.Fatalf("expected a cumulative histogram, got %v", explicit)
+	}
+	hdp := explicit.DataPoints[0]
+	if !reflect.DeepEqual(hdp.ExplicitBounds, []float64{0, 10, 20}) || !reflect.DeepEqual(hdp.BucketCounts, []uint64{0, 1, 2, 0}) {
+		t.Errorf("unexpected histogram buckets %v %v", hdp.ExplicitBounds, hdp.BucketCounts)
+	}
+}
+
+func TestPointTracker(t *testing.T) {
+	points, err := sortedPoints(testTimeSeries("GAUGE", "DOUBLE", doubleValue(1), doubleValue(2), doubleValue(3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := newPointTracker()
+	now := time.Unix(2000, 0)
+
+	if got := tracker.newer(1, points[:2], true, now); len(got) != 2 {
+		t.Errorf("expected both points of the first collection, got %d", len(got))
+	}
+	if got := tracker.newer(1, points, true, now); len(got) != 1 || *got[0].value.DoubleValue != 3 {
+		t.Errorf("expected only the new point, got %v", got)
+	}
+	if got := tracker.newer(1, points, true, now); len(got) != 0 {
+		t.Errorf("expected no point to be exported twice, got %v", got)
+	}
+	if got := tracker.newer(2, points, false, now); len(got) != 1 || *got[0].value.DoubleValue != 3 {
+		t.Errorf("expected only the newest point, got %v", got)
+	}
+
+	tracker.prune(now.Add(time.Second))
+	if len(tracker.newest) != 0 || len(tracker.seen) != 0 {
+		t.Errorf("expected the series to be pruned, got %v", tracker.newest)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"golang.org/x/net/context"
+	"google.golang.org/api/monitoring/v3"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+)
+
+const (
+	namespace = "stackdriver"
+	subsystem = "otlp"
+
+	scopeName = "github.com/prometheus-community/stackdriver_exporter"
+
+	// maxResourceMetricsPerExport is the maximum number of resource metrics sent in a single request.
+	maxResourceMetricsPerExport = 1000
+)
+
+// Options control how converted metrics are queued and retried.
+type Options struct {
+	// QueueCapacity is the maximum number of resource metrics waiting to be exported.
+	QueueCapacity int
+	// MaxRetries is how often a request failing with a recoverable error is retried before its metrics are dropped.
+	MaxRetries int
+	// MinBackoff is the delay before the first retry, doubled on every retry up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Exporter is a collectors.TimeSeriesSink converting the collected time series to OTLP metrics and exporting them to
+// an OpenTelemetry collector. Only the points which were not exported yet are sent, so that the points returned for
+// overlapping requested intervals are exported once.
+type Exporter struct {
+	logger  log.Logger
+	client  Client
+	opts    Options
+	tracker *pointTracker
+	queue   chan *metricspb.ResourceMetrics
+
+	exportedDataPointsTotal prometheus.Counter
+	failedDataPointsTotal   prometheus.Counter
+	droppedDataPointsTotal  prometheus.Counter
+}
+
+func NewExporter(logger log.Logger, client Client, opts Options) *Exporter {
+	newCounter := func(name, help string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{Namespace: namespace, Subsystem: subsystem, Name: name, Help: help})
+	}
+	return &Exporter{
+		logger:  logger,
+		client:  client,
+		opts:    opts,
+		tracker: newPointTracker(),
+		queue:   make(chan *metricspb.ResourceMetrics, opts.QueueCapacity),
+
+		exportedDataPointsTotal: newCounter("exported_data_points_total", "Total number of data points successfully exported to the OTLP endpoint."),
+		failedDataPointsTotal:   newCounter("failed_data_points_total", "Total number of data points dropped after the OTLP endpoint rejected them or retries were exhausted."),
+		droppedDataPointsTotal:  newCounter("dropped_data_points_total", "Total number of data points dropped because the OTLP export queue was full."),
+	}
+}
+
+// AppendTimeSeries converts the new points of timeSeries and queues them for export. Only the newest point of a series
+// is exported unless the metric type is collected with all points. As it cannot block the collection, metrics which do
+// not fit in the queue are dropped.
+func (e *Exporter) AppendTimeSeries(projectID string, descriptor *monitoring.MetricDescriptor, settings collectors.CollectionSettings, timeSeries []*monitoring.TimeSeries) {
+	now := time.Now()
+	for _, ts := range timeSeries {
+		points, err := sortedPoints(ts)
+		if err != nil {
+			level.Error(e.logger).Log("msg", "Error parsing time series points for OTLP export", "metric", ts.Metric.Type, "err", err)
+			continue
+		}
+		points = e.tracker.newer(seriesKey(ts), points, settings.AllPoints, now)
+		if len(points) == 0 {
+			continue
+		}
+		metric := newMetric(descriptor, ts, points)
+		if metric == nil {
+			continue
+		}
+
+		rm := &metricspb.ResourceMetrics{
+			Resource: newResource(projectID, ts.Resource),
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: scopeName, Version: version.Version},
+				Metrics: []*metricspb.Metric{metric},
+			}},
+		}
+		select {
+		case e.queue <- rm:
+		default:
+			e.droppedDataPointsTotal.Add(float64(countDataPoints(rm)))
+		}
+	}
+	e.tracker.prune(now.Add(-trackerRetention))
+}
+
+// Run exports the queued metrics until ctx is cancelled.
+func (e *Exporter) Run(ctx context.Context) {
+	for {
+		var batch []*metricspb.ResourceMetrics
+		select {
+		case <-ctx.Done():
+			return
+		case rm := <-e.queue:
+			batch = append(batch, rm)
+		}
+	fill:
+		for len(batch) < maxResourceMetricsPerExport {
+			select {
+			case rm := <-e.queue:
+				batch = append(batch, rm)
+			default:
+				break fill
+			}
+		}
+		e.export(ctx, batch)
+	}
+}
+
+// export sends a batch, retrying recoverable errors with an exponential backoff.
+func (e *Exporter) export(ctx context.Context, batch []*metricspb.ResourceMetrics) {
+	dataPoints := 0
+	for _, rm := range batch {
+		dataPoints += countDataPoints(rm)
+	}
+	req := &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: batch}
+
+	backoff := e.opts.MinBackoff
+	for attempt := 0; ; attempt++ {
+		rejected, err := e.client.Export(ctx, req)
+		if err == nil {
+			if rejected > 0 {
+				level.Warn(e.logger).Log("msg", "OTLP endpoint rejected some data points", "rejected", rejected)
+				e.failedDataPointsTotal.Add(float64(rejected))
+			}
+			e.exportedDataPointsTotal.Add(float64(int64(dataPoints) - rejected))
+			return
+		}
+
+		var recoverable recoverableError
+		if !errors.As(err, &recoverable) || attempt >= e.opts.MaxRetries || ctx.Err() != nil {
+			e.failedDataPointsTotal.Add(float64(dataPoints))
+			level.Error(e.logger).Log("msg", "Error exporting metrics to the OTLP endpoint, dropping them", "data_points", dataPoints, "attempts", attempt+1, "err", err)
+			return
+		}
+
+		level.Warn(e.logger).Log("msg", "Error exporting metrics to the OTLP endpoint, retrying", "data_points", dataPoints, "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			e.failedDataPointsTotal.Add(float64(dataPoints))
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > e.opts.MaxBackoff {
+			backoff = e.opts.MaxBackoff
+		}
+	}
+}
+
+func countDataPoints(rm *metricspb.ResourceMetrics) int {
+	count := 0
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case *metricspb.Metric_Gauge:
+				count += len(data.Gauge.DataPoints)
+			case *metricspb.Metric_Sum:
+				count += len(data.Sum.DataPoints)
+			case *metricspb.Metric_Histogram:
+				count += len(data.Histogram.DataPoints)
+			case *metricspb.Metric_ExponentialHistogram:
+				count += len(data.ExponentialHistogram.DataPoints)
+			}
+		}
+	}
+	return count
+}
+
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	e.exportedDataPointsTotal.Describe(ch)
+	e.failedDataPointsTotal.Describe(ch)
+	e.droppedDataPointsTotal.Describe(ch)
+}
+
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.exportedDataPointsTotal.Collect(ch)
+	e.failedDataPointsTotal.Collect(ch)
+	e.droppedDataPointsTotal.Collect(ch)
+}
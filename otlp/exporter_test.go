@@ -0,0 +1,165 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promlog"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"golang.org/x/net/context"
+	"google.golang.org/api/monitoring/v3"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+)
+
+// receiver is an OTLP/HTTP receiver recording the received requests. The first failures requests are answered with
+// status.
+type receiver struct {
+	t        *testing.T
+	failures int
+	status   int
+
+	mtx      sync.Mutex
+	requests []*colmetricspb.ExportMetricsServiceRequest
+	received chan struct{}
+}
+
+func newReceiver(t *testing.T, failures, status int) (*receiver, *httptest.Server) {
+	r := &receiver{t: t, failures: failures, status: status, received: make(chan struct{}, 100)}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	defer func() { r.received <- struct{}{} }()
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.failures > 0 {
+		r.failures--
+		http.Error(w, "failure", r.status)
+		return
+	}
+	if req.Header.Get("Content-Type") != "application/x-protobuf" || req.Header.Get("X-Api-Key") != "secret" {
+		r.t.Errorf("unexpected headers %v", req.Header)
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	var exportReq colmetricspb.ExportMetricsServiceRequest
+	if err := proto.Unmarshal(body, &exportReq); err != nil {
+		r.t.Fatal(err)
+	}
+	r.requests = append(r.requests, &exportReq)
+}
+
+func (r *receiver) wait(requests int) {
+	for i := 0; i < requests; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			r.t.Fatalf("timed out waiting for request %d", i+1)
+		}
+	}
+}
+
+// waitForCounter waits for the counter to reach value, as it is updated after the receiver answered.
+func waitForCounter(t *testing.T, counter prometheus.Counter, value float64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(counter) != value {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the counter to reach %v, got %v", value, testutil.ToFloat64(counter))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func newTestExporter(url string, capacity int) *Exporter {
+	client := NewHTTPClient(url, map[string]string{"X-Api-Key": "secret"}, time.Second)
+	return NewExporter(promlog.New(&promlog.Config{}), client, Options{
+		QueueCapacity: capacity,
+		MaxRetries:    3,
+		MinBackoff:    time.Millisecond,
+		MaxBackoff:    time.Millisecond,
+	})
+}
+
+func TestExporterRetriesRecoverableErrors(t *testing.T) {
+	r, server := newReceiver(t, 2, http.StatusServiceUnavailable)
+	e := newTestExporter(server.URL, 10)
+	ts := testTimeSeries("CUMULATIVE", "DOUBLE", doubleValue(1), doubleValue(2))
+	e.AppendTimeSeries("my-project", &monitoring.MetricDescriptor{}, collectors.CollectionSettings{AllPoints: true}, []*monitoring.TimeSeries{ts})
+	// The points were already queued
+	e.AppendTimeSeries("my-project", &monitoring.MetricDescriptor{}, collectors.CollectionSettings{AllPoints: true}, []*monitoring.TimeSeries{ts})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+	r.wait(3)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if len(r.requests) != 1 || len(r.requests[0].ResourceMetrics) != 1 {
+		t.Fatalf("expected a single resource metrics, got %v", r.requests)
+	}
+	rm := r.requests[0].ResourceMetrics[0]
+	if attr := rm.Resource.Attributes[1]; attr.Key != "cloud.account.id" || attr.Value.GetStringValue() != "my-project" {
+		t.Errorf("expected the project as resource attribute, got %v", rm.Resource.Attributes)
+	}
+	if points := rm.ScopeMetrics[0].Metrics[0].GetSum().DataPoints; len(points) != 2 {
+		t.Errorf("expected both points, got %v", points)
+	}
+	waitForCounter(t, e.exportedDataPointsTotal, 2)
+}
+
+func TestExporterDropsRejectedDataPoints(t *testing.T) {
+	r, server := newReceiver(t, 1, http.StatusBadRequest)
+	e := newTestExporter(server.URL, 10)
+	e.AppendTimeSeries("my-project", &monitoring.MetricDescriptor{}, collectors.CollectionSettings{}, []*monitoring.TimeSeries{
+		testTimeSeries("GAUGE", "DOUBLE", doubleValue(1), doubleValue(2)),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+	r.wait(1)
+
+	// Only the newest point is exported without AllPoints
+	waitForCounter(t, e.failedDataPointsTotal, 1)
+}
+
+func TestExporterDropsWhenQueueIsFull(t *testing.T) {
+	e := newTestExporter("http://127.0.0.1:0", 1)
+	other := testTimeSeries("GAUGE", "DOUBLE", doubleValue(1))
+	other.Metric.Labels = map[string]string{"instance_name": "b"}
+	e.AppendTimeSeries("my-project", &monitoring.MetricDescriptor{}, collectors.CollectionSettings{}, []*monitoring.TimeSeries{
+		testTimeSeries("GAUGE", "DOUBLE", doubleValue(1)),
+		other,
+	})
+	if v := testutil.ToFloat64(e.droppedDataPointsTotal); v != 1 {
+		t.Errorf("expected 1 dropped data point, got %v", v)
+	}
+}
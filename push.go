@@ -0,0 +1,120 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+	"github.com/prometheus-community/stackdriver_exporter/remotewrite"
+)
+
+const (
+	remoteWriteMinBackoff = 30 * time.Millisecond
+	remoteWriteMaxBackoff = 5 * time.Second
+
+	otlpMinBackoff = 30 * time.Millisecond
+	otlpMaxBackoff = 5 * time.Second
+)
+
+// runPush pushes the metrics of the current handler every interval until ctx is cancelled.
+func runPush(ctx context.Context, r *reloadableHandler, queue *remotewrite.Queue, interval time.Duration, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		r.get().push(ctx, queue, interval, logger)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// push collects the metrics of every served project together with the exporter's own metrics and enqueues them. The
+// collection and the enqueueing must complete within timeout, so that a slow receiver delays the next collection
+// instead of growing the queue without bounds.
+func (h *handler) push(ctx context.Context, queue *remotewrite.Queue, timeout time.Duration, logger log.Logger) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	projectCollectors, err := h.projectCollectors()
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating collectors to push", "err", err)
+		return
+	}
+	// Gather returns every metric it could collect along with the error
+	families, err := collectorsGatherer(ctx, projectCollectors, prometheus.DefaultGatherer).Gather()
+	if err != nil {
+		level.Error(logger).Log("msg", "Error gathering metrics to push", "err", err)
+	}
+	series := remotewrite.FromMetricFamilies(families, time.Now())
+	if err := queue.Enqueue(ctx, series); err != nil {
+		level.Warn(logger).Log("msg", "Remote write queue did not drain before the next push, dropping samples", "err", err)
+	}
+}
+
+// runCollections collects the metrics of the current handler every interval until ctx is cancelled, so that their
+// time series reach the OTLP exporter when metrics are neither scraped nor pushed.
+func runCollections(ctx context.Context, r *reloadableHandler, interval time.Duration, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		r.get().collect(ctx, interval, logger)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collect collects the metrics of every served project within timeout and discards them, the time series being
+// handed to the handler's TimeSeriesSink during the collection.
+func (h *handler) collect(ctx context.Context, timeout time.Duration, logger log.Logger) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	projectCollectors, err := h.projectCollectors()
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating collectors to export", "err", err)
+		return
+	}
+	if _, err := collectorsGatherer(ctx, projectCollectors, nil).Gather(); err != nil {
+		level.Error(logger).Log("msg", "Error collecting metrics to export", "err", err)
+	}
+}
+
+// projectCollectors returns the collectors of every served project.
+func (h *handler) projectCollectors() ([]*collectors.MonitoringCollector, error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	var projectCollectors []*collectors.MonitoringCollector
+	for _, project := range h.projectIDs {
+		collector, err := h.getCollector(project, h.metricsPrefixes)
+		if err != nil {
+			return nil, err
+		}
+		projectCollectors = append(projectCollectors, collector)
+	}
+	return projectCollectors, nil
+}
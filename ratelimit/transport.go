@@ -0,0 +1,162 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	namespace = "stackdriver"
+	subsystem = "monitoring"
+)
+
+// Options configures the limits of a Transport. A zero value disables the corresponding limit.
+type Options struct {
+	// MaxInFlight is the maximum number of concurrent requests.
+	MaxInFlight int
+	// QPS is the maximum sustained number of requests per second.
+	QPS float64
+	// Burst is the maximum number of requests which can be sent at once above QPS. It defaults to QPS rounded up.
+	Burst int
+}
+
+// Transport is an http.RoundTripper bounding the number of concurrent requests and their rate. It is meant to be
+// shared by every collector so the limits apply to the whole exporter.
+type Transport struct {
+	next     http.RoundTripper
+	inFlight chan struct{}
+	limiter  *rate.Limiter
+
+	inFlightMetric    prometheus.Gauge
+	waitingMetric     prometheus.Gauge
+	waitSecondsMetric prometheus.Counter
+}
+
+// NewTransport returns a Transport limiting the requests sent to next.
+func NewTransport(next http.RoundTripper, opts Options) *Transport {
+	t := &Transport{
+		next: next,
+		inFlightMetric: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "api_requests_in_flight",
+			Help:      "Number of Google Stackdriver Monitoring API requests currently in flight.",
+		}),
+		waitingMetric: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "api_requests_waiting",
+			Help:      "Number of Google Stackdriver Monitoring API requests waiting for the concurrency or rate limit.",
+		}),
+		waitSecondsMetric: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "api_request_wait_seconds_total",
+			Help:      "Total time Google Stackdriver Monitoring API requests waited for the concurrency or rate limit.",
+		}),
+	}
+
+	if opts.MaxInFlight > 0 {
+		t.inFlight = make(chan struct{}, opts.MaxInFlight)
+	}
+	if opts.QPS > 0 {
+		burst := opts.Burst
+		if burst <= 0 {
+			burst = int(math.Ceil(opts.QPS))
+		}
+		t.limiter = rate.NewLimiter(rate.Limit(opts.QPS), burst)
+	}
+	return t
+}
+
+// RoundTrip waits for a free slot and a token before sending the request. It returns early with the context error if
+// the request's context is done while waiting.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	begun := time.Now()
+	t.waitingMetric.Inc()
+	if t.inFlight != nil {
+		select {
+		case t.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			t.waitingMetric.Dec()
+			return nil, ctx.Err()
+		}
+	}
+	release := func() {
+		if t.inFlight != nil {
+			<-t.inFlight
+		}
+	}
+	if t.limiter != nil {
+		if err := t.limiter.Wait(ctx); err != nil {
+			t.waitingMetric.Dec()
+			release()
+			return nil, err
+		}
+	}
+	t.waitingMetric.Dec()
+	t.waitSecondsMetric.Add(time.Since(begun).Seconds())
+
+	t.inFlightMetric.Inc()
+	done := func() {
+		t.inFlightMetric.Dec()
+		release()
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.Body == nil {
+		done()
+		return resp, err
+	}
+	// The request stays in flight until its response has been read.
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: done}
+	return resp, nil
+}
+
+// releasingBody calls release once, when the body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// Describe implements prometheus.Collector.
+func (t *Transport) Describe(ch chan<- *prometheus.Desc) {
+	t.inFlightMetric.Describe(ch)
+	t.waitingMetric.Describe(ch)
+	t.waitSecondsMetric.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (t *Transport) Collect(ch chan<- prometheus.Metric) {
+	t.inFlightMetric.Collect(ch)
+	t.waitingMetric.Collect(ch)
+	t.waitSecondsMetric.Collect(ch)
+}
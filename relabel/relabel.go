@@ -0,0 +1,249 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package relabel implements the subset of the Prometheus relabel_configs which applies to the labels of a series.
+// @see https://prometheus.io/docs/prometheus/latest/configuration/configuration/#relabel_config
+package relabel
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// Action is the action performed by a relabel Config.
+type Action string
+
+const (
+	// Replace sets TargetLabel to Replacement, with the groups of Regex matching the source labels expanded.
+	Replace Action = "replace"
+	// Keep drops the series when Regex does not match the source labels.
+	Keep Action = "keep"
+	// Drop drops the series when Regex matches the source labels.
+	Drop Action = "drop"
+	// LabelDrop removes the labels whose name matches Regex.
+	LabelDrop Action = "labeldrop"
+	// LabelKeep removes the labels whose name does not match Regex.
+	LabelKeep Action = "labelkeep"
+	// LabelMap copies the labels whose name matches Regex to the label named Replacement.
+	LabelMap Action = "labelmap"
+	// HashMod sets TargetLabel to the modulus of a hash of the source labels.
+	HashMod Action = "hashmod"
+)
+
+// relabelTarget matches the label names in which regex groups may be expanded, as in Prometheus.
+var relabelTarget = regexp.MustCompile(`^(?:(?:[a-zA-Z_]|\$(?:\{\w+\}|\w+))+\w*)+$`)
+
+// Regexp is a regular expression anchored at both ends, as in Prometheus.
+type Regexp struct {
+	*regexp.Regexp
+	original string
+}
+
+// NewRegexp compiles an anchored regular expression.
+func NewRegexp(s string) (Regexp, error) {
+	re, err := regexp.Compile("^(?:" + s + ")$")
+	return Regexp{Regexp: re, original: s}, err
+}
+
+// MustNewRegexp is like NewRegexp but panics if the expression cannot be compiled.
+func MustNewRegexp(s string) Regexp {
+	re, err := NewRegexp(s)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	r, err := NewRegexp(s)
+	if err != nil {
+		return err
+	}
+	*re = r
+	return nil
+}
+
+func (re Regexp) MarshalYAML() (interface{}, error) {
+	return re.original, nil
+}
+
+// Config is a relabeling rule with the syntax and the defaults of Prometheus relabel_configs.
+type Config struct {
+	// SourceLabels are the labels whose values are joined with Separator and matched against Regex.
+	SourceLabels []string `yaml:"source_labels,flow,omitempty"`
+	Separator    string   `yaml:"separator,omitempty"`
+	Regex        Regexp   `yaml:"regex,omitempty"`
+	// Modulus is the modulus of the hash of the source labels for HashMod.
+	Modulus uint64 `yaml:"modulus,omitempty"`
+	// TargetLabel is the label set by Replace and HashMod. Regex groups are expanded in it for Replace.
+	TargetLabel string `yaml:"target_label,omitempty"`
+	// Replacement is the value set by Replace, or the new label name for LabelMap, with Regex groups expanded.
+	Replacement string `yaml:"replacement,omitempty"`
+	Action      Action `yaml:"action,omitempty"`
+}
+
+// DefaultConfig holds the values of the fields which are not set.
+var DefaultConfig = Config{
+	Separator:   ";",
+	Regex:       MustNewRegexp("(.*)"),
+	Replacement: "$1",
+	Action:      Replace,
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate checks that the rule can be applied.
+func (c *Config) Validate() error {
+	if c.Regex.Regexp == nil {
+		return errors.New("regex is required")
+	}
+	switch c.Action {
+	case Replace:
+		if c.TargetLabel == "" {
+			return errors.New("replace requires a target_label")
+		}
+		if !relabelTarget.MatchString(c.TargetLabel) {
+			return fmt.Errorf("%q is not a valid target_label for replace", c.TargetLabel)
+		}
+	case HashMod:
+		if c.TargetLabel == "" {
+			return errors.New("hashmod requires a target_label")
+		}
+		if !model.LabelName(c.TargetLabel).IsValid() {
+			return fmt.Errorf("%q is not a valid target_label for hashmod", c.TargetLabel)
+		}
+		if c.Modulus == 0 {
+			return errors.New("hashmod requires a modulus greater than 0")
+		}
+	case LabelDrop, LabelKeep:
+		if len(c.SourceLabels) > 0 || c.TargetLabel != "" {
+			return fmt.Errorf("%s only applies regex to the label names, source_labels and target_label are not allowed", c.Action)
+		}
+	case LabelMap:
+		if !relabelTarget.MatchString(c.Replacement) {
+			return fmt.Errorf("%q is not a valid replacement for labelmap", c.Replacement)
+		}
+	case Keep, Drop:
+	default:
+		return fmt.Errorf("unknown relabel action %q", c.Action)
+	}
+	return nil
+}
+
+// Process applies the rules in order to the labels given as parallel keys and values slices. It returns the resulting
+// labels, or false when a rule dropped the series. The given slices are not modified.
+func Process(keys, values []string, cfgs ...*Config) ([]string, []string, bool) {
+	labels := make(map[string]string, len(keys))
+	for i, key := range keys {
+		labels[key] = values[i]
+	}
+	for _, cfg := range cfgs {
+		if !relabel(labels, cfg) {
+			return nil, nil, false
+		}
+	}
+
+	// Keep the order of the labels which are left and append the new ones
+	newKeys := make([]string, 0, len(labels))
+	newValues := make([]string, 0, len(labels))
+	for _, key := range keys {
+		if value, ok := labels[key]; ok {
+			newKeys = append(newKeys, key)
+			newValues = append(newValues, value)
+			delete(labels, key)
+		}
+	}
+	for key, value := range labels {
+		newKeys = append(newKeys, key)
+		newValues = append(newValues, value)
+	}
+	return newKeys, newValues, true
+}
+
+func relabel(labels map[string]string, cfg *Config) bool {
+	values := make([]string, 0, len(cfg.SourceLabels))
+	for _, name := range cfg.SourceLabels {
+		values = append(values, labels[name])
+	}
+	val := strings.Join(values, cfg.Separator)
+
+	switch cfg.Action {
+	case Keep:
+		return cfg.Regex.MatchString(val)
+	case Drop:
+		return !cfg.Regex.MatchString(val)
+	case Replace:
+		indexes := cfg.Regex.FindStringSubmatchIndex(val)
+		if indexes == nil {
+			break
+		}
+		target := string(cfg.Regex.ExpandString(nil, cfg.TargetLabel, val, indexes))
+		if !model.LabelName(target).IsValid() {
+			break
+		}
+		res := string(cfg.Regex.ExpandString(nil, cfg.Replacement, val, indexes))
+		if res == "" {
+			delete(labels, target)
+			break
+		}
+		labels[target] = res
+	case HashMod:
+		sum := md5.Sum([]byte(val))
+		// Like Prometheus, use the lower 8 bytes of the hash
+		labels[cfg.TargetLabel] = fmt.Sprint(binary.BigEndian.Uint64(sum[8:]) % cfg.Modulus)
+	case LabelMap:
+		mapped := make(map[string]string)
+		for name, value := range labels {
+			if !cfg.Regex.MatchString(name) {
+				continue
+			}
+			// Expanded groups can still make an invalid name, ie when they start with a digit
+			if target := cfg.Regex.ReplaceAllString(name, cfg.Replacement); model.LabelName(target).IsValid() {
+				mapped[target] = value
+			}
+		}
+		for name, value := range mapped {
+			labels[name] = value
+		}
+	case LabelDrop:
+		for name := range labels {
+			if cfg.Regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	case LabelKeep:
+		for name := range labels {
+			if !cfg.Regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	}
+	return true
+}
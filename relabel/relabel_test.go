@@ -0,0 +1,128 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relabel
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func parseConfig(t *testing.T, s string) *Config {
+	t.Helper()
+	var cfg Config
+	if err := yaml.UnmarshalStrict([]byte(s), &cfg); err != nil {
+		t.Fatalf("unexpected error parsing %q: %v", s, err)
+	}
+	return &cfg
+}
+
+func labels(keys, values []string) map[string]string {
+	m := make(map[string]string, len(keys))
+	for i, key := range keys {
+		m[key] = values[i]
+	}
+	return m
+}
+
+func TestProcess(t *testing.T) {
+	keys := []string{"instance_id", "zone", "instance_name"}
+	values := []string{"1234", "us-central1-a", "web-1"}
+
+	for name, tc := range map[string]struct {
+		config   string
+		expected map[string]string
+	}{
+		"replace": {
+			config:   "{source_labels: [zone], regex: '(.*)-[a-z]', target_label: region}",
+			expected: map[string]string{"instance_id": "1234", "zone": "us-central1-a", "instance_name": "web-1", "region": "us-central1"},
+		},
+		"replace without match": {
+			config:   "{source_labels: [zone], regex: 'europe-.*', target_label: region, replacement: europe}",
+			expected: map[string]string{"instance_id": "1234", "zone": "us-central1-a", "instance_name": "web-1"},
+		},
+		"replace with empty value": {
+			config:   "{target_label: instance_id, replacement: ''}",
+			expected: map[string]string{"zone": "us-central1-a", "instance_name": "web-1"},
+		},
+		"labeldrop": {
+			config:   "{action: labeldrop, regex: instance_.*}",
+			expected: map[string]string{"zone": "us-central1-a"},
+		},
+		"labelkeep": {
+			config:   "{action: labelkeep, regex: zone}",
+			expected: map[string]string{"zone": "us-central1-a"},
+		},
+		"labelmap": {
+			config:   "{action: labelmap, regex: instance_(.*), replacement: vm_$1}",
+			expected: map[string]string{"instance_id": "1234", "zone": "us-central1-a", "instance_name": "web-1", "vm_id": "1234", "vm_name": "web-1"},
+		},
+		"hashmod": {
+			config:   "{action: hashmod, source_labels: [instance_id], modulus: 4, target_label: shard}",
+			expected: map[string]string{"instance_id": "1234", "zone": "us-central1-a", "instance_name": "web-1", "shard": "1"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			newKeys, newValues, keep := Process(keys, values, parseConfig(t, tc.config))
+			if !keep {
+				t.Fatal("expected the series to be kept")
+			}
+			if got := labels(newKeys, newValues); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+
+	if _, _, keep := Process(keys, values, parseConfig(t, "{action: keep, source_labels: [zone], regex: 'europe-.*'}")); keep {
+		t.Error("expected keep to drop the series which do not match")
+	}
+	if _, _, keep := Process(keys, values, parseConfig(t, "{action: drop, source_labels: [instance_name, zone], regex: 'web-.*;us-.*'}")); keep {
+		t.Error("expected drop to drop the series which match")
+	}
+}
+
+func TestProcessKeepsLabelOrder(t *testing.T) {
+	newKeys, _, _ := Process([]string{"b", "a", "c"}, []string{"1", "2", "3"}, parseConfig(t, "{action: labeldrop, regex: a}"))
+	if !reflect.DeepEqual(newKeys, []string{"b", "c"}) {
+		t.Errorf("expected the order of the labels to be kept, got %v", newKeys)
+	}
+}
+
+func TestLabelMapSkipsInvalidNames(t *testing.T) {
+	newKeys, newValues, _ := Process([]string{"disk_0_size", "disk_boot"}, []string{"10", "true"}, parseConfig(t, "{action: labelmap, regex: disk_(.*), replacement: $1}"))
+	expected := map[string]string{"disk_0_size": "10", "disk_boot": "true", "boot": "true"}
+	if got := labels(newKeys, newValues); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestInvalidConfigs(t *testing.T) {
+	for name, s := range map[string]string{
+		"unknown action":            "{action: lowercase}",
+		"invalid regex":             "{regex: '(', target_label: a}",
+		"replace without target":    "{source_labels: [a]}",
+		"hashmod without modulus":   "{action: hashmod, target_label: shard}",
+		"labeldrop with the target": "{action: labeldrop, regex: a, target_label: b}",
+		"invalid replace target":    "{source_labels: [zone], target_label: gcp-zone}",
+		"invalid labelmap target":   "{action: labelmap, regex: '(zone)', replacement: gcp-$1}",
+	} {
+		t.Run(name, func(t *testing.T) {
+			var cfg Config
+			if err := yaml.UnmarshalStrict([]byte(s), &cfg); err == nil {
+				t.Errorf("expected an error for %q", s)
+			}
+		})
+	}
+}
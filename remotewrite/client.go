@@ -0,0 +1,82 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/common/version"
+	"golang.org/x/net/context"
+)
+
+// maxErrorBodyBytes is how much of the body of a failed request is included in its error.
+const maxErrorBodyBytes = 512
+
+// Client sends write requests to a remote write receiver.
+type Client struct {
+	url        string
+	timeout    time.Duration
+	httpClient *http.Client
+}
+
+// NewClient returns a Client sending requests to url, which may contain basic auth credentials, each request being
+// cancelled after timeout.
+func NewClient(url string, timeout time.Duration) *Client {
+	return &Client{url: url, timeout: timeout, httpClient: &http.Client{}}
+}
+
+// recoverableError is an error after which the request can be retried.
+type recoverableError struct {
+	error
+}
+
+// Store sends a snappy compressed write request. Errors returned for network failures, server errors and rate
+// limited requests are recoverable.
+func (c *Client) Store(ctx context.Context, compressed []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(compressed))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "stackdriver_exporter/"+version.Version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return recoverableError{err}
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	line, _ := bufio.NewReader(io.LimitReader(resp.Body, maxErrorBodyBytes)).ReadString('\n')
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace([]byte(line)))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return recoverableError{err}
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+)
+
+const (
+	metricNameLabel = "__name__"
+	bucketLabel     = "le"
+	quantileLabel   = "quantile"
+)
+
+// FromMetricFamilies converts gathered metric families to remote write series. Metrics without a timestamp, such as
+// the self metrics of the exporter, are stamped with now. Histograms and summaries are split into their `_bucket`,
+// `_count` and `_sum` or quantile series, native histogram buckets are not sent.
+func FromMetricFamilies(families []*dto.MetricFamily, now time.Time) []TimeSeries {
+	var series []TimeSeries
+	for _, mf := range families {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			timestamp := now.UnixMilli()
+			if m.TimestampMs != nil {
+				timestamp = m.GetTimestampMs()
+			}
+			labels := make([]Label, 0, len(m.GetLabel())+1)
+			for _, l := range m.GetLabel() {
+				labels = append(labels, Label{Name: l.GetName(), Value: l.GetValue()})
+			}
+			add := func(suffix string, value float64, extra ...Label) {
+				ls := append(append([]Label{{Name: metricNameLabel, Value: name + suffix}}, labels...), extra...)
+				series = append(series, newTimeSeries(ls, Sample{Value: value, Timestamp: timestamp}))
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add("", q.GetValue(), Label{Name: quantileLabel, Value: formatFloat(q.GetQuantile())})
+				}
+				add("_sum", s.GetSampleSum())
+				add("_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				buckets := make(map[float64]uint64, len(h.GetBucket()))
+				for _, b := range h.GetBucket() {
+					buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+				}
+				addHistogram(add, h.GetSampleSum(), h.GetSampleCount(), buckets)
+			}
+		}
+	}
+	return series
+}
+
+// FromSamples converts the samples of a collectors.SampleSink to remote write series, one per sample.
+func FromSamples(samples []collectors.Sample) []TimeSeries {
+	var series []TimeSeries
+	for _, s := range samples {
+		s := s
+		labels := make([]Label, 0, len(s.LabelKeys)+1)
+		for i, key := range s.LabelKeys {
+			labels = append(labels, Label{Name: key, Value: s.LabelValues[i]})
+		}
+		add := func(suffix string, value float64, extra ...Label) {
+			ls := append(append([]Label{{Name: metricNameLabel, Value: s.FqName + suffix}}, labels...), extra...)
+			series = append(series, newTimeSeries(ls, Sample{Value: value, Timestamp: s.Timestamp.UnixMilli()}))
+		}
+
+		if s.Histogram != nil {
+			addHistogram(add, s.Histogram.Sum, s.Histogram.Count, s.Histogram.Buckets)
+			continue
+		}
+		add("", s.Value)
+	}
+	return series
+}
+
+// addHistogram adds the series of a classic histogram with cumulative buckets, including the `+Inf` bucket.
+func addHistogram(add func(suffix string, value float64, extra ...Label), sum float64, count uint64, buckets map[float64]uint64) {
+	bounds := make([]float64, 0, len(buckets)+1)
+	for bound := range buckets {
+		bounds = append(bounds, bound)
+	}
+	if _, ok := buckets[math.Inf(1)]; !ok {
+		bounds = append(bounds, math.Inf(1))
+	}
+	sort.Float64s(bounds)
+	for _, bound := range bounds {
+		value := buckets[bound]
+		if math.IsInf(bound, 1) {
+			value = count
+		}
+		add("_bucket", float64(value), Label{Name: bucketLabel, Value: formatFloat(bound)})
+	}
+	add("_sum", sum)
+	add("_count", float64(count))
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+)
+
+func TestFromMetricFamilies(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	reportTime := time.UnixMilli(1000)
+	desc := prometheus.NewDesc("latency", "", []string{"zone"}, nil)
+	histogram := prometheus.MustNewConstHistogram(desc, 3, 6, map[float64]uint64{1: 1, 5: 2}, "a")
+	registry.MustRegister(&constCollector{metrics: []prometheus.Metric{
+		prometheus.NewMetricWithTimestamp(reportTime, histogram),
+		prometheus.MustNewConstMetric(prometheus.NewDesc("up", "", nil, nil), prometheus.GaugeValue, 1),
+	}})
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.UnixMilli(2000)
+	series := FromMetricFamilies(families, now)
+	labels := func(name string, extra ...Label) []Label {
+		return newTimeSeries(append([]Label{{Name: metricNameLabel, Value: name}, {Name: "zone", Value: "a"}}, extra...)).Labels
+	}
+	expected := []TimeSeries{
+		{Labels: labels("latency_bucket", Label{Name: "le", Value: "1"}), Samples: []Sample{{Value: 1, Timestamp: 1000}}},
+		{Labels: labels("latency_bucket", Label{Name: "le", Value: "5"}), Samples: []Sample{{Value: 2, Timestamp: 1000}}},
+		{Labels: labels("latency_bucket", Label{Name: "le", Value: "+Inf"}), Samples: []Sample{{Value: 3, Timestamp: 1000}}},
+		{Labels: labels("latency_sum"), Samples: []Sample{{Value: 6, Timestamp: 1000}}},
+		{Labels: labels("latency_count"), Samples: []Sample{{Value: 3, Timestamp: 1000}}},
+		{Labels: []Label{{Name: metricNameLabel, Value: "up"}}, Samples: []Sample{{Value: 1, Timestamp: 2000}}},
+	}
+	if !reflect.DeepEqual(series, expected) {
+		t.Errorf("unexpected series\nexpected: %+v\ngot:      %+v", expected, series)
+	}
+}
+
+func TestFromSamples(t *testing.T) {
+	series := FromSamples([]collectors.Sample{{
+		FqName:      "usage",
+		LabelKeys:   []string{"zone"},
+		LabelValues: []string{"a"},
+		Timestamp:   time.UnixMilli(1000),
+		ValueType:   prometheus.GaugeValue,
+		Value:       4,
+	}})
+	expected := []TimeSeries{{
+		Labels:  []Label{{Name: metricNameLabel, Value: "usage"}, {Name: "zone", Value: "a"}},
+		Samples: []Sample{{Value: 4, Timestamp: 1000}},
+	}}
+	if !reflect.DeepEqual(series, expected) {
+		t.Errorf("unexpected series\nexpected: %+v\ngot:      %+v", expected, series)
+	}
+}
+
+type constCollector struct {
+	metrics []prometheus.Metric
+}
+
+func (c *constCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *constCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
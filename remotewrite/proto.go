@@ -0,0 +1,102 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"math"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// TimeSeries is a series of the remote write protocol with its samples in ascending timestamp order.
+// @see https://prometheus.io/docs/concepts/remote_write_spec/#protocol
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Value float64
+	// Timestamp is in milliseconds since the epoch.
+	Timestamp int64
+}
+
+// Field numbers of the prometheus.WriteRequest message and its nested messages.
+// @see https://github.com/prometheus/prometheus/blob/main/prompb/remote.proto
+// @see https://github.com/prometheus/prometheus/blob/main/prompb/types.proto
+const (
+	writeRequestTimeseriesField = 1
+	timeSeriesLabelsField       = 1
+	timeSeriesSamplesField      = 2
+	labelNameField              = 1
+	labelValueField             = 2
+	sampleValueField            = 1
+	sampleTimestampField        = 2
+)
+
+// newTimeSeries returns a TimeSeries with the labels sorted by name, as required by the protocol. Labels with an empty
+// value are dropped, as they are equivalent to a missing label.
+func newTimeSeries(labels []Label, samples ...Sample) TimeSeries {
+	ts := TimeSeries{Labels: make([]Label, 0, len(labels)), Samples: samples}
+	for _, l := range labels {
+		if l.Value != "" {
+			ts.Labels = append(ts.Labels, l)
+		}
+	}
+	sort.Slice(ts.Labels, func(i, j int) bool {
+		return ts.Labels[i].Name < ts.Labels[j].Name
+	})
+	return ts
+}
+
+// encodeWriteRequest returns the protobuf encoding of a WriteRequest holding series.
+func encodeWriteRequest(series []TimeSeries) []byte {
+	var b []byte
+	for _, ts := range series {
+		b = protowire.AppendTag(b, writeRequestTimeseriesField, protowire.BytesType)
+		b = protowire.AppendBytes(b, encodeTimeSeries(ts))
+	}
+	return b
+}
+
+func encodeTimeSeries(ts TimeSeries) []byte {
+	var b []byte
+	for _, l := range ts.Labels {
+		var label []byte
+		label = protowire.AppendTag(label, labelNameField, protowire.BytesType)
+		label = protowire.AppendString(label, l.Name)
+		label = protowire.AppendTag(label, labelValueField, protowire.BytesType)
+		label = protowire.AppendString(label, l.Value)
+
+		b = protowire.AppendTag(b, timeSeriesLabelsField, protowire.BytesType)
+		b = protowire.AppendBytes(b, label)
+	}
+	for _, s := range ts.Samples {
+		var sample []byte
+		sample = protowire.AppendTag(sample, sampleValueField, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+		sample = protowire.AppendTag(sample, sampleTimestampField, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.Timestamp))
+
+		b = protowire.AppendTag(b, timeSeriesSamplesField, protowire.BytesType)
+		b = protowire.AppendBytes(b, sample)
+	}
+	return b
+}
@@ -0,0 +1,199 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+)
+
+const (
+	namespace = "stackdriver"
+	subsystem = "remote_write"
+)
+
+// QueueOptions control how queued series are batched and retried.
+type QueueOptions struct {
+	// Capacity is the maximum number of series waiting to be sent.
+	Capacity int
+	// MaxSamplesPerSend is the maximum number of samples sent in a single request.
+	MaxSamplesPerSend int
+	// MaxRetries is how often a request failing with a recoverable error is retried before its samples are dropped.
+	MaxRetries int
+	// MinBackoff is the delay before the first retry, doubled on every retry up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Queue batches series and sends them to a remote write receiver in the order they were enqueued. There is no
+// write-ahead log: queued series are lost when the exporter stops, and while the receiver is unavailable the queue
+// fills up and Enqueue blocks, slowing down the producer instead of buffering without bounds.
+type Queue struct {
+	logger log.Logger
+	client *Client
+	opts   QueueOptions
+	series chan TimeSeries
+
+	sentSamplesTotal    prometheus.Counter
+	failedSamplesTotal  prometheus.Counter
+	droppedSamplesTotal prometheus.Counter
+	retriesTotal        prometheus.Counter
+	queuedSeriesDesc    *prometheus.Desc
+}
+
+func NewQueue(logger log.Logger, client *Client, opts QueueOptions) *Queue {
+	newCounter := func(name, help string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{Namespace: namespace, Subsystem: subsystem, Name: name, Help: help})
+	}
+	return &Queue{
+		logger: logger,
+		client: client,
+		opts:   opts,
+		series: make(chan TimeSeries, opts.Capacity),
+
+		sentSamplesTotal:    newCounter("sent_samples_total", "Total number of samples successfully sent to the remote write receiver."),
+		failedSamplesTotal:  newCounter("failed_samples_total", "Total number of samples dropped after the remote write receiver rejected them or retries were exhausted."),
+		droppedSamplesTotal: newCounter("dropped_samples_total", "Total number of samples dropped because the remote write queue was full."),
+		retriesTotal:        newCounter("retries_total", "Total number of remote write requests retried after a recoverable error."),
+		queuedSeriesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "queued_series"),
+			"Number of series waiting to be sent to the remote write receiver.",
+			nil, nil,
+		),
+	}
+}
+
+// Enqueue queues series, blocking while the queue is full. When ctx is done before every series is queued the
+// remaining ones are dropped and the context error is returned.
+func (q *Queue) Enqueue(ctx context.Context, series []TimeSeries) error {
+	for i, ts := range series {
+		select {
+		case q.series <- ts:
+		case <-ctx.Done():
+			q.droppedSamplesTotal.Add(float64(countSamples(series[i:])))
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// errQueueFull is returned by Append for the samples which did not fit in the queue.
+var errQueueFull = errors.New("remote write queue is full")
+
+// Append queues the samples of the metric types collected with all points. As it cannot block the collection, samples
+// which do not fit in the queue are dropped and an error is returned, so that they are appended again by the next
+// collection.
+func (q *Queue) Append(samples []collectors.Sample) error {
+	var err error
+	for _, ts := range FromSamples(samples) {
+		select {
+		case q.series <- ts:
+		default:
+			q.droppedSamplesTotal.Add(float64(len(ts.Samples)))
+			err = errQueueFull
+		}
+	}
+	return err
+}
+
+// Run sends the queued series until ctx is cancelled.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		var batch []TimeSeries
+		select {
+		case <-ctx.Done():
+			return
+		case ts := <-q.series:
+			batch = append(batch, ts)
+		}
+
+		samples := countSamples(batch)
+	fill:
+		for samples < q.opts.MaxSamplesPerSend {
+			select {
+			case ts := <-q.series:
+				batch = append(batch, ts)
+				samples += len(ts.Samples)
+			default:
+				break fill
+			}
+		}
+		q.send(ctx, batch, samples)
+	}
+}
+
+// send sends a batch, retrying recoverable errors with an exponential backoff.
+func (q *Queue) send(ctx context.Context, batch []TimeSeries, samples int) {
+	compressed := snappy.Encode(nil, encodeWriteRequest(batch))
+	backoff := q.opts.MinBackoff
+	for attempt := 0; ; attempt++ {
+		err := q.client.Store(ctx, compressed)
+		if err == nil {
+			q.sentSamplesTotal.Add(float64(samples))
+			return
+		}
+
+		var recoverable recoverableError
+		if !errors.As(err, &recoverable) || attempt >= q.opts.MaxRetries || ctx.Err() != nil {
+			q.failedSamplesTotal.Add(float64(samples))
+			level.Error(q.logger).Log("msg", "Error sending samples to the remote write receiver, dropping them", "samples", samples, "attempts", attempt+1, "err", err)
+			return
+		}
+
+		q.retriesTotal.Inc()
+		level.Warn(q.logger).Log("msg", "Error sending samples to the remote write receiver, retrying", "samples", samples, "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			q.failedSamplesTotal.Add(float64(samples))
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > q.opts.MaxBackoff {
+			backoff = q.opts.MaxBackoff
+		}
+	}
+}
+
+func countSamples(series []TimeSeries) int {
+	samples := 0
+	for _, ts := range series {
+		samples += len(ts.Samples)
+	}
+	return samples
+}
+
+func (q *Queue) Describe(ch chan<- *prometheus.Desc) {
+	q.sentSamplesTotal.Describe(ch)
+	q.failedSamplesTotal.Describe(ch)
+	q.droppedSamplesTotal.Describe(ch)
+	q.retriesTotal.Describe(ch)
+	ch <- q.queuedSeriesDesc
+}
+
+func (q *Queue) Collect(ch chan<- prometheus.Metric) {
+	q.sentSamplesTotal.Collect(ch)
+	q.failedSamplesTotal.Collect(ch)
+	q.droppedSamplesTotal.Collect(ch)
+	q.retriesTotal.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(q.queuedSeriesDesc, prometheus.GaugeValue, float64(len(q.series)))
+}
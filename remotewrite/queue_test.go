@@ -0,0 +1,250 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/promlog"
+	"golang.org/x/net/context"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+)
+
+// receiver is a remote write receiver recording the received series. The first failures requests are answered with
+// status.
+type receiver struct {
+	t        *testing.T
+	failures int
+	status   int
+
+	mtx      sync.Mutex
+	requests int
+	series   []TimeSeries
+	received chan struct{}
+}
+
+func newReceiver(t *testing.T, failures, status int) (*receiver, *httptest.Server) {
+	r := &receiver{t: t, failures: failures, status: status, received: make(chan struct{}, 100)}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.requests++
+	if r.requests <= r.failures {
+		http.Error(w, "failure", r.status)
+		r.received <- struct{}{}
+		return
+	}
+	if req.Header.Get("Content-Encoding") != "snappy" || req.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+		r.t.Errorf("unexpected headers %v", req.Header)
+	}
+	compressed, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	series, err := decodeWriteRequest(data)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	r.series = append(r.series, series...)
+	r.received <- struct{}{}
+}
+
+func (r *receiver) wait(requests int) {
+	for i := 0; i < requests; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			r.t.Fatalf("timed out waiting for request %d", i+1)
+		}
+	}
+}
+
+// decodeMessage calls field with the number and content of every length-delimited field of b and the value of the
+// other ones.
+func decodeMessage(b []byte, field func(num protowire.Number, content []byte, value uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var content []byte
+		var value uint64
+		switch typ {
+		case protowire.BytesType:
+			content, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed64Type:
+			value, n = protowire.ConsumeFixed64(b)
+		case protowire.VarintType:
+			value, n = protowire.ConsumeVarint(b)
+		default:
+			return fmt.Errorf("unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := field(num, content, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeWriteRequest(b []byte) ([]TimeSeries, error) {
+	var series []TimeSeries
+	err := decodeMessage(b, func(_ protowire.Number, tsContent []byte, _ uint64) error {
+		var ts TimeSeries
+		err := decodeMessage(tsContent, func(num protowire.Number, content []byte, _ uint64) error {
+			switch num {
+			case timeSeriesLabelsField:
+				var l Label
+				ts.Labels = append(ts.Labels, l)
+				return decodeMessage(content, func(num protowire.Number, content []byte, _ uint64) error {
+					if num == labelNameField {
+						ts.Labels[len(ts.Labels)-1].Name = string(content)
+					} else {
+						ts.Labels[len(ts.Labels)-1].Value = string(content)
+					}
+					return nil
+				})
+			case timeSeriesSamplesField:
+				var s Sample
+				err := decodeMessage(content, func(num protowire.Number, _ []byte, value uint64) error {
+					if num == sampleValueField {
+						s.Value = math.Float64frombits(value)
+					} else {
+						s.Timestamp = int64(value)
+					}
+					return nil
+				})
+				ts.Samples = append(ts.Samples, s)
+				return err
+			}
+			return nil
+		})
+		series = append(series, ts)
+		return err
+	})
+	return series, err
+}
+
+func testSeries(n int) []TimeSeries {
+	var series []TimeSeries
+	for i := 0; i < n; i++ {
+		series = append(series, newTimeSeries(
+			[]Label{{Name: "zone", Value: "a"}, {Name: metricNameLabel, Value: "metric"}, {Name: "empty"}},
+			Sample{Value: float64(i), Timestamp: int64(1000 + i)},
+		))
+	}
+	return series
+}
+
+func newTestQueue(url string, capacity int) *Queue {
+	return NewQueue(promlog.New(&promlog.Config{}), NewClient(url, time.Second), QueueOptions{
+		Capacity:          capacity,
+		MaxSamplesPerSend: 2,
+		MaxRetries:        3,
+		MinBackoff:        time.Millisecond,
+		MaxBackoff:        time.Millisecond,
+	})
+}
+
+func TestQueueRetriesRecoverableErrors(t *testing.T) {
+	r, server := newReceiver(t, 2, http.StatusServiceUnavailable)
+	q := newTestQueue(server.URL, 10)
+	if err := q.Enqueue(context.Background(), testSeries(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+	// Two failures, then the two batches
+	r.wait(4)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if !reflect.DeepEqual(r.series, testSeries(3)) {
+		t.Errorf("unexpected series received\nexpected: %+v\ngot:      %+v", testSeries(3), r.series)
+	}
+	if len(r.series[0].Labels) != 2 || r.series[0].Labels[0].Name != metricNameLabel {
+		t.Errorf("expected sorted labels without the empty one, got %+v", r.series[0].Labels)
+	}
+}
+
+func TestQueueDropsRejectedSamples(t *testing.T) {
+	r, server := newReceiver(t, 1, http.StatusBadRequest)
+	q := newTestQueue(server.URL, 10)
+	if err := q.Enqueue(context.Background(), testSeries(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+	r.wait(2)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.requests != 2 || len(r.series) != 1 {
+		t.Errorf("expected the rejected batch not to be retried, got %d requests and series %+v", r.requests, r.series)
+	}
+}
+
+func TestQueueBackpressure(t *testing.T) {
+	q := newTestQueue("http://127.0.0.1:0", 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := q.Enqueue(ctx, testSeries(3)); err == nil {
+		t.Fatal("expected enqueueing to a full queue to time out")
+	}
+	if len(q.series) != 2 {
+		t.Errorf("expected the queue to hold 2 series, got %d", len(q.series))
+	}
+}
+
+func TestQueueAppendFull(t *testing.T) {
+	q := newTestQueue("http://127.0.0.1:0", 1)
+	sample := collectors.Sample{FqName: "metric", Timestamp: time.Unix(1, 0), ValueType: prometheus.GaugeValue}
+	if err := q.Append([]collectors.Sample{sample}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Append([]collectors.Sample{sample}); err == nil {
+		t.Error("expected appending to a full queue to fail, so that the samples are appended again")
+	}
+}
@@ -14,38 +14,64 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/PuerkitoBio/rehttp"
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
+	versioncollector "github.com/prometheus/client_golang/prometheus/collectors/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/promlog"
 	"github.com/prometheus/common/promlog/flag"
 	"github.com/prometheus/common/version"
 	"github.com/prometheus/exporter-toolkit/web"
 	webflag "github.com/prometheus/exporter-toolkit/web/kingpinflag"
+	"github.com/redis/go-redis/v9"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/monitoring/v3"
 	"google.golang.org/api/option"
 
+	"github.com/prometheus-community/stackdriver_exporter/backfill"
 	"github.com/prometheus-community/stackdriver_exporter/collectors"
+	"github.com/prometheus-community/stackdriver_exporter/config"
 	"github.com/prometheus-community/stackdriver_exporter/delta"
+	"github.com/prometheus-community/stackdriver_exporter/exposition"
+	"github.com/prometheus-community/stackdriver_exporter/inventory"
+	"github.com/prometheus-community/stackdriver_exporter/otlp"
+	"github.com/prometheus-community/stackdriver_exporter/ratelimit"
+	"github.com/prometheus-community/stackdriver_exporter/remotewrite"
 	"github.com/prometheus-community/stackdriver_exporter/utils"
 )
 
+// newMonitoringCollector creates the collectors of the handlers, replaced in tests.
+var newMonitoringCollector = collectors.NewMonitoringCollector
+
 var (
 	// General exporter flags
 
 	toolkitFlags = webflag.AddFlags(kingpin.CommandLine, ":9255")
 
+	configFile = kingpin.Flag(
+		"config.file", "Path to a YAML configuration file. Settings in the file take precedence over their command line flags.",
+	).String()
+
 	metricsPath = kingpin.Flag(
 		"web.telemetry-path", "Path under which to expose Prometheus metrics.",
 	).Default("/metrics").String()
@@ -54,6 +80,26 @@ var (
 		"web.stackdriver-telemetry-path", "Path under which to expose Stackdriver metrics.",
 	).Default("/metrics").String()
 
+	scrapeTimeoutOffset = kingpin.Flag(
+		"web.scrape-timeout-offset", "Offset to subtract from the Prometheus scrape timeout, leaving time to serve the metrics fetched until then.",
+	).Default("500ms").Duration()
+
+	probePath = kingpin.Flag(
+		"web.probe-path", "Path under which to expose the Stackdriver metrics of the project given by the `project` URL param.",
+	).Default("/probe").String()
+
+	probeCollectorTTL = kingpin.Flag(
+		"web.probe-collector-ttl", "How long the collectors of a project which is only probed are kept after its last probe.",
+	).Default("10m").Duration()
+
+	enableOpenMetrics = kingpin.Flag(
+		"web.enable-openmetrics", "Serve the OpenMetrics format to scrapers negotiating it. The _total suffix OpenMetrics requires is appended to the names of counters.",
+	).Default("false").Bool()
+
+	backfillPath = kingpin.Flag(
+		"web.backfill-path", "Path under which to expose the points collected with monitoring.all-points in the OpenMetrics format, POST requests drain them.",
+	).Default("/backfill").String()
+
 	projectID = kingpin.Flag(
 		"google.project-id", "Comma seperated list of Google Project IDs.",
 	).String()
@@ -62,6 +108,10 @@ var (
 		"google.projects.filter", "Google projects search filter.",
 	).String()
 
+	projectsFilterRefreshInterval = kingpin.Flag(
+		"google.projects.filter-refresh-interval", "How often the projects matching the Google projects search filter are re-discovered. 0 disables it.",
+	).Default("0s").Duration()
+
 	stackdriverMaxRetries = kingpin.Flag(
 		"stackdriver.max-retries", "Max number of retries that should be attempted on 503 errors from stackdriver.",
 	).Default("0").Int()
@@ -82,11 +132,23 @@ var (
 		"stackdriver.retry-statuses", "The HTTP statuses that should trigger a retry.",
 	).Default("503").Ints()
 
+	stackdriverMaxInFlightRequests = kingpin.Flag(
+		"stackdriver.max-in-flight-requests", "Max number of concurrent requests to the Stackdriver API across all projects. 0 means unlimited.",
+	).Default("0").Int()
+
+	stackdriverRequestsPerSecond = kingpin.Flag(
+		"stackdriver.requests-per-second", "Max sustained rate of requests to the Stackdriver API across all projects. 0 means unlimited.",
+	).Default("0").Float64()
+
+	stackdriverRequestsBurst = kingpin.Flag(
+		"stackdriver.requests-burst", "Max number of requests to the Stackdriver API sent at once above the sustained rate. Defaults to the rate rounded up.",
+	).Default("0").Int()
+
 	// Monitoring collector flags
 
 	monitoringMetricsTypePrefixes = kingpin.Flag(
 		"monitoring.metrics-type-prefixes", "Comma separated Google Stackdriver Monitoring Metric Type prefixes.",
-	).Required().String()
+	).String()
 
 	monitoringMetricsInterval = kingpin.Flag(
 		"monitoring.metrics-interval", "Interval to request the Google Stackdriver Monitoring Metrics for. Only the most recent data point is used.",
@@ -119,6 +181,138 @@ var (
 		"monitoring.aggregate-deltas-ttl", "How long should a delta metric continue to be exported after GCP stops producing a metric",
 	).Default("30m").Duration()
 
+	monitoringMetricsDeltasSnapshotDir = kingpin.Flag(
+		"monitoring.aggregate-deltas-snapshot-dir", "If set, aggregated DELTA metrics are periodically written to this directory and restored from it on start-up",
+	).Default("").String()
+
+	monitoringMetricsDeltasSnapshotInterval = kingpin.Flag(
+		"monitoring.aggregate-deltas-snapshot-interval", "How often aggregated DELTA metrics are written to monitoring.aggregate-deltas-snapshot-dir",
+	).Default("1m").Duration()
+
+	monitoringMetricsDeltasRedisURL = kingpin.Flag(
+		"monitoring.aggregate-deltas-redis-url", "If set, aggregated DELTA metrics are stored in this Redis, ie redis://localhost:6379/0, so that they are shared by every exporter replica",
+	).Default("").String()
+
+	monitoringMetricsDeltasRedisKeyPrefix = kingpin.Flag(
+		"monitoring.aggregate-deltas-redis-key-prefix", "Prefix of the Redis keys holding aggregated DELTA metrics, replicas sharing the same prefix export the same values",
+	).Default("stackdriver_exporter").String()
+
+	monitoringPollInterval = kingpin.Flag(
+		"monitoring.poll-interval", "If set, metrics are fetched in the background at this interval and scrapes are served from the latest fetched metrics.",
+	).Default("0s").Duration()
+
+	monitoringNativeHistograms = kingpin.Flag(
+		"monitoring.native-histograms", "If enabled, DISTRIBUTION metrics with exponential buckets matching a native histogram schema are also exposed as native histograms.",
+	).Default("false").Bool()
+
+	monitoringStringValuesLimit = kingpin.Flag(
+		"monitoring.string-values-limit", "Maximum number of distinct values of a STRING metric type exported per scrape, 0 means unlimited.",
+	).Default("100").Int()
+
+	monitoringAllPoints = kingpin.Flag(
+		"monitoring.all-points", "If enabled, every point of the requested interval is exposed with its own timestamp at web.backfill-path, in addition to the newest one being scraped.",
+	).Default("false").Bool()
+
+	monitoringUnitLabel = kingpin.Flag(
+		"monitoring.unit-label", "Add the unit of the metric descriptor as `unit` label to every metric.",
+	).Default("true").Bool()
+
+	monitoringBaseUnits = kingpin.Flag(
+		"monitoring.base-units", "Convert values to the base unit of their metric descriptor unit, ie ms to seconds, append it to the metric names and expose it as OpenMetrics UNIT metadata.",
+	).Default("false").Bool()
+
+	monitoringMetricNameTemplate = kingpin.Flag(
+		"monitoring.metric-name-template", "Template of the metric names with the placeholders {resource_type}, {metric_type}, {service}, {metric_path} and {unit}. It must contain {metric_type}, or {service} and {metric_path}. Unless it contains {resource_type}, the resource type is added as resource_type label.",
+	).Default(utils.DefaultMetricNameTemplate).String()
+
+	monitoringLabelCollisions = kingpin.Flag(
+		"monitoring.label-collisions", "How metric and resource labels sharing a key are handled: drop the resource label, suffix it with _resource, or prefix every label with metric_ or resource_.",
+	).Default(collectors.DropLabelCollisions).Enum(collectors.DropLabelCollisions, collectors.SuffixLabelCollisions, collectors.PrefixLabels)
+
+	monitoringMetadataSystemLabels = kingpin.Flag(
+		"monitoring.metadata-system-labels", "Comma separated keys of the metadata system labels added to the series as metadata_system_<key>, * adds all of them.",
+	).String()
+
+	monitoringMetadataUserLabels = kingpin.Flag(
+		"monitoring.metadata-user-labels", "Comma separated keys of the metadata user labels added to the series as metadata_user_<key>, * adds all of them.",
+	).String()
+
+	monitoringAllPointsBufferSize = kingpin.Flag(
+		"monitoring.all-points-buffer-size", "Maximum number of points kept until they are drained from web.backfill-path, 0 means unlimited.",
+	).Default("100000").Int()
+
+	remoteWriteURL = kingpin.Flag(
+		"remote-write.url", "If set, metrics are collected every remote-write.interval and pushed to this Prometheus remote write endpoint. Basic auth credentials can be given in the URL.",
+	).Default("").String()
+
+	remoteWriteInterval = kingpin.Flag(
+		"remote-write.interval", "How often metrics are collected and pushed to remote-write.url.",
+	).Default("1m").Duration()
+
+	remoteWriteTimeout = kingpin.Flag(
+		"remote-write.timeout", "Timeout of each request to remote-write.url.",
+	).Default("30s").Duration()
+
+	remoteWriteQueueCapacity = kingpin.Flag(
+		"remote-write.queue-capacity", "Maximum number of series waiting to be pushed. Collections wait while the queue is full.",
+	).Default("100000").Int()
+
+	remoteWriteMaxSamplesPerSend = kingpin.Flag(
+		"remote-write.max-samples-per-send", "Maximum number of samples pushed in a single request.",
+	).Default("2000").Int()
+
+	remoteWriteMaxRetries = kingpin.Flag(
+		"remote-write.max-retries", "How often a request failing with a server error, a rate limit or a network error is retried before its samples are dropped.",
+	).Default("10").Int()
+
+	otlpEndpoint = kingpin.Flag(
+		"otlp.endpoint", "If set, the collected time series are exported to this OpenTelemetry endpoint, a host:port for grpc or a URL such as http://localhost:4318/v1/metrics for http/protobuf.",
+	).Default("").String()
+
+	otlpProtocol = kingpin.Flag(
+		"otlp.protocol", "Protocol used to export to otlp.endpoint.",
+	).Default("grpc").Enum("grpc", "http/protobuf")
+
+	otlpInsecure = kingpin.Flag(
+		"otlp.insecure", "Disable TLS when exporting to otlp.endpoint with grpc.",
+	).Default("false").Bool()
+
+	otlpHeaders = kingpin.Flag(
+		"otlp.header", "Header sent with every export request, as KEY=VALUE. Repeat for several headers.",
+	).StringMap()
+
+	otlpTimeout = kingpin.Flag(
+		"otlp.timeout", "Timeout of each export request to otlp.endpoint.",
+	).Default("10s").Duration()
+
+	otlpInterval = kingpin.Flag(
+		"otlp.interval", "How often metrics are collected for export, unless they are pushed with remote-write.url.",
+	).Default("1m").Duration()
+
+	otlpQueueCapacity = kingpin.Flag(
+		"otlp.queue-capacity", "Maximum number of time series waiting to be exported. Time series collected while the queue is full are dropped.",
+	).Default("100000").Int()
+
+	otlpMaxRetries = kingpin.Flag(
+		"otlp.max-retries", "How often an export request failing with a retryable error is retried before its data points are dropped.",
+	).Default("10").Int()
+
+	gceInventoryRefreshInterval = kingpin.Flag(
+		"gce-inventory.refresh-interval", "If set, the Compute Engine instances of the served projects are listed at this interval and exposed as stackdriver_gce_instance_info.",
+	).Default("0s").Duration()
+
+	gceInventoryJoinLabels = kingpin.Flag(
+		"gce-inventory.join-labels", "Add the name, machine type, network tags and labels of Compute Engine instances to the series of their gce_instance resource.",
+	).Default("false").Bool()
+
+	gceInventoryDisks = kingpin.Flag(
+		"gce-inventory.disks", "Also list the persistent disks of the served projects and expose them as stackdriver_gce_disk_info.",
+	).Default("false").Bool()
+
+	gceInventoryInstanceGroups = kingpin.Flag(
+		"gce-inventory.instance-groups", "Also list the instance groups of the served projects and add them to the instances, which costs an API call per instance group.",
+	).Default("false").Bool()
+
 	monitoringDescriptorCacheTTL = kingpin.Flag(
 		"monitoring.descriptor-cache-ttl", "How long should the metric descriptors for a prefixed be cached for",
 	).Default("0s").Duration()
@@ -129,7 +323,7 @@ var (
 )
 
 func init() {
-	prometheus.MustRegister(version.NewCollector("stackdriver_exporter"))
+	prometheus.MustRegister(versioncollector.NewCollector("stackdriver_exporter"))
 }
 
 func getDefaultGCPProject(ctx context.Context) (*string, error) {
@@ -149,9 +343,19 @@ func createMonitoringService(ctx context.Context) (*monitoring.Service, error) {
 		return nil, fmt.Errorf("Error creating Google client: %v", err)
 	}
 
+	// Limits apply to every attempt, as retries consume API quota too.
+	limitedTransport := ratelimit.NewTransport(googleClient.Transport, ratelimit.Options{
+		MaxInFlight: *stackdriverMaxInFlightRequests,
+		QPS:         *stackdriverRequestsPerSecond,
+		Burst:       *stackdriverRequestsBurst,
+	})
+	if err := prometheus.Register(limitedTransport); err != nil {
+		return nil, fmt.Errorf("Error registering rate limit metrics: %v", err)
+	}
+
 	googleClient.Timeout = *stackdriverHttpTimeout
 	googleClient.Transport = rehttp.NewTransport(
-		googleClient.Transport, // need to wrap DefaultClient transport
+		limitedTransport, // need to wrap DefaultClient transport
 		rehttp.RetryAll(
 			rehttp.RetryMaxRetries(*stackdriverMaxRetries),
 			rehttp.RetryStatuses(*stackdriverRetryStatuses...)), // Cloud support suggests retrying on 503 errors
@@ -166,15 +370,52 @@ func createMonitoringService(ctx context.Context) (*monitoring.Service, error) {
 	return monitoringService, nil
 }
 
+// createComputeService returns a Compute Engine API client to list the inventory of the served projects.
+func createComputeService(ctx context.Context) (*compute.Service, error) {
+	googleClient, err := google.DefaultClient(ctx, compute.ComputeReadonlyScope)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating Google client: %v", err)
+	}
+	googleClient.Timeout = *stackdriverHttpTimeout
+
+	computeService, err := compute.NewService(ctx, option.WithHTTPClient(googleClient))
+	if err != nil {
+		return nil, fmt.Errorf("Error creating Google Compute Engine service: %v", err)
+	}
+	return computeService, nil
+}
+
 type handler struct {
-	handler http.Handler
-	logger  log.Logger
+	logger log.Logger
 
-	projectIDs          []string
 	metricsPrefixes     []string
 	metricsExtraFilters []collectors.MetricFilter
+	relabelConfigs      []collectors.RelabelConfig
+	cfg                 *config.Config
 	additionalGatherer  prometheus.Gatherer
 	m                   *monitoring.Service
+	stores              *deltaStores
+	sampleSink          collectors.SampleSink
+	timeSeriesSink      collectors.TimeSeriesSink
+	inventory           collectors.Inventory
+
+	// collectors are kept for the lifetime of the handler, so that scrapes filtered with the `collect` URL param
+	// share the caches, delta stores, sample trackers and self metrics of the unfiltered ones. The collector of every prefix of a
+	// served project polls for all of them, into the snapshots shared by the collectors of the project. The
+	// collectors of projects which are only probed do not poll, and are evicted once they were not probed for
+	// probeCollectorTTL.
+	mtx           sync.Mutex
+	projectIDs    []string
+	served        map[string]bool
+	collectors    map[string]*collectors.MonitoringCollector
+	snapshots     map[string]*collectors.Snapshots
+	trackers      map[string]*collectors.SampleTracker
+	stopPolling   map[string]context.CancelFunc
+	probeLastUsed map[string]time.Time
+
+	// ctx is cancelled when the handler is replaced, stopping the background polling of its collectors.
+	ctx  context.Context
+	stop context.CancelFunc
 }
 
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -183,61 +424,395 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	for _, param := range collectParams {
 		filters[param] = true
 	}
+	prefixes := h.filterMetricTypePrefixes(filters)
 
-	if len(filters) > 0 {
-		h.innerHandler(filters).ServeHTTP(w, r)
+	h.mtx.Lock()
+	var projectCollectors []*collectors.MonitoringCollector
+	for _, project := range h.projectIDs {
+		collector, err := h.getCollector(project, prefixes)
+		if err != nil {
+			h.mtx.Unlock()
+			h.serveCollectorError(w, err)
+			return
+		}
+		projectCollectors = append(projectCollectors, collector)
+	}
+	h.mtx.Unlock()
+
+	h.serveCollectors(w, r, projectCollectors, h.additionalGatherer)
+}
+
+// serveProbe serves the metrics of the single project given by the `project` URL param, optionally filtered by the
+// `collect` URL params.
+func (h *handler) serveProbe(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	project := params.Get("project")
+	if project == "" {
+		http.Error(w, "'project' parameter must be specified", http.StatusBadRequest)
+		return
+	}
+	if !utils.IsValidDomainScopedProjectID(project) {
+		http.Error(w, fmt.Sprintf("'project' parameter %q is not a valid project ID", project), http.StatusBadRequest)
+		return
+	}
+
+	filters := make(map[string]bool)
+	for _, param := range params["collect"] {
+		filters[param] = true
+	}
+	prefixes := h.filterMetricTypePrefixes(filters)
+	if len(prefixes) == 0 {
+		http.Error(w, "'collect' parameters do not match any configured metric type prefix", http.StatusBadRequest)
+		return
+	}
+
+	h.mtx.Lock()
+	h.evictProbeCollectors(time.Now())
+	collector, err := h.getCollector(project, prefixes)
+	h.mtx.Unlock()
+	if err != nil {
+		h.serveCollectorError(w, err)
+		return
+	}
+
+	h.serveCollectors(w, r, []*collectors.MonitoringCollector{collector}, nil)
+}
+
+// serveCollectorError fails a scrape whose collectors could not be created.
+func (h *handler) serveCollectorError(w http.ResponseWriter, err error) {
+	level.Error(h.logger).Log("msg", "Error creating collector", "err", err)
+	http.Error(w, "An error has occurred while creating the collectors:\n\n"+err.Error(), http.StatusInternalServerError)
+}
+
+// serveCollectors collects the collectors within the scrape timeout of the request and serves their metrics together
+// with the ones of additionalGatherer, if any.
+func (h *handler) serveCollectors(w http.ResponseWriter, r *http.Request, monitoringCollectors []*collectors.MonitoringCollector, additionalGatherer prometheus.Gatherer) {
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+
+	gatherer := collectorsGatherer(ctx, monitoringCollectors, additionalGatherer)
+	if *enableOpenMetrics {
+		if format := expfmt.NegotiateIncludingOpenMetrics(r.Header); format.FormatType() == expfmt.TypeOpenMetrics {
+			h.serveOpenMetrics(w, gatherer, format, monitoringCollectors)
+			return
+		}
+	}
+
+	// Delegate http serving to Prometheus client library, which will call collector.Collect.
+	promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// serveOpenMetrics serves the metrics of gatherer in the OpenMetrics format together with the `_created` samples of
+// counters and histograms and the UNIT metadata of the metrics converted to base units, which the handler of the
+// Prometheus client library cannot expose.
+func (h *handler) serveOpenMetrics(w http.ResponseWriter, gatherer prometheus.Gatherer, format expfmt.Format, monitoringCollectors []*collectors.MonitoringCollector) {
+	families, err := gatherer.Gather()
+	if err != nil {
+		// Like the Prometheus client library, fail the scrape instead of serving partial metrics
+		level.Error(h.logger).Log("msg", "Error gathering metrics", "err", err)
+		http.Error(w, "An error has occurred while serving metrics:\n\n"+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	h.handler.ServeHTTP(w, r)
+	units := make(map[string]string)
+	for _, c := range monitoringCollectors {
+		for fqName, unit := range c.MetricUnits() {
+			units[fqName] = unit
+		}
+	}
+	w.Header().Set("Content-Type", string(format))
+	if err := exposition.WriteOpenMetrics(w, families, units); err != nil {
+		level.Error(h.logger).Log("msg", "Error writing metrics", "err", err)
+	}
 }
 
-func newHandler(projectIDs []string, metricPrefixes []string, metricExtraFilters []collectors.MetricFilter, m *monitoring.Service, logger log.Logger, additionalGatherer prometheus.Gatherer) *handler {
+// collectorsGatherer returns a gatherer collecting the collectors with ctx together with additionalGatherer, if any.
+func collectorsGatherer(ctx context.Context, monitoringCollectors []*collectors.MonitoringCollector, additionalGatherer prometheus.Gatherer) prometheus.Gatherer {
+	registry := prometheus.NewRegistry()
+	for _, c := range monitoringCollectors {
+		registry.MustRegister(collectors.WithContext(ctx, c))
+	}
+	if additionalGatherer == nil {
+		return registry
+	}
+	return prometheus.Gatherers{
+		additionalGatherer,
+		registry,
+	}
+}
+
+// scrapeContext returns the context of the request with a deadline derived from the Prometheus scrape timeout
+// header, if present, so that API requests stop once Prometheus has given up on the scrape.
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err == nil && seconds > 0 {
+			timeout := time.Duration(seconds * float64(time.Second))
+			if timeout > *scrapeTimeoutOffset {
+				timeout -= *scrapeTimeoutOffset
+			}
+			return context.WithTimeout(r.Context(), timeout)
+		}
+	}
+	return context.WithCancel(r.Context())
+}
+
+// newHandler returns a handler serving the projects, or an error if their collectors cannot be created.
+func newHandler(ctx context.Context, projectIDs []string, cfg *config.Config, m *monitoring.Service, logger log.Logger, additionalGatherer prometheus.Gatherer, stores *deltaStores, sampleSink collectors.SampleSink, timeSeriesSink collectors.TimeSeriesSink, inventory collectors.Inventory) (*handler, error) {
+	ctx, stop := context.WithCancel(ctx)
 	h := &handler{
 		logger:              logger,
-		projectIDs:          projectIDs,
-		metricsPrefixes:     metricPrefixes,
-		metricsExtraFilters: metricExtraFilters,
+		metricsPrefixes:     cfg.MetricsTypePrefixes,
+		metricsExtraFilters: metricExtraFilters(cfg),
+		relabelConfigs:      relabelConfigs(cfg),
+		cfg:                 cfg,
 		additionalGatherer:  additionalGatherer,
 		m:                   m,
+		stores:              stores,
+		sampleSink:          sampleSink,
+		timeSeriesSink:      timeSeriesSink,
+		inventory:           inventory,
+		served:              make(map[string]bool),
+		collectors:          make(map[string]*collectors.MonitoringCollector),
+		snapshots:           make(map[string]*collectors.Snapshots),
+		trackers:            make(map[string]*collectors.SampleTracker),
+		stopPolling:         make(map[string]context.CancelFunc),
+		probeLastUsed:       make(map[string]time.Time),
+		ctx:                 ctx,
+		stop:                stop,
 	}
 
-	h.handler = h.innerHandler(nil)
-	return h
+	if err := h.setProjectIDs(projectIDs); err != nil {
+		stop()
+		return nil, err
+	}
+	return h, nil
 }
 
-func (h *handler) innerHandler(filters map[string]bool) http.Handler {
-	registry := prometheus.NewRegistry()
+// setProjectIDs replaces the served projects. Collectors of projects which are still served are kept, the ones of
+// new projects are created right away so that they start polling. The collectors, delta stores and sample trackers
+// of the projects which are not served anymore are released.
+func (h *handler) setProjectIDs(projectIDs []string) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
 
+	served := make(map[string]bool, len(projectIDs))
+	for _, project := range projectIDs {
+		served[project] = true
+		if !h.served[project] {
+			// Drop the collectors of a project which was only probed so far, as they do not poll
+			h.removeCollectors(project)
+		}
+	}
 	for _, project := range h.projectIDs {
-		monitoringCollector, err := collectors.NewMonitoringCollector(project, h.m, collectors.MonitoringCollectorOptions{
-			MetricTypePrefixes:        h.filterMetricTypePrefixes(filters),
-			ExtraFilters:              h.metricsExtraFilters,
-			RequestInterval:           *monitoringMetricsInterval,
-			RequestOffset:             *monitoringMetricsOffset,
-			IngestDelay:               *monitoringMetricsIngestDelay,
-			FillMissingLabels:         *collectorFillMissingLabels,
-			DropDelegatedProjects:     *monitoringDropDelegatedProjects,
-			AggregateDeltas:           *monitoringMetricsAggregateDeltas,
-			DescriptorCacheTTL:        *monitoringDescriptorCacheTTL,
-			DescriptorCacheOnlyGoogle: *monitoringDescriptorCacheOnlyGoogle,
-		}, h.logger, delta.NewInMemoryCounterStore(h.logger, *monitoringMetricsDeltasTTL), delta.NewInMemoryHistogramStore(h.logger, *monitoringMetricsDeltasTTL))
-		if err != nil {
-			level.Error(h.logger).Log("err", err)
-			os.Exit(1)
+		if served[project] {
+			continue
 		}
-		registry.MustRegister(monitoringCollector)
+		h.removeCollectors(project)
+		h.stores.remove(project)
+		delete(h.trackers, project)
 	}
-	var gatherers prometheus.Gatherer = registry
-	if h.additionalGatherer != nil {
-		gatherers = prometheus.Gatherers{
-			h.additionalGatherer,
-			registry,
+
+	h.projectIDs = projectIDs
+	h.served = served
+	for _, project := range projectIDs {
+		if _, err := h.getCollector(project, h.metricsPrefixes); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// Delegate http serving to Prometheus client library, which will call collector.Collect.
-	return promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})
+// removeCollectors stops and forgets the collectors of the project. It must be called with h.mtx held.
+func (h *handler) removeCollectors(project string) {
+	for key := range h.collectors {
+		if strings.HasPrefix(key, project+"|") {
+			delete(h.collectors, key)
+			delete(h.probeLastUsed, key)
+		}
+	}
+	if stopPolling, ok := h.stopPolling[project]; ok {
+		stopPolling()
+		delete(h.stopPolling, project)
+	}
+	delete(h.snapshots, project)
+}
+
+// evictProbeCollectors forgets the collectors of projects which are only probed and were not probed for
+// probeCollectorTTL, together with the delta stores and sample trackers of those projects. It must be called with
+// h.mtx held.
+func (h *handler) evictProbeCollectors(now time.Time) {
+	evicted := make(map[string]bool)
+	for key, lastUsed := range h.probeLastUsed {
+		if now.Sub(lastUsed) > *probeCollectorTTL {
+			project, _, _ := strings.Cut(key, "|")
+			delete(h.collectors, key)
+			delete(h.probeLastUsed, key)
+			evicted[project] = true
+		}
+	}
+	for project := range evicted {
+		if !h.hasCollectors(project) {
+			h.stores.remove(project)
+			delete(h.trackers, project)
+		}
+	}
+}
+
+// usesProject returns whether the project is served or probed.
+func (h *handler) usesProject(project string) bool {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return h.served[project] || h.hasCollectors(project)
+}
+
+// hasCollectors returns whether the project has any collector. It must be called with h.mtx held.
+func (h *handler) hasCollectors(project string) bool {
+	for key := range h.collectors {
+		if strings.HasPrefix(key, project+"|") {
+			return true
+		}
+	}
+	return false
+}
+
+// servedProjectIDs returns the IDs of the served projects.
+func (h *handler) servedProjectIDs() []string {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return h.projectIDs
+}
+
+// getCollector returns the long-lived collector for the project and metric type prefixes, creating it with the
+// project's shared delta stores and sample tracker if it does not exist yet. The collectors of served projects also share the snapshots
+// of the project. It must be called with h.mtx held.
+func (h *handler) getCollector(project string, prefixes []string) (*collectors.MonitoringCollector, error) {
+	key := collectorKey(project, prefixes)
+	if !h.served[project] {
+		h.probeLastUsed[key] = time.Now()
+	}
+
+	if c, ok := h.collectors[key]; ok {
+		return c, nil
+	}
+	var snapshots *collectors.Snapshots
+	if h.served[project] {
+		snapshots = h.snapshots[project]
+		if snapshots == nil {
+			snapshots = collectors.NewSnapshots()
+			h.snapshots[project] = snapshots
+		}
+	}
+	tracker := h.trackers[project]
+	if tracker == nil {
+		tracker = collectors.NewSampleTracker()
+		h.trackers[project] = tracker
+	}
+	counterStore, histogramStore := h.stores.get(project, time.Duration(h.cfg.AggregateDeltasTTL))
+	monitoringCollector, err := newMonitoringCollector(project, h.m, collectors.MonitoringCollectorOptions{
+		MetricTypePrefixes:        prefixes,
+		ExtraFilters:              h.metricsExtraFilters,
+		RequestInterval:           time.Duration(h.cfg.MetricsInterval),
+		RequestOffset:             time.Duration(h.cfg.MetricsOffset),
+		IngestDelay:               h.cfg.MetricsIngestDelay,
+		FillMissingLabels:         h.cfg.FillMissingLabels,
+		DropDelegatedProjects:     h.cfg.DropDelegatedProjects,
+		AggregateDeltas:           h.cfg.AggregateDeltas,
+		DescriptorCacheTTL:        time.Duration(h.cfg.DescriptorCacheTTL),
+		DescriptorCacheOnlyGoogle: h.cfg.DescriptorCacheOnlyGoogle,
+		PollInterval:              time.Duration(h.cfg.PollInterval),
+		NativeHistograms:          h.cfg.NativeHistograms,
+		StringValuesLimit:         h.cfg.StringValuesLimit,
+		AllPoints:                 h.cfg.AllPoints,
+		UnitLabel:                 h.cfg.UnitLabel,
+		BaseUnits:                 h.cfg.BaseUnits,
+		MetricNameTemplate:        h.cfg.MetricNameTemplate,
+		RelabelConfigs:            h.relabelConfigs,
+		LabelCollisions:           h.cfg.LabelCollisions,
+		MetadataSystemLabels:      h.cfg.MetadataSystemLabels,
+		MetadataUserLabels:        h.cfg.MetadataUserLabels,
+		Inventory:                 h.inventory,
+		InventoryLabels:           *gceInventoryJoinLabels,
+		SampleSink:                h.sampleSink,
+		SampleTracker:             tracker,
+		TimeSeriesSink:            h.timeSeriesSink,
+		Snapshots:                 snapshots,
+		CollectionOverrides:       collectionOverrides(h.cfg),
+	}, h.logger, counterStore, histogramStore)
+	if err != nil {
+		delete(h.probeLastUsed, key)
+		return nil, fmt.Errorf("error creating the collector of project %s: %w", project, err)
+	}
+	h.collectors[key] = monitoringCollector
+
+	if !h.served[project] {
+		return monitoringCollector, nil
+	}
+	// Filtered collectors serve the snapshots polled by the collector of every prefix
+	if key != collectorKey(project, h.metricsPrefixes) {
+		if _, err := h.getCollector(project, h.metricsPrefixes); err != nil {
+			return nil, err
+		}
+	} else if _, ok := h.stopPolling[project]; !ok {
+		pollCtx, stopPolling := context.WithCancel(h.ctx)
+		monitoringCollector.StartPolling(pollCtx)
+		h.stopPolling[project] = stopPolling
+	}
+	return monitoringCollector, nil
+}
+
+// collectorKey identifies the collector of a project and metric type prefixes.
+func collectorKey(project string, prefixes []string) string {
+	return project + "|" + strings.Join(prefixes, ",")
+}
+
+// scrapeErrorsResponse is a failing metric type prefix as served by serveScrapeErrors.
+type scrapeErrorsResponse struct {
+	ProjectID string             `json:"project_id"`
+	Prefix    string             `json:"prefix"`
+	Timestamp time.Time          `json:"timestamp"`
+	Errors    []scrapeErrorEntry `json:"errors"`
+}
+
+type scrapeErrorEntry struct {
+	MetricType string `json:"metric_type,omitempty"`
+	Code       string `json:"code"`
+	Error      string `json:"error"`
+}
+
+// serveScrapeErrors serves the errors of the last collection of every failing metric type prefix as JSON.
+func (h *handler) serveScrapeErrors(w http.ResponseWriter, r *http.Request) {
+	h.mtx.Lock()
+	keys := make([]string, 0, len(h.collectors))
+	for key := range h.collectors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	response := []scrapeErrorsResponse{}
+	for _, key := range keys {
+		for _, prefixErrors := range h.collectors[key].LastScrapeErrors() {
+			entry := scrapeErrorsResponse{
+				ProjectID: prefixErrors.ProjectID,
+				Prefix:    prefixErrors.Prefix,
+				Timestamp: prefixErrors.Timestamp,
+			}
+			for _, err := range prefixErrors.Errors {
+				entry.Errors = append(entry.Errors, scrapeErrorEntry{
+					MetricType: err.MetricType,
+					Code:       err.Code(),
+					Error:      err.Err.Error(),
+				})
+			}
+			response = append(response, entry)
+		}
+	}
+	h.mtx.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		level.Error(h.logger).Log("msg", "Error encoding scrape errors", "err", err)
+	}
 }
 
 // filterMetricTypePrefixes filters the initial list of metric type prefixes, with the ones coming from an individual
@@ -255,6 +830,257 @@ func (h *handler) filterMetricTypePrefixes(filters map[string]bool) []string {
 	return filteredPrefixes
 }
 
+// deltaStores holds the delta stores of every project so that aggregated DELTA metrics survive configuration reloads.
+// When snapshotDir is set the stores are also snapshotted to files, so that they survive restarts. When redisClient is
+// set the stores are kept in Redis instead, so that they are shared by every replica.
+type deltaStores struct {
+	mtx        sync.Mutex
+	logger     log.Logger
+	counters   map[string]collectors.DeltaCounterStore
+	histograms map[string]collectors.DeltaHistogramStore
+
+	redisClient    redis.UniversalClient
+	redisKeyPrefix string
+
+	snapshotDir      string
+	snapshotInterval time.Duration
+	stopSnapshots    map[string]context.CancelFunc
+	ctx              context.Context
+	stop             context.CancelFunc
+	wg               sync.WaitGroup
+}
+
+func newDeltaStores(logger log.Logger, snapshotDir string, snapshotInterval time.Duration) *deltaStores {
+	ctx, stop := context.WithCancel(context.Background())
+	return &deltaStores{
+		logger:           logger,
+		counters:         make(map[string]collectors.DeltaCounterStore),
+		histograms:       make(map[string]collectors.DeltaHistogramStore),
+		snapshotDir:      snapshotDir,
+		snapshotInterval: snapshotInterval,
+		stopSnapshots:    make(map[string]context.CancelFunc),
+		ctx:              ctx,
+		stop:             stop,
+	}
+}
+
+// get returns the stores for the project, creating them with the given TTL if they do not exist yet. The TTL of
+// existing stores is not changed.
+func (s *deltaStores) get(project string, ttl time.Duration) (collectors.DeltaCounterStore, collectors.DeltaHistogramStore) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.counters[project]; !ok {
+		if s.redisClient != nil {
+			keyPrefix := fmt.Sprintf("%s:%s:", s.redisKeyPrefix, project)
+			s.counters[project] = delta.NewRedisCounterStore(s.logger, s.redisClient, keyPrefix, ttl)
+			s.histograms[project] = delta.NewRedisHistogramStore(s.logger, s.redisClient, keyPrefix, ttl)
+		} else if s.snapshotDir != "" {
+			s.counters[project], s.histograms[project] = s.newFileStores(project, ttl)
+		} else {
+			s.counters[project] = delta.NewInMemoryCounterStore(s.logger, ttl)
+			s.histograms[project] = delta.NewInMemoryHistogramStore(s.logger, ttl)
+		}
+	}
+	return s.counters[project], s.histograms[project]
+}
+
+// newFileStores restores the stores of the project from snapshotDir and snapshots them until remove or close is called. When a
+// snapshot cannot be restored the project falls back to an in-memory store, leaving the snapshot untouched. Projects
+// whose ID is not a valid project ID, and could thus escape snapshotDir, are only kept in memory.
+func (s *deltaStores) newFileStores(project string, ttl time.Duration) (collectors.DeltaCounterStore, collectors.DeltaHistogramStore) {
+	if !utils.IsValidProjectID(project) {
+		level.Error(s.logger).Log("msg", "Not a valid project ID, keeping aggregated DELTA metrics in memory only", "project_id", project)
+		return delta.NewInMemoryCounterStore(s.logger, ttl), delta.NewInMemoryHistogramStore(s.logger, ttl)
+	}
+
+	ctx, stop := context.WithCancel(s.ctx)
+	s.stopSnapshots[project] = stop
+
+	var counterStore collectors.DeltaCounterStore
+	fileCounterStore, err := delta.NewFileCounterStore(s.logger, filepath.Join(s.snapshotDir, project+"-counters.gob"), ttl)
+	if err != nil {
+		level.Error(s.logger).Log("msg", "Error restoring aggregated DELTA counters, keeping them in memory only", "project_id", project, "err", err)
+		counterStore = delta.NewInMemoryCounterStore(s.logger, ttl)
+	} else {
+		counterStore = fileCounterStore
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			fileCounterStore.Run(ctx, s.snapshotInterval)
+		}()
+	}
+
+	var histogramStore collectors.DeltaHistogramStore
+	fileHistogramStore, err := delta.NewFileHistogramStore(s.logger, filepath.Join(s.snapshotDir, project+"-histograms.gob"), ttl)
+	if err != nil {
+		level.Error(s.logger).Log("msg", "Error restoring aggregated DELTA histograms, keeping them in memory only", "project_id", project, "err", err)
+		histogramStore = delta.NewInMemoryHistogramStore(s.logger, ttl)
+	} else {
+		histogramStore = fileHistogramStore
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			fileHistogramStore.Run(ctx, s.snapshotInterval)
+		}()
+	}
+
+	return counterStore, histogramStore
+}
+
+// retain removes the stores of the projects which are not used anymore. used is called without s.mtx held, so that
+// it can lock a handler getting stores.
+func (s *deltaStores) retain(used func(project string) bool) {
+	s.mtx.Lock()
+	projects := make([]string, 0, len(s.counters))
+	for project := range s.counters {
+		projects = append(projects, project)
+	}
+	s.mtx.Unlock()
+
+	for _, project := range projects {
+		if !used(project) {
+			s.remove(project)
+		}
+	}
+}
+
+// remove forgets the stores of the project, writing their final snapshots if they are snapshotted to files.
+func (s *deltaStores) remove(project string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if stop, ok := s.stopSnapshots[project]; ok {
+		stop()
+		delete(s.stopSnapshots, project)
+	}
+	delete(s.counters, project)
+	delete(s.histograms, project)
+}
+
+// close writes the final snapshots of the stores.
+func (s *deltaStores) close() {
+	s.stop()
+	s.wg.Wait()
+}
+
+// reloadableHandler serves the most recently loaded handler so that the configuration can be swapped at runtime.
+type reloadableHandler struct {
+	mtx     sync.RWMutex
+	current *handler
+}
+
+func (r *reloadableHandler) get() *handler {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.current
+}
+
+func (r *reloadableHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.get().ServeHTTP(w, req)
+}
+
+// serveProbe serves the probe endpoint of the most recently loaded handler.
+func (r *reloadableHandler) serveProbe(w http.ResponseWriter, req *http.Request) {
+	r.get().serveProbe(w, req)
+}
+
+// serveScrapeErrors serves the scrape errors of the most recently loaded handler.
+func (r *reloadableHandler) serveScrapeErrors(w http.ResponseWriter, req *http.Request) {
+	r.get().serveScrapeErrors(w, req)
+}
+
+// set replaces the served handler, stopping the previous one.
+func (r *reloadableHandler) set(h *handler) {
+	r.mtx.Lock()
+	previous := r.current
+	r.current = h
+	r.mtx.Unlock()
+
+	if previous != nil {
+		previous.stop()
+	}
+}
+
+// configFromFlags builds the exporter configuration from the command line flags.
+func configFromFlags() config.Config {
+	cfg := config.Config{
+		ProjectsFilter:                *projectsFilter,
+		ProjectsFilterRefreshInterval: model.Duration(*projectsFilterRefreshInterval),
+		ExtraFilters:                  parseMetricExtraFilters(),
+		MetricsInterval:               model.Duration(*monitoringMetricsInterval),
+		MetricsOffset:                 model.Duration(*monitoringMetricsOffset),
+		MetricsIngestDelay:            *monitoringMetricsIngestDelay,
+		FillMissingLabels:             *collectorFillMissingLabels,
+		DropDelegatedProjects:         *monitoringDropDelegatedProjects,
+		AggregateDeltas:               *monitoringMetricsAggregateDeltas,
+		AggregateDeltasTTL:            model.Duration(*monitoringMetricsDeltasTTL),
+		DescriptorCacheTTL:            model.Duration(*monitoringDescriptorCacheTTL),
+		DescriptorCacheOnlyGoogle:     *monitoringDescriptorCacheOnlyGoogle,
+		PollInterval:                  model.Duration(*monitoringPollInterval),
+		NativeHistograms:              *monitoringNativeHistograms,
+		StringValuesLimit:             *monitoringStringValuesLimit,
+		AllPoints:                     *monitoringAllPoints,
+		UnitLabel:                     *monitoringUnitLabel,
+		BaseUnits:                     *monitoringBaseUnits,
+		MetricNameTemplate:            *monitoringMetricNameTemplate,
+		LabelCollisions:               *monitoringLabelCollisions,
+	}
+	if *projectID != "" {
+		cfg.ProjectIDs = strings.Split(*projectID, ",")
+	}
+	if *monitoringMetricsTypePrefixes != "" {
+		cfg.MetricsTypePrefixes = strings.Split(*monitoringMetricsTypePrefixes, ",")
+	}
+	if *monitoringMetadataSystemLabels != "" {
+		cfg.MetadataSystemLabels = strings.Split(*monitoringMetadataSystemLabels, ",")
+	}
+	if *monitoringMetadataUserLabels != "" {
+		cfg.MetadataUserLabels = strings.Split(*monitoringMetadataUserLabels, ",")
+	}
+	return cfg
+}
+
+// loadConfig returns the configuration from the command line flags, overridden by the configuration file if any.
+func loadConfig() (*config.Config, error) {
+	cfg := configFromFlags()
+	if *configFile != "" {
+		return config.LoadFile(*configFile, cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// resolveProjectIDs returns the explicitly configured project IDs together with the ones matching the projects filter.
+// When neither is configured the default GCloud project is used.
+func resolveProjectIDs(ctx context.Context, cfg *config.Config, logger log.Logger) ([]string, error) {
+	var projectIDs []string
+
+	if cfg.ProjectsFilter != "" {
+		level.Info(logger).Log("msg", "Using Google Cloud Projects Filter", "projectsFilter", cfg.ProjectsFilter)
+		filtered, err := discoverProjectIDs(ctx, cfg.ProjectsFilter)
+		if err != nil {
+			return nil, err
+		}
+		projectIDs = append(projectIDs, filtered...)
+	}
+
+	projectIDs = append(projectIDs, cfg.ProjectIDs...)
+
+	if cfg.ProjectsFilter == "" && len(cfg.ProjectIDs) == 0 {
+		level.Info(logger).Log("msg", "Neither projectID nor projectsFilter was provided. Trying to discover it")
+		defaultProject, err := getDefaultGCPProject(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("no explicit projectID and error trying to discover default GCloud project: %w", err)
+		}
+		projectIDs = append(projectIDs, *defaultProject)
+	}
+
+	return projectIDs, nil
+}
+
 func main() {
 	promlogConfig := &promlog.Config{}
 	flag.AddFlags(kingpin.CommandLine, promlogConfig)
@@ -266,15 +1092,6 @@ func main() {
 	logger := promlog.New(promlogConfig)
 
 	ctx := context.Background()
-	if *projectID == "" && *projectsFilter == "" {
-		level.Info(logger).Log("msg", "Neither projectID nor projectsFilter was provided. Trying to discover it")
-		var err error
-		projectID, err = getDefaultGCPProject(ctx)
-		if err != nil {
-			level.Error(logger).Log("msg", "no explicit projectID and error trying to discover default GCloud project", "err", err)
-			os.Exit(1)
-		}
-	}
 
 	level.Info(logger).Log("msg", "Starting stackdriver_exporter", "version", version.Info())
 	level.Info(logger).Log("msg", "Build context", "build_context", version.BuildContext())
@@ -285,38 +1102,200 @@ func main() {
 		os.Exit(1)
 	}
 
-	var projectIDs []string
+	var additionalGatherer prometheus.Gatherer
+	if *metricsPath == *stackdriverMetricsPath {
+		additionalGatherer = prometheus.DefaultGatherer
+	}
 
-	if *projectsFilter != "" {
-		level.Info(logger).Log("msg", "Using Google Cloud Projects Filter", "projectsFilter", *projectsFilter)
-		projectIDs, err = utils.GetProjectIDsFromFilter(ctx, *projectsFilter)
+	stores := newDeltaStores(logger, *monitoringMetricsDeltasSnapshotDir, *monitoringMetricsDeltasSnapshotInterval)
+	if *monitoringMetricsDeltasRedisURL != "" {
+		if *monitoringMetricsDeltasSnapshotDir != "" {
+			level.Error(logger).Log("msg", "monitoring.aggregate-deltas-redis-url and monitoring.aggregate-deltas-snapshot-dir are mutually exclusive")
+			os.Exit(1)
+		}
+		redisOptions, err := redis.ParseURL(*monitoringMetricsDeltasRedisURL)
 		if err != nil {
-			level.Error(logger).Log("msg", "failed to get project IDs from filter", "err", err)
+			level.Error(logger).Log("msg", "failed to parse Redis URL", "err", err)
 			os.Exit(1)
 		}
+		stores.redisClient = redis.NewClient(redisOptions)
+		stores.redisKeyPrefix = *monitoringMetricsDeltasRedisKeyPrefix
+		level.Info(logger).Log("msg", "Storing aggregated DELTA metrics in Redis", "addr", redisOptions.Addr, "key_prefix", stores.redisKeyPrefix)
+	}
+	if *monitoringMetricsDeltasSnapshotDir != "" {
+		term := make(chan os.Signal, 1)
+		signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-term
+			level.Info(logger).Log("msg", "Writing aggregated DELTA metrics snapshots before shutting down", "dir", *monitoringMetricsDeltasSnapshotDir)
+			stores.close()
+			os.Exit(0)
+		}()
+	}
+	var sampleSink collectors.SampleSink
+	var backfillBuffer *backfill.Buffer
+	var pushQueue *remotewrite.Queue
+	if *remoteWriteURL != "" {
+		pushQueue = remotewrite.NewQueue(logger, remotewrite.NewClient(*remoteWriteURL, *remoteWriteTimeout), remotewrite.QueueOptions{
+			Capacity:          *remoteWriteQueueCapacity,
+			MaxSamplesPerSend: *remoteWriteMaxSamplesPerSend,
+			MaxRetries:        *remoteWriteMaxRetries,
+			MinBackoff:        remoteWriteMinBackoff,
+			MaxBackoff:        remoteWriteMaxBackoff,
+		})
+		prometheus.MustRegister(pushQueue)
+		// Points collected with monitoring.all-points are pushed instead of being served at web.backfill-path
+		sampleSink = pushQueue
+	} else {
+		backfillBuffer = backfill.NewBuffer(logger, *monitoringAllPointsBufferSize)
+		prometheus.MustRegister(backfillBuffer)
+		sampleSink = backfillBuffer
+	}
+
+	var otlpExporter *otlp.Exporter
+	var timeSeriesSink collectors.TimeSeriesSink
+	if *otlpEndpoint != "" {
+		var otlpClient otlp.Client
+		if *otlpProtocol == "grpc" {
+			otlpClient, err = otlp.NewGRPCClient(*otlpEndpoint, *otlpInsecure, *otlpHeaders, *otlpTimeout)
+			if err != nil {
+				level.Error(logger).Log("msg", "failed to create OTLP client", "err", err)
+				os.Exit(1)
+			}
+		} else {
+			otlpClient = otlp.NewHTTPClient(*otlpEndpoint, *otlpHeaders, *otlpTimeout)
+		}
+		otlpExporter = otlp.NewExporter(logger, otlpClient, otlp.Options{
+			QueueCapacity: *otlpQueueCapacity,
+			MaxRetries:    *otlpMaxRetries,
+			MinBackoff:    otlpMinBackoff,
+			MaxBackoff:    otlpMaxBackoff,
+		})
+		prometheus.MustRegister(otlpExporter)
+		timeSeriesSink = otlpExporter
 	}
 
-	if *projectID != "" {
-		projectIDs = append(projectIDs, strings.Split(*projectID, ",")...)
+	var inv *inventory.Inventory
+	var gceInventory collectors.Inventory
+	if *gceInventoryRefreshInterval > 0 {
+		computeService, err := createComputeService(ctx)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to create compute service", "err", err)
+			os.Exit(1)
+		}
+		inv = inventory.New(logger, inventory.NewComputeLister(computeService), inventory.Options{
+			Disks:          *gceInventoryDisks,
+			InstanceGroups: *gceInventoryInstanceGroups,
+		})
+		prometheus.MustRegister(inv)
+		gceInventory = inv
 	}
 
-	level.Info(logger).Log("msg", "Using Google Cloud Project IDs", "projectIDs", fmt.Sprintf("%v", projectIDs))
+	stackdriverHandler := &reloadableHandler{}
+	var reloadMtx sync.Mutex
+	stopRefresh := func() {}
+	reload := func() error {
+		reloadMtx.Lock()
+		defer reloadMtx.Unlock()
 
-	metricsTypePrefixes := strings.Split(*monitoringMetricsTypePrefixes, ",")
-	metricExtraFilters := parseMetricExtraFilters()
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		projectIDs, err := resolveProjectIDs(ctx, cfg, logger)
+		if err != nil {
+			return err
+		}
+		level.Info(logger).Log("msg", "Using Google Cloud Project IDs", "projectIDs", fmt.Sprintf("%v", projectIDs))
+
+		h, err := newHandler(ctx, projectIDs, cfg, monitoringService, logger, additionalGatherer, stores, sampleSink, timeSeriesSink, gceInventory)
+		if err != nil {
+			return err
+		}
+		stackdriverHandler.set(h)
+		// Delta stores outlive handlers, forget the ones of the projects which are not used anymore
+		stores.retain(h.usesProject)
+
+		stopRefresh()
+		stopRefresh = func() {}
+		if cfg.ProjectsFilter != "" && cfg.ProjectsFilterRefreshInterval > 0 {
+			refreshCtx, cancel := context.WithCancel(ctx)
+			stopRefresh = cancel
+			resolve := func(ctx context.Context) ([]string, error) { return resolveProjectIDs(ctx, cfg, logger) }
+			go refreshProjectIDs(refreshCtx, h, resolve, time.Duration(cfg.ProjectsFilterRefreshInterval), logger)
+		}
+		return nil
+	}
+
+	if err := reload(); err != nil {
+		level.Error(logger).Log("msg", "failed to load configuration", "err", err)
+		os.Exit(1)
+	}
+
+	if *configFile != "" {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := reload(); err != nil {
+					level.Error(logger).Log("msg", "Error reloading config", "err", err)
+					continue
+				}
+				level.Info(logger).Log("msg", "Reloaded config file", "file", *configFile)
+			}
+		}()
+
+		http.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				fmt.Fprintf(w, "This endpoint requires a POST request.\n")
+				return
+			}
+			if err := reload(); err != nil {
+				level.Error(logger).Log("msg", "Error reloading config", "err", err)
+				http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+				return
+			}
+			level.Info(logger).Log("msg", "Reloaded config file", "file", *configFile)
+		})
+	}
+
+	if inv != nil {
+		level.Info(logger).Log("msg", "Listing Compute Engine inventory", "interval", *gceInventoryRefreshInterval)
+		go inv.Run(ctx, *gceInventoryRefreshInterval, func() []string {
+			return stackdriverHandler.get().servedProjectIDs()
+		})
+	}
+
+	if pushQueue != nil {
+		level.Info(logger).Log("msg", "Pushing metrics to remote write endpoint", "interval", *remoteWriteInterval)
+		go pushQueue.Run(ctx)
+		go runPush(ctx, stackdriverHandler, pushQueue, *remoteWriteInterval, logger)
+	}
+
+	if otlpExporter != nil {
+		level.Info(logger).Log("msg", "Exporting metrics to OTLP endpoint", "endpoint", *otlpEndpoint, "protocol", *otlpProtocol)
+		go otlpExporter.Run(ctx)
+		// Pushed collections export their time series too
+		if pushQueue == nil {
+			go runCollections(ctx, stackdriverHandler, *otlpInterval, logger)
+		}
+	}
 
 	if *metricsPath == *stackdriverMetricsPath {
-		handler := newHandler(
-			projectIDs, metricsTypePrefixes, metricExtraFilters, monitoringService, logger, prometheus.DefaultGatherer)
-		http.Handle(*metricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handler))
+		http.Handle(*metricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, stackdriverHandler))
 	} else {
 		level.Info(logger).Log("msg", "Serving Stackdriver metrics at separate path", "path", *stackdriverMetricsPath)
-		handler := newHandler(
-			projectIDs, metricsTypePrefixes, metricExtraFilters, monitoringService, logger, nil)
-		http.Handle(*stackdriverMetricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handler))
+		http.Handle(*stackdriverMetricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, stackdriverHandler))
 		http.Handle(*metricsPath, promhttp.Handler())
 	}
 
+	http.Handle(*probePath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, http.HandlerFunc(stackdriverHandler.serveProbe)))
+	http.HandleFunc("/debug/scrape-errors", stackdriverHandler.serveScrapeErrors)
+	if backfillBuffer != nil {
+		http.Handle(*backfillPath, backfillBuffer)
+	}
+
 	if *metricsPath != "/" && *metricsPath != "" {
 		landingConfig := web.LandingConfig{
 			Name:        "Stackdriver Exporter",
@@ -327,6 +1306,10 @@ func main() {
 					Address: *metricsPath,
 					Text:    "Metrics",
 				},
+				{
+					Address: "/debug/scrape-errors",
+					Text:    "Scrape Errors",
+				},
 			},
 		}
 		if *metricsPath != *stackdriverMetricsPath {
@@ -352,17 +1335,94 @@ func main() {
 	}
 }
 
-func parseMetricExtraFilters() []collectors.MetricFilter {
-	var extraFilters []collectors.MetricFilter
+func parseMetricExtraFilters() []config.ExtraFilter {
+	var extraFilters []config.ExtraFilter
 	for _, ef := range *monitoringMetricsExtraFilter {
 		efPrefix, efModifier := utils.GetExtraFilterModifiers(ef, ":")
 		if efPrefix != "" {
-			extraFilter := collectors.MetricFilter{
-				Prefix:   efPrefix,
-				Modifier: efModifier,
+			extraFilter := config.ExtraFilter{
+				Prefix: efPrefix,
+				Filter: efModifier,
 			}
 			extraFilters = append(extraFilters, extraFilter)
 		}
 	}
 	return extraFilters
 }
+
+func metricExtraFilters(cfg *config.Config) []collectors.MetricFilter {
+	var extraFilters []collectors.MetricFilter
+	for _, ef := range cfg.ExtraFilters {
+		extraFilters = append(extraFilters, collectors.MetricFilter{
+			Prefix:   ef.Prefix,
+			Modifier: ef.Filter,
+		})
+	}
+	return extraFilters
+}
+
+func relabelConfigs(cfg *config.Config) []collectors.RelabelConfig {
+	var configs []collectors.RelabelConfig
+	for _, rc := range cfg.RelabelConfigs {
+		configs = append(configs, collectors.RelabelConfig{
+			Prefix: rc.Prefix,
+			Rules:  rc.Rules,
+		})
+	}
+	return configs
+}
+
+// collectionOverrides resolves the configured overrides against the global collection settings.
+func collectionOverrides(cfg *config.Config) []collectors.CollectionOverride {
+	var overrides []collectors.CollectionOverride
+	for _, o := range cfg.CollectionOverrides {
+		settings := collectors.CollectionSettings{
+			RequestInterval:       time.Duration(cfg.MetricsInterval),
+			RequestOffset:         time.Duration(cfg.MetricsOffset),
+			IngestDelay:           cfg.MetricsIngestDelay,
+			DropDelegatedProjects: cfg.DropDelegatedProjects,
+			AggregateDeltas:       cfg.AggregateDeltas,
+			PollInterval:          time.Duration(cfg.PollInterval),
+			NativeHistograms:      cfg.NativeHistograms,
+			StringValuesLimit:     cfg.StringValuesLimit,
+			AllPoints:             cfg.AllPoints,
+		}
+		if o.MetricsInterval != nil {
+			settings.RequestInterval = time.Duration(*o.MetricsInterval)
+		}
+		if o.MetricsOffset != nil {
+			settings.RequestOffset = time.Duration(*o.MetricsOffset)
+		}
+		if o.MetricsIngestDelay != nil {
+			settings.IngestDelay = *o.MetricsIngestDelay
+		}
+		if o.DropDelegatedProjects != nil {
+			settings.DropDelegatedProjects = *o.DropDelegatedProjects
+		}
+		if o.AggregateDeltas != nil {
+			settings.AggregateDeltas = *o.AggregateDeltas
+		}
+		if o.PollInterval != nil {
+			settings.PollInterval = time.Duration(*o.PollInterval)
+		}
+		if o.NativeHistograms != nil {
+			settings.NativeHistograms = *o.NativeHistograms
+		}
+		if o.StringValuesLimit != nil {
+			settings.StringValuesLimit = *o.StringValuesLimit
+		}
+		if o.AllPoints != nil {
+			settings.AllPoints = *o.AllPoints
+		}
+		if o.Aggregation != nil {
+			settings.Aggregation = &collectors.Aggregation{
+				AlignmentPeriod:    time.Duration(o.Aggregation.AlignmentPeriod),
+				PerSeriesAligner:   o.Aggregation.PerSeriesAligner,
+				CrossSeriesReducer: o.Aggregation.CrossSeriesReducer,
+				GroupByFields:      o.Aggregation.GroupByFields,
+			}
+		}
+		overrides = append(overrides, collectors.CollectionOverride{Match: o.Match, Settings: settings})
+	}
+	return overrides
+}
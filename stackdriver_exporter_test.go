@@ -0,0 +1,329 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/promlog"
+	"golang.org/x/net/context"
+	"google.golang.org/api/monitoring/v3"
+	"google.golang.org/api/option"
+
+	"github.com/prometheus-community/stackdriver_exporter/collectors"
+	"github.com/prometheus-community/stackdriver_exporter/config"
+	"github.com/prometheus-community/stackdriver_exporter/delta"
+)
+
+func TestMain(m *testing.M) {
+	// Apply the flag defaults
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestMonitoringService returns a monitoring.Service of an API without any metric descriptor.
+func newTestMonitoringService(t *testing.T) *monitoring.Service {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+
+	service, err := monitoring.NewService(context.Background(), option.WithEndpoint(server.URL), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+// collectorFactory replaces the collectors created by the handlers for the duration of the test and counts them by
+// project. The projects of failing get an error instead.
+type collectorFactory struct {
+	created map[string]int
+	failing map[string]bool
+}
+
+func newCollectorFactory(t *testing.T) *collectorFactory {
+	f := &collectorFactory{created: make(map[string]int), failing: make(map[string]bool)}
+	t.Cleanup(func() { newMonitoringCollector = collectors.NewMonitoringCollector })
+	newMonitoringCollector = func(project string, m *monitoring.Service, opts collectors.MonitoringCollectorOptions, logger log.Logger, counterStore collectors.DeltaCounterStore, histogramStore collectors.DeltaHistogramStore) (*collectors.MonitoringCollector, error) {
+		if f.failing[project] {
+			return nil, errors.New("failing project")
+		}
+		f.created[project]++
+		return collectors.NewMonitoringCollector(project, m, opts, logger, counterStore, histogramStore)
+	}
+	return f
+}
+
+// testConfig returns the default configuration with the prefixes.
+func testConfig(prefixes ...string) *config.Config {
+	cfg := configFromFlags()
+	cfg.MetricsTypePrefixes = prefixes
+	return &cfg
+}
+
+// newTestHandler returns a handler serving projectIDs with cfg.
+func newTestHandler(t *testing.T, projectIDs []string, cfg *config.Config) (*handler, error) {
+	logger := promlog.New(&promlog.Config{})
+	h, err := newHandler(context.Background(), projectIDs, cfg, newTestMonitoringService(t), logger, nil, newDeltaStores(logger, "", 0), nil, nil, nil)
+	if h != nil {
+		t.Cleanup(h.stop)
+	}
+	return h, err
+}
+
+func serve(handlerFunc http.HandlerFunc, url string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handlerFunc(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	return rec
+}
+
+func TestServeProbeRejectsInvalidProjects(t *testing.T) {
+	factory := newCollectorFactory(t)
+	h, err := newTestHandler(t, nil, testConfig("compute.googleapis.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, url := range []string{"/probe", "/probe?project=../../etc/x", "/probe?project=My_Project", "/probe?project=../..:my-project"} {
+		if rec := serve(h.serveProbe, url); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got %d", url, rec.Code)
+		}
+	}
+	if len(factory.created) != 0 {
+		t.Errorf("expected no collector for invalid projects, got %v", factory.created)
+	}
+}
+
+func TestServeProbeDomainScopedProjects(t *testing.T) {
+	factory := newCollectorFactory(t)
+	h, err := newTestHandler(t, nil, testConfig("compute.googleapis.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := serve(h.serveProbe, "/probe?project=example.com:probed-project"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the probe of a domain-scoped project to succeed, got %d: %s", rec.Code, rec.Body)
+	}
+	if factory.created["example.com:probed-project"] != 1 {
+		t.Errorf("expected a collector for the domain-scoped project, got %v", factory.created)
+	}
+}
+
+func TestServeProbeCollectors(t *testing.T) {
+	factory := newCollectorFactory(t)
+	h, err := newTestHandler(t, []string{"served-project"}, testConfig("compute.googleapis.com", "pubsub.googleapis.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := serve(h.serveProbe, "/probe?project=probed-project&collect=compute.googleapis.com"); rec.Code != http.StatusOK {
+			t.Fatalf("expected the probe to succeed, got %d: %s", rec.Code, rec.Body)
+		}
+	}
+	if factory.created["probed-project"] != 1 {
+		t.Errorf("expected a single collector to be created for repeated probes, got %d", factory.created["probed-project"])
+	}
+	if _, ok := h.stopPolling["probed-project"]; ok {
+		t.Error("expected the collectors of probed projects not to poll")
+	}
+	if _, ok := h.stores.counters["probed-project"]; !ok {
+		t.Error("expected the probed project to have delta stores")
+	}
+
+	h.mtx.Lock()
+	h.evictProbeCollectors(time.Now().Add(*probeCollectorTTL + time.Second))
+	h.mtx.Unlock()
+	if h.hasCollectors("probed-project") {
+		t.Error("expected idle probe collectors to be evicted")
+	}
+	if _, ok := h.stores.counters["probed-project"]; ok {
+		t.Error("expected the delta stores of evicted projects to be removed")
+	}
+	if _, ok := h.trackers["probed-project"]; ok {
+		t.Error("expected the sample tracker of evicted projects to be removed")
+	}
+	if !h.hasCollectors("served-project") {
+		t.Error("expected the collectors of served projects to be kept")
+	}
+}
+
+func TestServedProjectsSharePoller(t *testing.T) {
+	newCollectorFactory(t)
+	cfg := testConfig("compute.googleapis.com", "pubsub.googleapis.com")
+	cfg.PollInterval = model.Duration(time.Hour)
+	h, err := newTestHandler(t, []string{"served-project"}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for url, handlerFunc := range map[string]http.HandlerFunc{
+		"/metrics?collect=compute.googleapis.com":                     h.ServeHTTP,
+		"/probe?project=served-project&collect=pubsub.googleapis.com": h.serveProbe,
+	} {
+		if rec := serve(handlerFunc, url); rec.Code != http.StatusOK {
+			t.Fatalf("expected %s to succeed, got %d: %s", url, rec.Code, rec.Body)
+		}
+	}
+	if len(h.collectors) != 3 {
+		t.Errorf("expected a collector per set of prefixes, got %d", len(h.collectors))
+	}
+	if len(h.stopPolling) != 1 {
+		t.Errorf("expected a single poller for the project, got %d", len(h.stopPolling))
+	}
+	if len(h.trackers) != 1 {
+		t.Errorf("expected a single sample tracker for the project, got %d", len(h.trackers))
+	}
+}
+
+func TestCollectorErrors(t *testing.T) {
+	factory := newCollectorFactory(t)
+	factory.failing["broken-project"] = true
+
+	if _, err := newTestHandler(t, []string{"broken-project"}, testConfig("compute.googleapis.com")); err == nil {
+		t.Error("expected an error creating a handler for a failing project")
+	}
+
+	h, err := newTestHandler(t, nil, testConfig("compute.googleapis.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(h.serveProbe, "/probe?project=broken-project"); rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected probing a failing project to fail the scrape, got %d", rec.Code)
+	}
+}
+
+func TestServeHTTPReusesCollectors(t *testing.T) {
+	factory := newCollectorFactory(t)
+	h, err := newTestHandler(t, []string{"project-a", "project-b"}, testConfig("compute.googleapis.com", "pubsub.googleapis.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, url := range []string{"/metrics", "/metrics", "/metrics?collect=pubsub.googleapis.com", "/metrics?collect=pubsub.googleapis.com"} {
+		if rec := serve(h.ServeHTTP, url); rec.Code != http.StatusOK {
+			t.Fatalf("expected %s to succeed, got %d: %s", url, rec.Code, rec.Body)
+		}
+	}
+	// A collector for every prefix, created with the handler, and one for the filtered prefix
+	for _, project := range []string{"project-a", "project-b"} {
+		if factory.created[project] != 2 {
+			t.Errorf("expected 2 collectors for %s, got %d", project, factory.created[project])
+		}
+	}
+
+	// The collectors of a project share its delta stores
+	counterStore, _ := h.stores.get("project-a", 0)
+	if len(h.stores.counters) != 2 {
+		t.Errorf("expected delta stores per project, got %d", len(h.stores.counters))
+	}
+	if again, _ := h.stores.get("project-a", 0); again != counterStore {
+		t.Error("expected the delta stores of a project to be reused")
+	}
+}
+
+func TestReloadRetainsUsedDeltaStores(t *testing.T) {
+	newCollectorFactory(t)
+	previous, err := newTestHandler(t, []string{"project-a", "project-b"}, testConfig("compute.googleapis.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := previous.stores
+	if rec := serve(previous.serveProbe, "/probe?project=probed-project"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the probe to succeed, got %d: %s", rec.Code, rec.Body)
+	}
+
+	// The reloaded configuration drops project-a
+	logger := promlog.New(&promlog.Config{})
+	h, err := newHandler(context.Background(), []string{"project-b"}, testConfig("compute.googleapis.com"), newTestMonitoringService(t), logger, nil, stores, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.stop()
+	stores.retain(h.usesProject)
+
+	for project, used := range map[string]bool{"project-a": false, "project-b": true, "probed-project": false} {
+		if _, ok := stores.counters[project]; ok != used {
+			t.Errorf("expected the delta stores of %s to be kept: %v, got %v", project, used, ok)
+		}
+	}
+}
+
+func TestFileDeltaStoresRejectInvalidProjects(t *testing.T) {
+	dir := t.TempDir()
+	snapshotDir := filepath.Join(dir, "snapshots")
+	if err := os.Mkdir(snapshotDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	stores := newDeltaStores(promlog.New(&promlog.Config{}), snapshotDir, time.Hour)
+
+	counterStore, histogramStore := stores.get("../escaped", time.Hour)
+	if _, ok := counterStore.(*delta.FileCounterStore); ok {
+		t.Error("expected the counters of an invalid project ID to be kept in memory")
+	}
+	if _, ok := histogramStore.(*delta.FileHistogramStore); ok {
+		t.Error("expected the histograms of an invalid project ID to be kept in memory")
+	}
+	counterStore, _ = stores.get("valid-project", time.Hour)
+	if _, ok := counterStore.(*delta.FileCounterStore); !ok {
+		t.Errorf("expected the counters of a valid project ID to be snapshotted, got %T", counterStore)
+	}
+	stores.close()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected no snapshot outside the snapshot directory, got %v", entries)
+	}
+	if _, err := os.Stat(filepath.Join(snapshotDir, "valid-project-counters.gob")); err != nil {
+		t.Errorf("expected the snapshot of the valid project: %v", err)
+	}
+}
+
+func TestServeOpenMetrics(t *testing.T) {
+	newCollectorFactory(t)
+	h, err := newTestHandler(t, []string{"project-a"}, testConfig("compute.googleapis.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(enabled bool) { *enableOpenMetrics = enabled }(*enableOpenMetrics)
+	*enableOpenMetrics = true
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	h.ServeHTTP(rec, req)
+	if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/openmetrics-text") {
+		t.Errorf("expected the OpenMetrics format without base units, got %s", contentType)
+	}
+	if !strings.HasSuffix(rec.Body.String(), "# EOF\n") {
+		t.Errorf("expected an OpenMetrics exposition, got:\n%s", rec.Body)
+	}
+}
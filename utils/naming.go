@@ -0,0 +1,98 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultMetricNameTemplate names metrics `stackdriver_<resource type>_<metric type>`, ie
+// `stackdriver_gce_instance_compute_googleapis_com_instance_cpu_usage_time`.
+const DefaultMetricNameTemplate = "stackdriver_{resource_type}_{metric_type}"
+
+const (
+	resourceTypePlaceholder = "{resource_type}"
+	metricTypePlaceholder   = "{metric_type}"
+	servicePlaceholder      = "{service}"
+	metricPathPlaceholder   = "{metric_path}"
+	unitPlaceholder         = "{unit}"
+)
+
+var (
+	placeholderRE      = regexp.MustCompile(`\{[^}]*\}`)
+	templateLiteralRE  = regexp.MustCompile(`^[a-zA-Z0-9_:]*$`)
+	repeatedUnderscore = regexp.MustCompile(`__+`)
+)
+
+// MetricNameTemplate builds metric names from a template with the placeholders:
+//   - {resource_type}: the monitored resource type, ie gce_instance
+//   - {metric_type}: the whole metric type, ie compute_googleapis_com_instance_cpu_usage_time
+//   - {service}: the service of the metric type without .googleapis.com, ie compute
+//   - {metric_path}: the metric type without its service, ie instance_cpu_usage_time
+//   - {unit}: the base unit of the metric and any suffix, ie seconds, which are appended to the name otherwise
+type MetricNameTemplate struct {
+	template string
+}
+
+// ParseMetricNameTemplate parses a metric name template. It must contain {metric_type}, or {metric_path} together with
+// {service} as services share metric paths, so that different metric types get different names.
+func ParseMetricNameTemplate(template string) (*MetricNameTemplate, error) {
+	for _, placeholder := range placeholderRE.FindAllString(template, -1) {
+		switch placeholder {
+		case resourceTypePlaceholder, metricTypePlaceholder, servicePlaceholder, metricPathPlaceholder, unitPlaceholder:
+		default:
+			return nil, fmt.Errorf("unknown placeholder %s in metric name template %q", placeholder, template)
+		}
+	}
+	if !strings.Contains(template, metricTypePlaceholder) && !(strings.Contains(template, servicePlaceholder) && strings.Contains(template, metricPathPlaceholder)) {
+		return nil, fmt.Errorf("metric name template %q must contain %s, or %s and %s", template, metricTypePlaceholder, servicePlaceholder, metricPathPlaceholder)
+	}
+	if !templateLiteralRE.MatchString(placeholderRE.ReplaceAllString(template, "")) {
+		return nil, fmt.Errorf("metric name template %q must only contain letters, digits, underscores and colons besides placeholders", template)
+	}
+	return &MetricNameTemplate{template: template}, nil
+}
+
+// HasResourceType returns whether the names built by the template contain the monitored resource type. Otherwise, a
+// single name spans the time series of every resource type of a metric type.
+func (t *MetricNameTemplate) HasResourceType() bool {
+	return strings.Contains(t.template, resourceTypePlaceholder)
+}
+
+// Name returns the metric name of a metric type and monitored resource type. The suffix, ie of an aggregation, and
+// the unit are appended to the name unless the template places them with {unit}. The unit is left out when the name
+// already ends with it, and empty placeholders are dropped.
+func (t *MetricNameTemplate) Name(resourceType, metricType, suffix, unit string) string {
+	service, metricPath, _ := strings.Cut(metricType, "/")
+	replacer := strings.NewReplacer(
+		resourceTypePlaceholder, NormalizeMetricName(resourceType),
+		metricTypePlaceholder, NormalizeMetricName(metricType),
+		servicePlaceholder, NormalizeMetricName(strings.TrimSuffix(service, ".googleapis.com")),
+		metricPathPlaceholder, NormalizeMetricName(metricPath),
+	)
+
+	before, after, _ := strings.Cut(t.template, unitPlaceholder)
+	before = clean(replacer.Replace(before) + "_" + suffix)
+	if unit != "" && !strings.HasSuffix(before, "_"+unit) {
+		before += "_" + unit
+	}
+	return clean(before + "_" + replacer.Replace(strings.ReplaceAll(after, unitPlaceholder, "")))
+}
+
+// clean drops the repeated, leading and trailing underscores left by empty placeholders.
+func clean(name string) string {
+	return strings.Trim(repeatedUnderscore.ReplaceAllString(name, "_"), "_")
+}
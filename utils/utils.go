@@ -23,7 +23,10 @@ import (
 )
 
 var (
-	safeNameRE = regexp.MustCompile(`[^a-zA-Z0-9_]*$`)
+	safeNameRE        = regexp.MustCompile(`[^a-zA-Z0-9_]*$`)
+	invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	projectIDRE       = regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+	domainRE          = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+$`)
 )
 
 func NormalizeMetricName(metricName string) string {
@@ -41,6 +44,12 @@ func NormalizeMetricName(metricName string) string {
 	return strings.Join(normalizedMetricName, "_")
 }
 
+// SanitizeLabelName replaces the characters which are not valid in Prometheus label names, ie the `-` of Google Cloud
+// label keys, with underscores.
+func SanitizeLabelName(name string) string {
+	return invalidLabelChars.ReplaceAllString(name, "_")
+}
+
 func GetExtraFilterModifiers(extraFilter string, separator string) (string, string) {
 	mPrefix := strings.Split(extraFilter, separator)
 	if mPrefix[0] == extraFilter {
@@ -49,6 +58,23 @@ func GetExtraFilterModifiers(extraFilter string, separator string) (string, stri
 	return mPrefix[0], strings.Join(mPrefix[1:], "")
 }
 
+// IsValidProjectID returns whether projectID has the format of a Google Cloud project ID, so that it can safely be
+// used in file names and URLs.
+// @see https://cloud.google.com/resource-manager/docs/creating-managing-projects#before_you_begin
+func IsValidProjectID(projectID string) bool {
+	return projectIDRE.MatchString(projectID)
+}
+
+// IsValidDomainScopedProjectID is like IsValidProjectID but also accepts the project IDs of G Suite domains, which are
+// prefixed with the domain, ie `example.com:my-project`. As they contain a colon, they are not safe to use in file names.
+// @see https://cloud.google.com/resource-manager/docs/creating-managing-projects#before_you_begin
+func IsValidDomainScopedProjectID(projectID string) bool {
+	if domain, id, ok := strings.Cut(projectID, ":"); ok {
+		return domainRE.MatchString(domain) && projectIDRE.MatchString(id)
+	}
+	return projectIDRE.MatchString(projectID)
+}
+
 func ProjectResource(projectID string) string {
 	return "projects/" + projectID
 }
@@ -26,8 +26,73 @@ var _ = Describe("NormalizeMetricName", func() {
 	})
 })
 
+var _ = Describe("SanitizeLabelName", func() {
+	It("replaces invalid characters with underscores", func() {
+		Expect(SanitizeLabelName("cost-center.team")).To(Equal("cost_center_team"))
+	})
+})
+
+var _ = Describe("IsValidProjectID", func() {
+	It("accepts Google Cloud project IDs", func() {
+		Expect(IsValidProjectID("fake-project-1")).To(BeTrue())
+	})
+
+	It("rejects anything else", func() {
+		for _, projectID := range []string{"", "../../etc/x", "Fake-Project", "short", "fake-project-", "1-fake-project", "fake/project"} {
+			Expect(IsValidProjectID(projectID)).To(BeFalse(), projectID)
+		}
+	})
+})
+
+var _ = Describe("IsValidDomainScopedProjectID", func() {
+	It("accepts Google Cloud project IDs with or without a domain", func() {
+		for _, projectID := range []string{"fake-project-1", "example.com:fake-project-1", "sub.example-domain.com:fake-project-1"} {
+			Expect(IsValidDomainScopedProjectID(projectID)).To(BeTrue(), projectID)
+		}
+	})
+
+	It("rejects anything else", func() {
+		for _, projectID := range []string{"", "short", "example.com:", ":fake-project-1", "example:fake-project-1", "../..:fake-project-1", "example.com:fake/project", "a.com:b.com:fake-project-1"} {
+			Expect(IsValidDomainScopedProjectID(projectID)).To(BeFalse(), projectID)
+		}
+	})
+})
+
 var _ = Describe("ProjectResource", func() {
 	It("returns a project resource", func() {
 		Expect(ProjectResource("fake-project-1")).To(Equal("projects/fake-project-1"))
 	})
 })
+
+var _ = Describe("MetricNameTemplate", func() {
+	const metricType = "compute.googleapis.com/instance/cpu/usage_time"
+
+	It("builds the default metric names", func() {
+		template, err := ParseMetricNameTemplate(DefaultMetricNameTemplate)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(template.HasResourceType()).To(BeTrue())
+		Expect(template.Name("gce_instance", metricType, "", "")).To(Equal("stackdriver_gce_instance_compute_googleapis_com_instance_cpu_usage_time"))
+		Expect(template.Name("gce_instance", metricType, "align_rate", "seconds")).To(Equal("stackdriver_gce_instance_compute_googleapis_com_instance_cpu_usage_time_align_rate_seconds"))
+	})
+
+	It("places the unit where the template puts it", func() {
+		template, err := ParseMetricNameTemplate("gcp_{service}_{unit}_{metric_path}")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(template.HasResourceType()).To(BeFalse())
+		Expect(template.Name("gce_instance", metricType, "", "seconds")).To(Equal("gcp_compute_seconds_instance_cpu_usage_time"))
+		Expect(template.Name("gce_instance", metricType, "", "")).To(Equal("gcp_compute_instance_cpu_usage_time"))
+	})
+
+	It("does not append the unit twice", func() {
+		template, err := ParseMetricNameTemplate("{service}_{metric_path}")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(template.Name("gce_instance", "compute.googleapis.com/instance/uptime_seconds", "", "seconds")).To(Equal("compute_instance_uptime_seconds"))
+	})
+
+	It("rejects invalid templates", func() {
+		for _, template := range []string{"gcp_{metric}", "gcp_{service}", "gcp-{metric_type}", "{metric_path}", "gcp_{resource_type}_{metric_path}"} {
+			_, err := ParseMetricNameTemplate(template)
+			Expect(err).To(HaveOccurred(), template)
+		}
+	})
+})